@@ -0,0 +1,219 @@
+package docker
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// answerTable holds the DNS answers for a snapshot, built once when the
+// snapshot is published instead of on every query. ServeDNS copies the
+// RRs it hands out and patches their owner name and TTL, so the table
+// itself is never modified after it is built.
+type answerTable struct {
+	names map[string]*nameAnswers // owner FQDN -> answers
+	ptrs  map[string][]dns.RR     // reverse-arpa FQDN -> PTR RRset
+	soas  map[string]dns.RR       // zone -> SOA for apex queries and negative answers
+	nss   map[string][]dns.RR     // zone -> NS RRset for apex queries
+	// versions holds each zone's serial and IXFR journal.
+	versions map[string]*zoneVersion
+}
+
+// nameAnswers holds the RRsets for one owner name.
+type nameAnswers struct {
+	// cname answers every query type when set (RFC 1034: a CNAME owner
+	// has no other records).
+	cname dns.RR
+	// rrsets holds the A, AAAA, SRV and TXT RRsets, keyed by type.
+	rrsets map[uint16][]dns.RR
+}
+
+// count returns the number of RRs of the given type. It is safe to call
+// on a nil nameAnswers.
+func (n *nameAnswers) count(qtype uint16) int {
+	if n == nil {
+		return 0
+	}
+	return len(n.rrsets[qtype])
+}
+
+// answers returns the RRs that answer qtype: the CNAME if there is one,
+// otherwise the RRset of that type.
+func (n *nameAnswers) answers(qtype uint16) []dns.RR {
+	if n.cname != nil {
+		return []dns.RR{n.cname}
+	}
+	return n.rrsets[qtype]
+}
+
+// lookup returns the answers for an owner name. It is safe to call on a
+// nil answerTable.
+func (a *answerTable) lookup(name string) (*nameAnswers, bool) {
+	if a == nil {
+		return nil, false
+	}
+	n, ok := a.names[name]
+	return n, ok
+}
+
+// ptr returns the PTR RRset for a reverse-arpa name. It is safe to call
+// on a nil answerTable.
+func (a *answerTable) ptr(name string) ([]dns.RR, bool) {
+	if a == nil {
+		return nil, false
+	}
+	rrs, ok := a.ptrs[name]
+	return rrs, ok
+}
+
+// buildAnswers precomputes the answers for a merged record set and the
+// records added by dynamic updates. RRs carry their lowercase owner name
+// and the configured TTL; ServeDNS replaces both per query. Each zone's
+// serial is carried over from the current snapshot's answers unless the
+// zone's records changed, so callers must publish the result before
+// building the next table; they do so under mu.
+func (d *Docker) buildAnswers(rs *recordSet) *answerTable {
+	a := &answerTable{
+		names:    make(map[string]*nameAnswers),
+		ptrs:     make(map[string][]dns.RR, len(rs.ptrs)),
+		soas:     make(map[string]dns.RR, len(d.zones)),
+		nss:      make(map[string][]dns.RR, len(d.zones)),
+		versions: make(map[string]*zoneVersion, len(d.zones)),
+	}
+	name := func(fqdn string) *nameAnswers {
+		n, ok := a.names[fqdn]
+		if !ok {
+			n = &nameAnswers{rrsets: make(map[uint16][]dns.RR)}
+			a.names[fqdn] = n
+		}
+		return n
+	}
+	header := func(fqdn string, rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: fqdn, Rrtype: rrtype, Class: dns.ClassINET, Ttl: d.ttl}
+	}
+
+	for fqdn, ips := range rs.records {
+		n := name(fqdn)
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				n.rrsets[dns.TypeA] = append(n.rrsets[dns.TypeA], &dns.A{Hdr: header(fqdn, dns.TypeA), A: ip})
+			} else {
+				n.rrsets[dns.TypeAAAA] = append(n.rrsets[dns.TypeAAAA], &dns.AAAA{Hdr: header(fqdn, dns.TypeAAAA), AAAA: ip})
+			}
+		}
+	}
+	for fqdn, srvs := range rs.srvs {
+		n := name(fqdn)
+		for _, srv := range srvs {
+			n.rrsets[dns.TypeSRV] = append(n.rrsets[dns.TypeSRV], &dns.SRV{
+				Hdr:      header(fqdn, dns.TypeSRV),
+				Priority: 10,
+				Weight:   10,
+				Port:     srv.port,
+				Target:   srv.target,
+			})
+		}
+	}
+	for fqdn, txts := range rs.txts {
+		n := name(fqdn)
+		for _, strs := range txts {
+			n.rrsets[dns.TypeTXT] = append(n.rrsets[dns.TypeTXT], &dns.TXT{Hdr: header(fqdn, dns.TypeTXT), Txt: strs})
+		}
+	}
+	for fqdn, target := range rs.cnames {
+		name(fqdn).cname = &dns.CNAME{Hdr: header(fqdn, dns.TypeCNAME), Target: target}
+	}
+	for arpa, fqdns := range rs.ptrs {
+		rrs := make([]dns.RR, 0, len(fqdns))
+		for _, fqdn := range fqdns {
+			rrs = append(rrs, &dns.PTR{Hdr: header(arpa, dns.TypePTR), Ptr: fqdn})
+		}
+		a.ptrs[arpa] = rrs
+	}
+	d.overlay.addTo(a, name, time.Now())
+	d.addGlue(a, name)
+
+	prev := d.loadSnapshot().answers
+	for _, zone := range d.zones {
+		a.nss[zone] = d.ns(zone)
+		v := d.version(prev, a, zone)
+		a.versions[zone] = v
+		a.soas[zone] = d.soa(zone, v.serial)
+	}
+	return a
+}
+
+// apexSOA returns a copy of the zone's SOA from the snapshot, or a fresh
+// one if the snapshot has none (before the first sync). Until then there
+// are no records to version, so the fresh SOA carries serial 0.
+func (d *Docker) apexSOA(snap *snapshot, zone string) dns.RR {
+	if snap.answers != nil {
+		if soa, ok := snap.answers.soas[zone]; ok {
+			return copyRR(soa)
+		}
+	}
+	return d.soa(zone, 0)
+}
+
+// apexNS returns copies of the zone's NS records from the snapshot, or
+// fresh ones if the snapshot has none (before the first sync).
+func (d *Docker) apexNS(snap *snapshot, zone string) []dns.RR {
+	if snap.answers != nil {
+		if nss, ok := snap.answers.nss[zone]; ok {
+			rrs := make([]dns.RR, len(nss))
+			for i, ns := range nss {
+				rrs[i] = copyRR(ns)
+			}
+			return rrs
+		}
+	}
+	return d.ns(zone)
+}
+
+// patchedRRs returns copies of rrs with the owner name replaced and the
+// TTL lowered to ttl. Records from dynamic updates may carry a lower TTL
+// of their own.
+func patchedRRs(rrs []dns.RR, name string, ttl uint32) []dns.RR {
+	out := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		c := copyRR(rr)
+		c.Header().Name = name
+		c.Header().Ttl = min(rr.Header().Ttl, ttl)
+		out[i] = c
+	}
+	return out
+}
+
+// copyRR returns a shallow copy of a precomputed RR, so callers further
+// down the plugin chain can rewrite its header without touching the
+// snapshot. RDATA slices (addresses, TXT strings) stay shared; nothing
+// in CoreDNS modifies those in place.
+func copyRR(rr dns.RR) dns.RR {
+	switch rr := rr.(type) {
+	case *dns.A:
+		c := *rr
+		return &c
+	case *dns.AAAA:
+		c := *rr
+		return &c
+	case *dns.SRV:
+		c := *rr
+		return &c
+	case *dns.TXT:
+		c := *rr
+		return &c
+	case *dns.CNAME:
+		c := *rr
+		return &c
+	case *dns.PTR:
+		c := *rr
+		return &c
+	case *dns.SOA:
+		c := *rr
+		return &c
+	case *dns.NS:
+		c := *rr
+		return &c
+	}
+	return dns.Copy(rr)
+}
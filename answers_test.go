@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestBuildAnswers(t *testing.T) {
+	d := &Docker{ttl: DefaultTTL, zones: []string{"docker.", "internal."}}
+	rs := &recordSet{
+		records: map[string][]net.IP{
+			"web.docker.":   {net.ParseIP("172.17.0.2"), net.ParseIP("2001:db8::2")},
+			"alias.docker.": {net.ParseIP("172.17.0.3")},
+		},
+		srvs: map[string][]srvRecord{
+			"_http._tcp.web.docker.": {{target: "web.docker.", port: 80}},
+		},
+		ptrs: map[string][]string{
+			"2.0.17.172.in-addr.arpa.": {"web.docker."},
+		},
+		cnames: map[string]string{
+			"alias.docker.": "external.example.com.",
+		},
+		txts: map[string][][]string{
+			"web.docker.": {{"v=spf1 -all"}},
+		},
+	}
+	a := d.buildAnswers(rs)
+
+	web, ok := a.lookup("web.docker.")
+	if !ok {
+		t.Fatal("expected answers for web.docker.")
+	}
+	for qtype, want := range map[uint16]int{dns.TypeA: 1, dns.TypeAAAA: 1, dns.TypeTXT: 1, dns.TypeSRV: 0, dns.TypeMX: 0} {
+		if got := len(web.answers(qtype)); got != want {
+			t.Errorf("expected %d %s answers for web.docker., got %d", want, dns.TypeToString[qtype], got)
+		}
+	}
+	if rr := web.answers(dns.TypeA)[0]; rr.Header().Ttl != DefaultTTL || rr.Header().Name != "web.docker." {
+		t.Errorf("expected precomputed A to carry the owner name and configured TTL, got %s", rr)
+	}
+
+	alias, _ := a.lookup("alias.docker.")
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeTXT, dns.TypeMX} {
+		answers := alias.answers(qtype)
+		if len(answers) != 1 || answers[0].Header().Rrtype != dns.TypeCNAME {
+			t.Errorf("expected the CNAME to answer %s queries for alias.docker., got %v", dns.TypeToString[qtype], answers)
+		}
+	}
+
+	if _, ok := a.lookup("_http._tcp.web.docker."); !ok {
+		t.Errorf("expected answers for the SRV owner name")
+	}
+	if rrs, ok := a.ptr("2.0.17.172.in-addr.arpa."); !ok || len(rrs) != 1 {
+		t.Errorf("expected one PTR answer, got %v", rrs)
+	}
+	for _, zone := range d.zones {
+		if _, ok := a.soas[zone]; !ok {
+			t.Errorf("expected a precomputed SOA for %s", zone)
+		}
+		if _, ok := a.nss[zone]; !ok {
+			t.Errorf("expected a precomputed NS for %s", zone)
+		}
+	}
+}
+
+func TestNilAnswerTable(t *testing.T) {
+	var a *answerTable
+	if _, ok := a.lookup("web.docker."); ok {
+		t.Errorf("expected a nil table to find no names")
+	}
+	if _, ok := a.ptr("2.0.17.172.in-addr.arpa."); ok {
+		t.Errorf("expected a nil table to find no PTRs")
+	}
+	var n *nameAnswers
+	if n.count(dns.TypeA) != 0 {
+		t.Errorf("expected a nil nameAnswers to count zero")
+	}
+}
+
+// TestServeDNSLeavesSnapshotUntouched checks that per-query patching of
+// the owner name and stale TTL, and whatever later plugins do to the
+// response, never leaks back into the precomputed answers.
+func TestServeDNSLeavesSnapshotUntouched(t *testing.T) {
+	d := &Docker{
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
+		recordSet: recordSet{
+			records: map[string][]net.IP{"web.docker.": {net.ParseIP("172.17.0.2")}},
+			ptrs:    map[string][]string{"2.0.17.172.in-addr.arpa.": {"web.docker."}},
+		},
+	})
+
+	queries := []struct {
+		qname string
+		qtype uint16
+	}{
+		{"WeB.DoCkEr.", dns.TypeA},
+		{"2.0.17.172.IN-ADDR.ARPA.", dns.TypePTR},
+		{"missing.docker.", dns.TypeA},
+		{"docker.", dns.TypeSOA},
+	}
+	for _, q := range queries {
+		m := new(dns.Msg)
+		m.SetQuestion(q.qname, q.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := d.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("%s %s: %v", q.qname, dns.TypeToString[q.qtype], err)
+		}
+		for _, rr := range append(rec.Msg.Answer, rec.Msg.Ns...) {
+			if rr.Header().Rrtype != dns.TypeSOA && rr.Header().Name != q.qname {
+				t.Errorf("expected answer owner %s to match the query casing %s", rr.Header().Name, q.qname)
+			}
+			if rr.Header().Rrtype != dns.TypeSOA && rr.Header().Ttl != 5 {
+				t.Errorf("expected stale TTL 5 on %s, got %d", rr.Header().Name, rr.Header().Ttl)
+			}
+			rr.Header().Name = "mutated."
+			rr.Header().Ttl = 1
+		}
+	}
+
+	a := d.loadSnapshot().answers
+	web, _ := a.lookup("web.docker.")
+	if h := web.answers(dns.TypeA)[0].Header(); h.Name != "web.docker." || h.Ttl != DefaultTTL {
+		t.Errorf("expected precomputed A to be untouched, got name=%s ttl=%d", h.Name, h.Ttl)
+	}
+	ptrs, _ := a.ptr("2.0.17.172.in-addr.arpa.")
+	if h := ptrs[0].Header(); h.Name != "2.0.17.172.in-addr.arpa." || h.Ttl != DefaultTTL {
+		t.Errorf("expected precomputed PTR to be untouched, got name=%s ttl=%d", h.Name, h.Ttl)
+	}
+	if h := a.soas["docker."].Header(); h.Name != "docker." || h.Ttl != DefaultTTL {
+		t.Errorf("expected precomputed SOA to be untouched, got name=%s ttl=%d", h.Name, h.Ttl)
+	}
+}
+
+func TestApexSOABeforeFirstSync(t *testing.T) {
+	d := &Docker{
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("missing.docker.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := d.ServeDNS(context.Background(), rec, m); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Msg.Rcode != dns.RcodeNameError {
+		t.Errorf("expected NXDOMAIN before the first sync, got %s", dns.RcodeToString[rec.Msg.Rcode])
+	}
+	if len(rec.Msg.Ns) != 1 || rec.Msg.Ns[0].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("expected an SOA in the authority section, got %v", rec.Msg.Ns)
+	}
+}
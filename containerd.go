@@ -0,0 +1,428 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	apievents "github.com/containerd/containerd/api/events"
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	introspectionapi "github.com/containerd/containerd/api/services/introspection/v1"
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
+	versionapi "github.com/containerd/containerd/api/services/version/v1"
+	apitypes "github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/api/types/task"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/go-connections/nat"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	// runtimeDocker and runtimeContainerd are the values of the runtime
+	// option.
+	runtimeDocker     = "docker"
+	runtimeContainerd = "containerd"
+
+	// defaultContainerdSocket is where containerd listens by default.
+	defaultContainerdSocket = "unix:///run/containerd/containerd.sock"
+	// defaultContainerdNamespace is the namespace nerdctl uses unless
+	// told otherwise.
+	defaultContainerdNamespace = "default"
+	// defaultCNIResultsDir is where the CNI library caches the result of
+	// every network attachment, including the addresses it assigned.
+	defaultCNIResultsDir = "/var/lib/cni/results"
+
+	// containerdNamespaceHeader is the gRPC metadata key containerd reads
+	// the namespace of a request from.
+	containerdNamespaceHeader = "containerd-namespace"
+)
+
+// Labels nerdctl stores on the containers it creates.
+const (
+	nerdctlNameLabel     = "nerdctl/name"
+	nerdctlHostnameLabel = "nerdctl/hostname"
+	nerdctlNetworksLabel = "nerdctl/networks"
+	nerdctlPortsLabel    = "nerdctl/ports"
+)
+
+// containerdClient adapts containerd's gRPC API to daemonClient, so the
+// rest of the plugin sees containerd containers, in the shape the Docker
+// API would report them. Names, networks, ports and hostnames come from
+// the labels nerdctl sets; addresses come from the CNI result cache, since
+// containerd itself knows nothing about networking.
+type containerdClient struct {
+	conn          *grpc.ClientConn
+	namespace     string
+	cniResultsDir string
+
+	containers    containersapi.ContainersClient
+	tasks         tasksapi.TasksClient
+	events        eventsapi.EventsClient
+	version       versionapi.VersionClient
+	introspection introspectionapi.IntrospectionClient
+}
+
+// newContainerdClient returns a client for the containerd socket at
+// address, a unix:// URL, scoped to namespace. Like the Docker client,
+// it connects on first use.
+func newContainerdClient(address, namespace, cniResultsDir string) (*containerdClient, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &containerdClient{
+		conn:          conn,
+		namespace:     namespace,
+		cniResultsDir: cniResultsDir,
+		containers:    containersapi.NewContainersClient(conn),
+		tasks:         tasksapi.NewTasksClient(conn),
+		events:        eventsapi.NewEventsClient(conn),
+		version:       versionapi.NewVersionClient(conn),
+		introspection: introspectionapi.NewIntrospectionClient(conn),
+	}, nil
+}
+
+// withNamespace scopes a request to the client's namespace.
+func (c *containerdClient) withNamespace(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, containerdNamespaceHeader, c.namespace)
+}
+
+// Close closes the gRPC connection.
+func (c *containerdClient) Close() error {
+	return c.conn.Close()
+}
+
+// Ping implements daemonProber.
+func (c *containerdClient) Ping(ctx context.Context) (types.Ping, error) {
+	if _, err := c.version.Version(ctx, &emptypb.Empty{}); err != nil {
+		return types.Ping{}, errgrpc.ToNative(err)
+	}
+	return types.Ping{}, nil
+}
+
+// Info implements daemonProber. The heartbeat only needs the ID, which
+// containerd generates once per state directory.
+func (c *containerdClient) Info(ctx context.Context) (system.Info, error) {
+	resp, err := c.introspection.Server(ctx, &emptypb.Empty{})
+	if err != nil {
+		return system.Info{}, errgrpc.ToNative(err)
+	}
+	return system.Info{ID: resp.UUID}, nil
+}
+
+// ServerVersion reports containerd's version.
+func (c *containerdClient) ServerVersion(ctx context.Context) (types.Version, error) {
+	resp, err := c.version.Version(ctx, &emptypb.Empty{})
+	if err != nil {
+		return types.Version{}, errgrpc.ToNative(err)
+	}
+	v := types.Version{
+		Version:    resp.Version,
+		Components: []types.ComponentVersion{{Name: "containerd", Version: resp.Version}},
+	}
+	v.Platform.Name = "containerd"
+	return v, nil
+}
+
+// ContainerList lists the containers with a running or paused task, the
+// containers docker ps would show.
+func (c *containerdClient) ContainerList(ctx context.Context, _ container.ListOptions) ([]container.Summary, error) {
+	ctx = c.withNamespace(ctx)
+	tasks, err := c.tasks.List(ctx, &tasksapi.ListTasksRequest{})
+	if err != nil {
+		return nil, errgrpc.ToNative(err)
+	}
+	states := make(map[string]task.Status, len(tasks.Tasks))
+	for _, t := range tasks.Tasks {
+		if t.Status == task.Status_RUNNING || t.Status == task.Status_PAUSED {
+			states[t.ID] = t.Status
+		}
+	}
+	if len(states) == 0 {
+		return nil, nil
+	}
+
+	resp, err := c.containers.List(ctx, &containersapi.ListContainersRequest{})
+	if err != nil {
+		return nil, errgrpc.ToNative(err)
+	}
+	var summaries []container.Summary
+	for _, ctr := range resp.Containers {
+		status, ok := states[ctr.ID]
+		if !ok {
+			continue
+		}
+		inspect := nerdctlInspect(c.namespace, ctr.ID, ctr.Labels, status, "")
+		s := container.Summary{
+			ID:     ctr.ID,
+			Names:  []string{inspect.Name},
+			Labels: ctr.Labels,
+			State:  inspect.State.Status,
+		}
+		s.HostConfig.NetworkMode = string(inspect.HostConfig.NetworkMode)
+		summaries = append(summaries, s)
+	}
+	return summaries, nil
+}
+
+// ContainerInspect implements ContainerInspector. A container without a
+// task is reported as not running.
+func (c *containerdClient) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	ctx = c.withNamespace(ctx)
+	resp, err := c.containers.Get(ctx, &containersapi.GetContainerRequest{ID: containerID})
+	if err != nil {
+		return container.InspectResponse{}, errgrpc.ToNative(err)
+	}
+	status := task.Status_STOPPED
+	t, err := c.tasks.Get(ctx, &tasksapi.GetRequest{ContainerID: containerID})
+	if err == nil {
+		status = t.Process.Status
+	} else if err = errgrpc.ToNative(err); !cerrdefs.IsNotFound(err) {
+		return container.InspectResponse{}, err
+	}
+	return nerdctlInspect(c.namespace, containerID, resp.Container.Labels, status, c.cniResultsDir), nil
+}
+
+// Events subscribes to containerd's task and container events and
+// translates them into Docker events. The filter is ignored: every event
+// that can change a container's records is delivered.
+func (c *containerdClient) Events(ctx context.Context, _ events.ListOptions) (<-chan events.Message, <-chan error) {
+	msgs := make(chan events.Message)
+	errs := make(chan error, 1)
+	go func() {
+		stream, err := c.events.Subscribe(c.withNamespace(ctx), &eventsapi.SubscribeRequest{
+			Filters: []string{
+				`namespace==` + c.namespace + `,topic~="^/tasks/"`,
+				`namespace==` + c.namespace + `,topic~="^/containers/"`,
+			},
+		})
+		if err != nil {
+			errs <- errgrpc.ToNative(err)
+			return
+		}
+		for {
+			envelope, err := stream.Recv()
+			if err != nil {
+				if ctx.Err() != nil {
+					errs <- ctx.Err()
+				} else {
+					errs <- errgrpc.ToNative(err)
+				}
+				return
+			}
+			msg, ok := containerdEvent(envelope)
+			if !ok {
+				continue
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return msgs, errs
+}
+
+// containerdEvent translates a containerd event into the Docker event the
+// sync loop handles. It returns false for events that do not change a
+// container's records, such as exec processes exiting.
+func containerdEvent(envelope *apitypes.Envelope) (events.Message, bool) {
+	if envelope.Event == nil {
+		return events.Message{}, false
+	}
+	event, err := envelope.Event.UnmarshalNew()
+	if err != nil {
+		log.Debugf("Ignoring undecodable containerd event %s: %v", envelope.Topic, err)
+		return events.Message{}, false
+	}
+	var id string
+	var action events.Action
+	switch e := event.(type) {
+	case *apievents.TaskStart:
+		id, action = e.ContainerID, events.ActionStart
+	case *apievents.TaskExit:
+		if e.ID != e.ContainerID {
+			return events.Message{}, false
+		}
+		id, action = e.ContainerID, events.ActionDie
+	case *apievents.TaskDelete:
+		if e.ID != "" && e.ID != e.ContainerID {
+			return events.Message{}, false
+		}
+		id, action = e.ContainerID, events.ActionDie
+	case *apievents.TaskPaused:
+		id, action = e.ContainerID, events.ActionPause
+	case *apievents.TaskResumed:
+		id, action = e.ContainerID, events.ActionUnPause
+	case *apievents.ContainerUpdate:
+		// nerdctl rename and label changes update the container.
+		id, action = e.ID, events.ActionUpdate
+	case *apievents.ContainerDelete:
+		id, action = e.ID, events.ActionDestroy
+	default:
+		return events.Message{}, false
+	}
+	msg := events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor:  events.Actor{ID: id},
+	}
+	if envelope.Timestamp != nil {
+		t := envelope.Timestamp.AsTime()
+		msg.Time, msg.TimeNano = t.Unix(), t.UnixNano()
+	}
+	return msg, true
+}
+
+// nerdctlInspect builds the Docker inspect response for containerd
+// container id in namespace from the labels nerdctl sets on it. Network
+// addresses are read from the CNI result cache in cniResultsDir; with an
+// empty cniResultsDir the networks are listed without addresses.
+func nerdctlInspect(namespace, id string, labels map[string]string, status task.Status, cniResultsDir string) container.InspectResponse {
+	name := labels[nerdctlNameLabel]
+	if name == "" {
+		name = shortID(id)
+	}
+
+	state := &container.State{Status: container.StateExited}
+	switch status {
+	case task.Status_RUNNING:
+		state.Status, state.Running = container.StateRunning, true
+	case task.Status_PAUSED, task.Status_PAUSING:
+		state.Status, state.Running, state.Paused = container.StatePaused, true, true
+	case task.Status_CREATED:
+		state.Status = container.StateCreated
+	}
+
+	var networks []string
+	if raw := labels[nerdctlNetworksLabel]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &networks); err != nil {
+			log.Debugf("Container %s has invalid %s label: %v", id, nerdctlNetworksLabel, err)
+		}
+	}
+	networkMode := ""
+	if len(networks) > 0 {
+		networkMode = networks[0]
+	}
+
+	settings := &container.NetworkSettings{Networks: make(map[string]*network.EndpointSettings, len(networks))}
+	settings.Ports = nerdctlPorts(id, labels[nerdctlPortsLabel])
+	for _, n := range networks {
+		if n == "host" || n == "none" || strings.HasPrefix(n, "container:") {
+			continue
+		}
+		endpoint := &network.EndpointSettings{}
+		if cniResultsDir != "" {
+			cniAddresses(cniResultsDir, n, namespace, id, endpoint)
+		}
+		settings.Networks[n] = endpoint
+	}
+
+	config := &container.Config{Hostname: labels[nerdctlHostnameLabel], Labels: labels}
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:         id,
+			Name:       "/" + name,
+			State:      state,
+			HostConfig: &container.HostConfig{NetworkMode: container.NetworkMode(networkMode)},
+		},
+		Config:          config,
+		NetworkSettings: settings,
+	}
+}
+
+// nerdctlPorts converts the nerdctl/ports label, a JSON list of CNI port
+// mappings, to Docker port bindings.
+func nerdctlPorts(id, raw string) nat.PortMap {
+	if raw == "" {
+		return nil
+	}
+	var mappings []struct {
+		HostPort      int32
+		ContainerPort int32
+		Protocol      string
+		HostIP        string
+	}
+	if err := json.Unmarshal([]byte(raw), &mappings); err != nil {
+		log.Debugf("Container %s has invalid %s label: %v", id, nerdctlPortsLabel, err)
+		return nil
+	}
+	ports := make(nat.PortMap, len(mappings))
+	for _, m := range mappings {
+		proto := strings.ToLower(m.Protocol)
+		if proto == "" {
+			proto = "tcp"
+		}
+		port := nat.Port(strconv.Itoa(int(m.ContainerPort)) + "/" + proto)
+		ports[port] = append(ports[port], nat.PortBinding{HostIP: m.HostIP, HostPort: strconv.Itoa(int(m.HostPort))})
+	}
+	return ports
+}
+
+// cniResult is the part of a CNI cache entry that holds the assigned
+// addresses.
+type cniResult struct {
+	Result struct {
+		IPs []struct {
+			Address string `json:"address"`
+		} `json:"ips"`
+	} `json:"result"`
+}
+
+// cniAddresses fills endpoint with the addresses CNI assigned container id
+// in namespace on network. The CNI library caches each attachment's
+// result as NETWORK-ID-IFNAME, and nerdctl attaches containers under the
+// ID NAMESPACE-ID, so one namespace's results are not mistaken for
+// another's.
+func cniAddresses(dir, networkName, namespace, id string, endpoint *network.EndpointSettings) {
+	paths, err := filepath.Glob(filepath.Join(dir, networkName+"-"+namespace+"-"+id+"-*"))
+	if err != nil || len(paths) == 0 {
+		log.Debugf("No CNI result for container %s on network %s", shortID(id), networkName)
+		return
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warningf("Failed to read CNI result %s: %v", paths[0], err)
+		}
+		return
+	}
+	var result cniResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Warningf("Failed to parse CNI result %s: %v", paths[0], err)
+		return
+	}
+	for _, ip := range result.Result.IPs {
+		addr, _, err := net.ParseCIDR(ip.Address)
+		if err != nil {
+			continue
+		}
+		if addr.To4() != nil {
+			if endpoint.IPAddress == "" {
+				endpoint.IPAddress = addr.String()
+			}
+		} else if endpoint.GlobalIPv6Address == "" {
+			endpoint.GlobalIPv6Address = addr.String()
+		}
+	}
+}
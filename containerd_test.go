@@ -0,0 +1,362 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apievents "github.com/containerd/containerd/api/events"
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	introspectionapi "github.com/containerd/containerd/api/services/introspection/v1"
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
+	versionapi "github.com/containerd/containerd/api/services/version/v1"
+	apitypes "github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/api/types/task"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/coredns/caddy"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestParseRuntime(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+		runtime containerd k8s.io
+		endpoint unix:///run/k3s/containerd/containerd.sock
+	}`)
+	d := &Docker{}
+	if err := parse(c, d); err != nil {
+		t.Fatal(err)
+	}
+	if d.runtime != runtimeContainerd || d.namespace != "k8s.io" || d.endpoint != "unix:///run/k3s/containerd/containerd.sock" {
+		t.Errorf("expected runtime containerd in namespace k8s.io, got %s %s %s", d.runtime, d.namespace, d.endpoint)
+	}
+
+	c = caddy.NewTestController("dns", `docker {
+		endpoint docker unix:///var/run/docker.sock
+		endpoint nerdctl unix:///run/containerd/containerd.sock {
+			runtime containerd
+			subzone
+		}
+	}`)
+	d = &Docker{zones: []string{"docker."}}
+	if err := parse(c, d); err != nil {
+		t.Fatal(err)
+	}
+	if d.endpoints[0].runtimeName() != runtimeDocker || d.endpoints[1].runtimeName() != runtimeContainerd {
+		t.Errorf("expected per-endpoint runtimes docker and containerd, got %s and %s", d.endpoints[0].runtimeName(), d.endpoints[1].runtimeName())
+	}
+}
+
+func TestParseRuntimeErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing":           `docker { runtime }`,
+		"unknown":           `docker { runtime cri-o }`,
+		"docker namespace":  `docker { runtime docker default }`,
+		"too many":          `docker { runtime containerd default extra }`,
+		"containerd on tcp": "docker {\nruntime containerd\nendpoint tcp://10.0.0.5:2375\n}",
+		"containerd auto":   "docker {\nruntime containerd\nendpoint auto\n}",
+		"api_version":       "docker {\nruntime containerd\napi_version 1.41\n}",
+		"with named endpoints": `docker {
+			runtime containerd
+			endpoint a unix:///a.sock
+		}`,
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", input)
+			if err := parse(c, &Docker{zones: []string{"docker."}}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func writeCNIResult(t *testing.T, dir, network, namespace, id, ifname, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, network+"-"+namespace+"-"+id+"-"+ifname), []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNerdctlInspect(t *testing.T) {
+	dir := t.TempDir()
+	writeCNIResult(t, dir, "bridge", "default", "abc123", "eth0",
+		`{"kind":"cniCacheV1","result":{"ips":[{"address":"10.4.0.5/24"},{"address":"fd00::5/64"}]}}`)
+	writeCNIResult(t, dir, "backend", "default", "abc123", "eth1",
+		`{"kind":"cniCacheV1","result":{"ips":[{"address":"10.5.0.9/24"}]}}`)
+
+	labels := map[string]string{
+		nerdctlNameLabel:     "web",
+		nerdctlHostnameLabel: "web-host",
+		nerdctlNetworksLabel: `["bridge","backend"]`,
+		nerdctlPortsLabel:    `[{"HostPort":8080,"ContainerPort":80,"Protocol":"tcp","HostIP":"0.0.0.0"}]`,
+	}
+	inspect := nerdctlInspect("default", "abc123", labels, task.Status_RUNNING, dir)
+
+	if inspect.Name != "/web" || !inspect.State.Running || inspect.Config.Hostname != "web-host" {
+		t.Errorf("expected running container /web, got %s running=%t", inspect.Name, inspect.State.Running)
+	}
+	if inspect.HostConfig.NetworkMode != "bridge" {
+		t.Errorf("expected the first nerdctl network to be primary, got %s", inspect.HostConfig.NetworkMode)
+	}
+	bridge := inspect.NetworkSettings.Networks["bridge"]
+	if bridge == nil || bridge.IPAddress != "10.4.0.5" || bridge.GlobalIPv6Address != "fd00::5" {
+		t.Errorf("expected the CNI addresses on bridge, got %+v", bridge)
+	}
+	if backend := inspect.NetworkSettings.Networks["backend"]; backend == nil || backend.IPAddress != "10.5.0.9" {
+		t.Errorf("expected the CNI address on backend, got %+v", backend)
+	}
+	other := nerdctlInspect("other", "abc123", labels, task.Status_RUNNING, dir)
+	if bridge := other.NetworkSettings.Networks["bridge"]; bridge == nil || bridge.IPAddress != "" {
+		t.Errorf("expected no address from another namespace's CNI result, got %+v", bridge)
+	}
+	if b := inspect.NetworkSettings.Ports["80/tcp"]; len(b) != 1 || b[0].HostPort != "8080" || b[0].HostIP != "0.0.0.0" {
+		t.Errorf("expected port 80/tcp bound to 8080, got %v", b)
+	}
+
+	paused := nerdctlInspect("default", "abc123", labels, task.Status_PAUSED, "")
+	if !paused.State.Paused {
+		t.Errorf("expected a paused task to be reported paused")
+	}
+	unnamed := nerdctlInspect("default", "0123456789abcdef", nil, task.Status_STOPPED, "")
+	if unnamed.Name != "/0123456789ab" || unnamed.State.Running {
+		t.Errorf("expected a stopped container named by its short ID, got %s", unnamed.Name)
+	}
+}
+
+func envelope(t *testing.T, topic string, event proto.Message) *apitypes.Envelope {
+	t.Helper()
+	a, err := anypb.New(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &apitypes.Envelope{Timestamp: timestamppb.Now(), Namespace: "default", Topic: topic, Event: a}
+}
+
+func TestContainerdEvent(t *testing.T) {
+	tests := []struct {
+		topic  string
+		event  proto.Message
+		id     string
+		action events.Action
+	}{
+		{"/tasks/start", &apievents.TaskStart{ContainerID: "c1"}, "c1", events.ActionStart},
+		{"/tasks/exit", &apievents.TaskExit{ContainerID: "c1", ID: "c1"}, "c1", events.ActionDie},
+		{"/tasks/delete", &apievents.TaskDelete{ContainerID: "c1"}, "c1", events.ActionDie},
+		{"/tasks/paused", &apievents.TaskPaused{ContainerID: "c1"}, "c1", events.ActionPause},
+		{"/tasks/resumed", &apievents.TaskResumed{ContainerID: "c1"}, "c1", events.ActionUnPause},
+		{"/containers/update", &apievents.ContainerUpdate{ID: "c1"}, "c1", events.ActionUpdate},
+		{"/containers/delete", &apievents.ContainerDelete{ID: "c1"}, "c1", events.ActionDestroy},
+	}
+	for _, tt := range tests {
+		msg, ok := containerdEvent(envelope(t, tt.topic, tt.event))
+		if !ok || msg.Type != events.ContainerEventType || msg.Actor.ID != tt.id || msg.Action != tt.action {
+			t.Errorf("%s: expected %s %s, got %+v (ok=%t)", tt.topic, tt.action, tt.id, msg, ok)
+		}
+	}
+
+	if _, ok := containerdEvent(envelope(t, "/tasks/exit", &apievents.TaskExit{ContainerID: "c1", ID: "exec-1"})); ok {
+		t.Errorf("expected exec process exits to be ignored")
+	}
+	if _, ok := containerdEvent(envelope(t, "/tasks/oom", &apievents.TaskOOM{ContainerID: "c1"})); ok {
+		t.Errorf("expected unrelated events to be ignored")
+	}
+}
+
+// fakeContainerd holds the containers, tasks and events the fake
+// containerd services below serve.
+type fakeContainerd struct {
+	containers map[string]map[string]string // ID -> labels
+	tasks      map[string]task.Status
+	events     chan *apitypes.Envelope
+	namespaces chan string
+}
+
+type fakeContainers struct {
+	containersapi.UnimplementedContainersServer
+	*fakeContainerd
+}
+
+func (f fakeContainers) List(ctx context.Context, _ *containersapi.ListContainersRequest) (*containersapi.ListContainersResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ns := md.Get(containerdNamespaceHeader); len(ns) == 1 {
+		select {
+		case f.namespaces <- ns[0]:
+		default:
+		}
+	}
+	resp := &containersapi.ListContainersResponse{}
+	for id, labels := range f.containers {
+		resp.Containers = append(resp.Containers, &containersapi.Container{ID: id, Labels: labels})
+	}
+	return resp, nil
+}
+
+func (f fakeContainers) Get(ctx context.Context, req *containersapi.GetContainerRequest) (*containersapi.GetContainerResponse, error) {
+	labels, ok := f.containers[req.ID]
+	if !ok {
+		return nil, errgrpc.ToGRPC(cerrdefs.ErrNotFound)
+	}
+	return &containersapi.GetContainerResponse{Container: &containersapi.Container{ID: req.ID, Labels: labels}}, nil
+}
+
+type fakeTasks struct {
+	tasksapi.UnimplementedTasksServer
+	*fakeContainerd
+}
+
+func (f fakeTasks) List(ctx context.Context, _ *tasksapi.ListTasksRequest) (*tasksapi.ListTasksResponse, error) {
+	resp := &tasksapi.ListTasksResponse{}
+	for id, status := range f.tasks {
+		resp.Tasks = append(resp.Tasks, &task.Process{ID: id, ContainerID: id, Status: status})
+	}
+	return resp, nil
+}
+
+func (f fakeTasks) Get(ctx context.Context, req *tasksapi.GetRequest) (*tasksapi.GetResponse, error) {
+	status, ok := f.tasks[req.ContainerID]
+	if !ok {
+		return nil, errgrpc.ToGRPC(cerrdefs.ErrNotFound)
+	}
+	return &tasksapi.GetResponse{Process: &task.Process{ID: req.ContainerID, ContainerID: req.ContainerID, Status: status}}, nil
+}
+
+type fakeEvents struct {
+	eventsapi.UnimplementedEventsServer
+	*fakeContainerd
+}
+
+func (f fakeEvents) Subscribe(_ *eventsapi.SubscribeRequest, stream eventsapi.Events_SubscribeServer) error {
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-f.events:
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type fakeVersion struct {
+	versionapi.UnimplementedVersionServer
+}
+
+func (fakeVersion) Version(context.Context, *emptypb.Empty) (*versionapi.VersionResponse, error) {
+	return &versionapi.VersionResponse{Version: "v2.1.0"}, nil
+}
+
+type fakeIntrospection struct {
+	introspectionapi.UnimplementedIntrospectionServer
+}
+
+func (fakeIntrospection) Server(context.Context, *emptypb.Empty) (*introspectionapi.ServerResponse, error) {
+	return &introspectionapi.ServerResponse{UUID: "containerd-uuid"}, nil
+}
+
+// startFakeContainerd serves f on a unix socket and returns its URL.
+func startFakeContainerd(t *testing.T, f *fakeContainerd) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "containerd.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	containersapi.RegisterContainersServer(srv, fakeContainers{fakeContainerd: f})
+	tasksapi.RegisterTasksServer(srv, fakeTasks{fakeContainerd: f})
+	eventsapi.RegisterEventsServer(srv, fakeEvents{fakeContainerd: f})
+	versionapi.RegisterVersionServer(srv, fakeVersion{})
+	introspectionapi.RegisterIntrospectionServer(srv, fakeIntrospection{})
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return "unix://" + path
+}
+
+func TestContainerdClient(t *testing.T) {
+	ctx := context.Background()
+	cniDir := t.TempDir()
+	writeCNIResult(t, cniDir, "bridge", "nerdctl-test", "web1", "eth0", `{"result":{"ips":[{"address":"10.4.0.5/24"}]}}`)
+	f := &fakeContainerd{
+		containers: map[string]map[string]string{
+			"web1":  {nerdctlNameLabel: "web", nerdctlNetworksLabel: `["bridge"]`, "com.docker.compose.project": "shop", "com.docker.compose.service": "frontend"},
+			"done1": {nerdctlNameLabel: "done", nerdctlNetworksLabel: `["bridge"]`},
+		},
+		tasks:      map[string]task.Status{"web1": task.Status_RUNNING, "done1": task.Status_STOPPED},
+		events:     make(chan *apitypes.Envelope, 1),
+		namespaces: make(chan string, 1),
+	}
+	cli, err := newContainerdClient(startFakeContainerd(t, f), "nerdctl-test", cniDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	if _, err := cli.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := cli.Info(ctx); err != nil || info.ID != "containerd-uuid" {
+		t.Errorf("expected the containerd UUID as daemon ID, got %q (%v)", info.ID, err)
+	}
+	if v, err := cli.ServerVersion(ctx); err != nil || isPodman(v) || v.Version != "v2.1.0" {
+		t.Errorf("expected containerd v2.1.0, got %+v (%v)", v, err)
+	}
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || containers[0].ID != "web1" || containers[0].Names[0] != "/web" {
+		t.Fatalf("expected only the running container web1, got %+v", containers)
+	}
+	if ns := <-f.namespaces; ns != "nerdctl-test" {
+		t.Errorf("expected requests in namespace nerdctl-test, got %q", ns)
+	}
+	if _, err := cli.ContainerInspect(ctx, "missing"); !cerrdefs.IsNotFound(err) {
+		t.Errorf("expected not found for a missing container, got %v", err)
+	}
+
+	d := newSyncTestDocker()
+	d.client = cli
+	if err := d.syncRecords(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertRecordIPs(t, d, "web.docker.", "10.4.0.5")
+	assertRecordIPs(t, d, "shop.frontend.docker.", "10.4.0.5")
+
+	eventCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs, errs := cli.Events(eventCtx, events.ListOptions{})
+	f.events <- envelope(t, "/tasks/exit", &apievents.TaskExit{ContainerID: "web1", ID: "web1"})
+	select {
+	case msg := <-msgs:
+		if msg.Action != events.ActionDie || msg.Actor.ID != "web1" {
+			t.Errorf("expected die web1, got %s %s", msg.Action, msg.Actor.ID)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the event")
+	}
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled once the subscription is cancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscription to end")
+	}
+}
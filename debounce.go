@@ -0,0 +1,75 @@
+package docker
+
+import "time"
+
+// eventCoalescer collects container events that arrive in a burst so the
+// event loop can apply them as a single sync. Each new event pushes the
+// flush out by window, but never past maxDelay after the first event of
+// the burst, so a steady stream of events still gets applied.
+type eventCoalescer struct {
+	window   time.Duration
+	maxDelay time.Duration
+
+	pending []ContainerEvent
+	first   time.Time
+	timer   *time.Timer
+}
+
+// newEventCoalescer returns a coalescer with the given window and cap.
+func newEventCoalescer(window, maxDelay time.Duration) *eventCoalescer {
+	return &eventCoalescer{window: window, maxDelay: maxDelay}
+}
+
+// add queues an event and (re)arms the flush timer.
+func (c *eventCoalescer) add(ev ContainerEvent, now time.Time) {
+	if len(c.pending) == 0 {
+		c.first = now
+	}
+	c.pending = append(c.pending, ev)
+
+	wait := c.window
+	if remaining := c.maxDelay - now.Sub(c.first); remaining < wait {
+		wait = remaining
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	if c.timer == nil {
+		c.timer = time.NewTimer(wait)
+		return
+	}
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+	c.timer.Reset(wait)
+}
+
+// C returns the channel that fires when the pending burst should be
+// flushed. It is nil (blocks forever in a select) while nothing is
+// queued, and on a nil coalescer, so the event loop can select on it
+// whether or not debouncing is enabled.
+func (c *eventCoalescer) C() <-chan time.Time {
+	if c == nil || c.timer == nil || len(c.pending) == 0 {
+		return nil
+	}
+	return c.timer.C
+}
+
+// drain returns the queued events and resets the coalescer.
+func (c *eventCoalescer) drain() []ContainerEvent {
+	evs := c.pending
+	c.pending = nil
+	return evs
+}
+
+// stop discards any queued events and releases the timer.
+func (c *eventCoalescer) stop() {
+	c.pending = nil
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
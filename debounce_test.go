@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventCoalescerNilAndIdle(t *testing.T) {
+	var nilCoalescer *eventCoalescer
+	if nilCoalescer.C() != nil {
+		t.Errorf("expected nil channel from nil coalescer")
+	}
+
+	c := newEventCoalescer(10*time.Millisecond, 50*time.Millisecond)
+	if c.C() != nil {
+		t.Errorf("expected nil channel from idle coalescer")
+	}
+}
+
+func TestEventCoalescerFlushesBurstOnce(t *testing.T) {
+	c := newEventCoalescer(20*time.Millisecond, time.Second)
+	defer c.stop()
+
+	now := time.Now()
+	c.add(ContainerEvent{ID: "c1"}, now)
+	c.add(ContainerEvent{ID: "c1"}, now)
+	c.add(ContainerEvent{ID: "c2"}, now)
+
+	select {
+	case <-c.C():
+	case <-time.After(time.Second):
+		t.Fatal("coalescer did not fire")
+	}
+
+	batch := c.drain()
+	if len(batch) != 3 {
+		t.Fatalf("expected 3 queued events, got %d", len(batch))
+	}
+	if c.C() != nil {
+		t.Errorf("expected coalescer to be idle after drain")
+	}
+}
+
+func TestEventCoalescerMaxDelayCapsWindow(t *testing.T) {
+	c := newEventCoalescer(time.Hour, 30*time.Millisecond)
+	defer c.stop()
+
+	start := time.Now()
+	c.add(ContainerEvent{ID: "c1"}, start)
+	// A later event would push the flush out by a full window, but the
+	// burst is capped at maxDelay after its first event.
+	c.add(ContainerEvent{ID: "c2"}, start.Add(10*time.Millisecond))
+
+	select {
+	case <-c.C():
+	case <-time.After(time.Second):
+		t.Fatal("coalescer did not fire within the max delay")
+	}
+	if got := len(c.drain()); got != 2 {
+		t.Errorf("expected 2 queued events, got %d", got)
+	}
+}
+
+func TestEventCoalescerStopDiscardsPending(t *testing.T) {
+	c := newEventCoalescer(time.Hour, time.Hour)
+	c.add(ContainerEvent{ID: "c1"}, time.Now())
+	c.stop()
+	if c.C() != nil {
+		t.Errorf("expected no pending flush after stop")
+	}
+	if got := len(c.drain()); got != 0 {
+		t.Errorf("expected stop to discard pending events, got %d", got)
+	}
+}
@@ -0,0 +1,425 @@
+package docker
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+const (
+	// sigValidity is how long an RRSIG is valid for. Inception is
+	// backdated by sigSkew, for validators whose clock is behind.
+	sigValidity = 7 * 24 * time.Hour
+	sigSkew     = 3 * time.Hour
+	// sigRefresh is how long a cached RRSIG is reused before the RRset is
+	// signed again, long before the signature expires.
+	sigRefresh = 24 * time.Hour
+	// sigCacheSize is how many signed RRsets are cached.
+	sigCacheSize = 10000
+)
+
+// dnssecKey is a zone signing key and its private half.
+type dnssecKey struct {
+	dnskey *dns.DNSKEY
+	priv   crypto.Signer
+	tag    uint16
+}
+
+// zoneKeys are the keys of one zone. The KSKs sign the DNSKEY RRset and
+// the ZSKs everything else. A zone with a single kind of key uses it for
+// both, as a combined signing key.
+type zoneKeys struct {
+	ksks, zsks []*dnssecKey
+}
+
+// signer signs answers for DNSSEC-aware clients with each zone's keys.
+// Signatures are made when a query first needs them and cached by the
+// RRset they cover, so a record that did not change is not signed again
+// after a sync.
+type signer struct {
+	keys  map[string]*zoneKeys // zone -> keys
+	cache *cache.Cache[*signedRRset]
+}
+
+// signedRRset is a cached set of RRSIGs for one RRset. refresh is when
+// the RRset should be signed again.
+type signedRRset struct {
+	sigs    []*dns.RRSIG
+	refresh time.Time
+}
+
+// newSigner loads or generates the keys for zones. With no key arguments
+// a KSK and a ZSK are generated for each zone and kept in memory. A single
+// directory argument holds a key pair per zone, generated and written
+// there on first use, along with a dsset-<zone> file of its DS records.
+// Otherwise every argument is a BIND key pair, given as the path without
+// its .key or .private extension, and every zone needs at least one.
+func newSigner(zones []string, args []string) (*signer, error) {
+	s := &signer{keys: make(map[string]*zoneKeys, len(zones)), cache: cache.New[*signedRRset](sigCacheSize)}
+	switch {
+	case len(args) == 0:
+		for _, zone := range zones {
+			keys, err := generateZoneKeys(zone)
+			if err != nil {
+				return nil, err
+			}
+			s.keys[zone] = keys
+		}
+		log.Warning("DNSSEC keys were generated in memory and change on every restart, so no DS record can stay valid; use key files or a key directory for a chain of trust")
+	case len(args) == 1 && isDir(args[0]):
+		for _, zone := range zones {
+			keys, err := loadKeyDir(args[0], zone)
+			if err != nil {
+				return nil, err
+			}
+			s.keys[zone] = keys
+		}
+	default:
+		for _, arg := range args {
+			k, err := readKeyFile(arg)
+			if err != nil {
+				return nil, err
+			}
+			zone, ok := matchZone(zones, k.dnskey.Header().Name)
+			if !ok {
+				return nil, fmt.Errorf("DNSSEC key %s is for %s, which is not a configured zone", arg, k.dnskey.Header().Name)
+			}
+			if s.keys[zone] == nil {
+				s.keys[zone] = &zoneKeys{}
+			}
+			s.keys[zone].add(k)
+		}
+		for _, zone := range zones {
+			if s.keys[zone] == nil {
+				return nil, fmt.Errorf("no DNSSEC key for zone %s", zone)
+			}
+		}
+	}
+	for _, zone := range zones {
+		for _, ds := range s.keys[zone].ds() {
+			log.Infof("DS record for zone %s: %s", zone, ds)
+		}
+	}
+	return s, nil
+}
+
+// add files a key as a KSK or ZSK by its SEP flag.
+func (z *zoneKeys) add(k *dnssecKey) {
+	if k.dnskey.Flags&dns.SEP != 0 {
+		z.ksks = append(z.ksks, k)
+	} else {
+		z.zsks = append(z.zsks, k)
+	}
+}
+
+// signing returns the keys that sign RRsets of type rrtype.
+func (z *zoneKeys) signing(rrtype uint16) []*dnssecKey {
+	if (rrtype == dns.TypeDNSKEY && len(z.ksks) > 0) || len(z.zsks) == 0 {
+		return z.ksks
+	}
+	return z.zsks
+}
+
+// all returns every key of the zone.
+func (z *zoneKeys) all() []*dnssecKey {
+	return append(append([]*dnssecKey(nil), z.ksks...), z.zsks...)
+}
+
+// generateZoneKeys generates an ECDSA P-256 KSK and ZSK for zone.
+func generateZoneKeys(zone string) (*zoneKeys, error) {
+	z := &zoneKeys{}
+	for _, flags := range []uint16{dns.ZONE | dns.SEP, dns.ZONE} {
+		k, err := generateKey(zone, flags)
+		if err != nil {
+			return nil, err
+		}
+		z.add(k)
+	}
+	return z, nil
+}
+
+func generateKey(zone string, flags uint16) (*dnssecKey, error) {
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := dnskey.Generate(256)
+	if err != nil {
+		return nil, fmt.Errorf("generating DNSSEC key for %s: %w", zone, err)
+	}
+	return newDNSSECKey(dnskey, priv, zone)
+}
+
+func newDNSSECKey(dnskey *dns.DNSKEY, priv crypto.PrivateKey, name string) (*dnssecKey, error) {
+	s, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("DNSSEC key %s cannot sign", name)
+	}
+	return &dnssecKey{dnskey: dnskey, priv: s, tag: dnskey.KeyTag()}, nil
+}
+
+// readKeyFile reads the BIND key pair base.key and base.private. base may
+// also be given with either extension.
+func readKeyFile(base string) (*dnssecKey, error) {
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ".key"), ".private")
+	pub, err := os.ReadFile(base + ".key")
+	if err != nil {
+		return nil, fmt.Errorf("reading DNSSEC key: %w", err)
+	}
+	rr, err := dns.NewRR(string(pub))
+	if err != nil {
+		return nil, fmt.Errorf("parsing DNSSEC key %s.key: %w", base, err)
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("%s.key does not hold a DNSKEY record", base)
+	}
+	f, err := os.Open(base + ".private")
+	if err != nil {
+		return nil, fmt.Errorf("reading DNSSEC key: %w", err)
+	}
+	defer f.Close()
+	priv, err := dnskey.ReadPrivateKey(f, base+".private")
+	if err != nil {
+		return nil, fmt.Errorf("parsing DNSSEC key %s.private: %w", base, err)
+	}
+	return newDNSSECKey(dnskey, priv, base)
+}
+
+// loadKeyDir reads zone's keys from dir, generating and writing a KSK and
+// ZSK if it has none yet, and writes the zone's DS records to
+// dir/dsset-<zone>.
+func loadKeyDir(dir, zone string) (*zoneKeys, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "K"+zone+"+*.key"))
+	if err != nil {
+		return nil, err
+	}
+	z := &zoneKeys{}
+	for _, path := range matches {
+		k, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		z.add(k)
+	}
+	if len(matches) == 0 {
+		log.Infof("Generating DNSSEC keys for zone %s in %s", zone, dir)
+		if z, err = generateZoneKeys(zone); err != nil {
+			return nil, err
+		}
+		for _, k := range z.all() {
+			if err := writeKeyFile(dir, k); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var dsset strings.Builder
+	for _, ds := range z.ds() {
+		dsset.WriteString(ds.String() + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dsset-"+zone), []byte(dsset.String()), 0o644); err != nil {
+		return nil, fmt.Errorf("writing DS records: %w", err)
+	}
+	return z, nil
+}
+
+// writeKeyFile writes k to dir as a BIND key pair.
+func writeKeyFile(dir string, k *dnssecKey) error {
+	base := filepath.Join(dir, fmt.Sprintf("K%s+%03d+%05d", k.dnskey.Header().Name, k.dnskey.Algorithm, k.tag))
+	if err := os.WriteFile(base+".key", []byte(k.dnskey.String()+"\n"), 0o644); err != nil {
+		return fmt.Errorf("writing DNSSEC key: %w", err)
+	}
+	if err := os.WriteFile(base+".private", []byte(k.dnskey.PrivateKeyString(k.priv)), 0o600); err != nil {
+		return fmt.Errorf("writing DNSSEC key: %w", err)
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// matchZone returns the configured zone equal to name, ignoring case.
+func matchZone(zones []string, name string) (string, bool) {
+	for _, zone := range zones {
+		if strings.EqualFold(zone, name) {
+			return zone, true
+		}
+	}
+	return "", false
+}
+
+// ds returns the SHA-256 DS records of the KSKs, for the zone's parent.
+func (z *zoneKeys) ds() []*dns.DS {
+	var dss []*dns.DS
+	for _, k := range z.signing(dns.TypeDNSKEY) {
+		dss = append(dss, k.dnskey.ToDS(dns.SHA256))
+	}
+	return dss
+}
+
+// dnskeys returns the DNSKEY RRset with owner name and TTL.
+func (z *zoneKeys) dnskeys(name string, ttl uint32) []dns.RR {
+	var rrs []dns.RR
+	for _, k := range z.all() {
+		c := *k.dnskey
+		c.Hdr.Name = name
+		c.Hdr.Ttl = ttl
+		rrs = append(rrs, &c)
+	}
+	return rrs
+}
+
+// sign returns the RRSIGs for every RRset in rrs, which must belong to
+// zone. The signatures cover the RRs with origTTL as their TTL, so they
+// stay valid for the reduced TTL of stale answers. RRSIG and OPT records
+// are skipped.
+func (s *signer) sign(zone string, rrs []dns.RR, origTTL uint32) []dns.RR {
+	z := s.keys[zone]
+	if z == nil {
+		return nil
+	}
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	var order []rrsetKey
+	rrsets := make(map[rrsetKey][]dns.RR)
+	for _, rr := range rrs {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeRRSIG || h.Rrtype == dns.TypeOPT {
+			continue
+		}
+		key := rrsetKey{strings.ToLower(h.Name), h.Rrtype}
+		if _, ok := rrsets[key]; !ok {
+			order = append(order, key)
+		}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	var sigs []dns.RR
+	for _, key := range order {
+		rrset := rrsets[key]
+		for _, sig := range s.signRRset(zone, z.signing(key.rrtype), rrset, origTTL) {
+			c := *sig
+			c.Hdr.Name = rrset[0].Header().Name
+			c.Hdr.Ttl = rrset[0].Header().Ttl
+			sigs = append(sigs, &c)
+		}
+	}
+	return sigs
+}
+
+// signRRset returns the signatures of rrset by keys, from the cache if it
+// holds recent ones.
+func (s *signer) signRRset(zone string, keys []*dnssecKey, rrset []dns.RR, origTTL uint32) []*dns.RRSIG {
+	now := time.Now()
+	hash := rrsetHash(keys, rrset, origTTL)
+	if cached, ok := s.cache.Get(hash); ok && now.Before(cached.refresh) {
+		return cached.sigs
+	}
+
+	inception := uint32(now.Add(-sigSkew).Unix())
+	expiration := uint32(now.Add(sigValidity).Unix())
+	var sigs []*dns.RRSIG
+	for _, k := range keys {
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Ttl: origTTL},
+			Algorithm:  k.dnskey.Algorithm,
+			KeyTag:     k.tag,
+			SignerName: zone,
+			OrigTtl:    origTTL,
+			Inception:  inception,
+			Expiration: expiration,
+		}
+		if err := sig.Sign(k.priv, rrset); err != nil {
+			log.Errorf("Failed to sign %s %s: %v", rrset[0].Header().Name, dns.TypeToString[rrset[0].Header().Rrtype], err)
+			continue
+		}
+		dnssecSignatureCount.Inc()
+		sigs = append(sigs, sig)
+	}
+	s.cache.Add(hash, &signedRRset{sigs: sigs, refresh: now.Add(sigRefresh)})
+	return sigs
+}
+
+// rrsetHash identifies an RRset and the keys that sign it for the
+// signature cache. Owner names are lowercased and TTLs left out, as they
+// are when signing.
+func rrsetHash(keys []*dnssecKey, rrset []dns.RR, origTTL uint32) uint64 {
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%d ", k.tag)
+	}
+	fmt.Fprintf(&b, "%d", origTTL)
+	for _, rr := range rrset {
+		c := dns.Copy(rr)
+		c.Header().Name = strings.ToLower(c.Header().Name)
+		c.Header().Ttl = 0
+		b.WriteString("\n" + c.String())
+	}
+	return cache.Hash([]byte(b.String()))
+}
+
+// secure signs m for a client that set the DO bit, if dnssec is enabled
+// and m's records belong to zone. A negative answer is turned into a
+// "black lie": a NOERROR answer with an NSEC record at the query name
+// that lists just the types existing there, so one signed record proves
+// it without revealing the zone's other names. n holds the answers at the
+// query name, if it exists.
+func (d *Docker) secure(state request.Request, zone string, m *dns.Msg, n *nameAnswers) {
+	if d.signer == nil || !state.Do() || d.signer.keys[zone] == nil {
+		return
+	}
+	if len(m.Answer) == 0 && (m.Rcode == dns.RcodeSuccess || m.Rcode == dns.RcodeNameError) {
+		m.Ns = append(m.Ns, d.nsec(state.QName(), zone, n, m.Rcode == dns.RcodeNameError))
+		m.Rcode = dns.RcodeSuccess
+	}
+	m.Answer = append(m.Answer, d.signer.sign(zone, m.Answer, d.ttl)...)
+	m.Ns = append(m.Ns, d.signer.sign(zone, m.Ns, d.negTTL())...)
+	m.Extra = append(m.Extra, d.signer.sign(zone, m.Extra, d.ttl)...)
+}
+
+// nsec returns the black lie NSEC record for name: its next name is the
+// closest possible successor, \000.name, so it covers no other name. Its
+// bitmap lists the types at name, or, for a name that does not exist,
+// NXNAME as in compact denial of existence (RFC 9824).
+func (d *Docker) nsec(name, zone string, n *nameAnswers, nxdomain bool) *dns.NSEC {
+	var types []uint16
+	apex := strings.EqualFold(name, zone)
+	if apex {
+		types = append(types, dns.TypeNS, dns.TypeSOA, dns.TypeDNSKEY)
+	}
+	if n != nil {
+		if n.cname != nil {
+			types = append(types, dns.TypeCNAME)
+		}
+		for rrtype, rrs := range n.rrsets {
+			if len(rrs) > 0 {
+				types = append(types, rrtype)
+			}
+		}
+	}
+	if nxdomain && !apex {
+		types = append(types, dns.TypeNXNAME)
+	}
+	types = append(types, dns.TypeRRSIG, dns.TypeNSEC)
+	slices.Sort(types)
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: d.negTTL()},
+		NextDomain: `\000.` + strings.ToLower(name),
+		TypeBitMap: types,
+	}
+}
@@ -0,0 +1,280 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// newDNSSECTestDocker returns a Docker serving web.docker. and its PTR,
+// signing with generated keys.
+func newDNSSECTestDocker(t *testing.T) *Docker {
+	t.Helper()
+	d := &Docker{
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker.", "17.172.in-addr.arpa."},
+	}
+	s, err := newSigner(d.zones, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.signer = s
+	storeSnapshot(d, &snapshot{
+		connected: true,
+		recordSet: recordSet{
+			records: map[string][]net.IP{"web.docker.": {net.ParseIP("172.17.0.2")}},
+			ptrs:    map[string][]string{"2.0.17.172.in-addr.arpa.": {"web.docker."}},
+		},
+	})
+	return d
+}
+
+// serveDO sends a query with the DO bit set, or without it if do is
+// false, and returns the response.
+func serveDO(t *testing.T, d *Docker, qname string, qtype uint16, do bool) *dns.Msg {
+	t.Helper()
+	r := new(dns.Msg)
+	r.SetQuestion(qname, qtype)
+	r.SetEdns0(4096, do)
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := d.ServeDNS(context.Background(), w, r); err != nil {
+		t.Fatal(err)
+	}
+	return w.Msg
+}
+
+// verifySection checks that every RRset in rrs is covered by a valid
+// RRSIG from one of the zone's keys, and returns the signed types.
+func verifySection(t *testing.T, d *Docker, zone string, rrs []dns.RR) []uint16 {
+	t.Helper()
+	keys := d.signer.keys[zone].all()
+	var types []uint16
+	for _, rr := range rrs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		var rrset []dns.RR
+		for _, other := range rrs {
+			if other.Header().Rrtype == sig.TypeCovered && other.Header().Name == sig.Header().Name {
+				rrset = append(rrset, other)
+			}
+		}
+		i := slices.IndexFunc(keys, func(k *dnssecKey) bool { return k.tag == sig.KeyTag })
+		if i < 0 {
+			t.Fatalf("expected %s to be signed by a key of %s", sig, zone)
+		}
+		if err := sig.Verify(keys[i].dnskey, rrset); err != nil {
+			t.Errorf("expected a valid signature over %v, got %v", rrset, err)
+		}
+		if !sig.ValidityPeriod(time.Now()) {
+			t.Errorf("expected %s to be valid now", sig)
+		}
+		types = append(types, sig.TypeCovered)
+	}
+	return types
+}
+
+func TestDNSSECSignsAnswers(t *testing.T) {
+	d := newDNSSECTestDocker(t)
+
+	m := serveDO(t, d, "web.docker.", dns.TypeA, true)
+	if got := verifySection(t, d, "docker.", m.Answer); !slices.Equal(got, []uint16{dns.TypeA}) {
+		t.Errorf("expected the A RRset to be signed, got %v", m.Answer)
+	}
+
+	m = serveDO(t, d, "web.docker.", dns.TypeA, false)
+	for _, rr := range m.Answer {
+		if _, ok := rr.(*dns.RRSIG); ok {
+			t.Errorf("expected no signatures without the DO bit, got %v", rr)
+		}
+	}
+
+	m = serveDO(t, d, "2.0.17.172.in-addr.arpa.", dns.TypePTR, true)
+	if got := verifySection(t, d, "17.172.in-addr.arpa.", m.Answer); !slices.Equal(got, []uint16{dns.TypePTR}) {
+		t.Errorf("expected the PTR RRset to be signed by the reverse zone, got %v", m.Answer)
+	}
+
+	for _, qtype := range []uint16{dns.TypeSOA, dns.TypeNS} {
+		m = serveDO(t, d, "docker.", qtype, true)
+		if got := verifySection(t, d, "docker.", m.Answer); !slices.Equal(got, []uint16{qtype}) {
+			t.Errorf("expected the apex %s to be signed, got %v", dns.TypeToString[qtype], m.Answer)
+		}
+	}
+}
+
+func TestDNSSECDNSKEY(t *testing.T) {
+	d := newDNSSECTestDocker(t)
+	m := serveDO(t, d, "docker.", dns.TypeDNSKEY, true)
+	var keys int
+	for _, rr := range m.Answer {
+		if _, ok := rr.(*dns.DNSKEY); ok {
+			keys++
+		}
+	}
+	if keys != 2 {
+		t.Errorf("expected the KSK and ZSK at the apex, got %v", m.Answer)
+	}
+	verifySection(t, d, "docker.", m.Answer)
+	ksk := d.signer.keys["docker."].ksks[0]
+	if !slices.ContainsFunc(m.Answer, func(rr dns.RR) bool {
+		sig, ok := rr.(*dns.RRSIG)
+		return ok && sig.KeyTag == ksk.tag
+	}) {
+		t.Errorf("expected the DNSKEY RRset to be signed by the KSK, got %v", m.Answer)
+	}
+}
+
+func TestDNSSECBlackLies(t *testing.T) {
+	d := newDNSSECTestDocker(t)
+
+	tests := map[string]struct {
+		qname string
+		qtype uint16
+		want  []uint16
+	}{
+		"nxdomain":    {"missing.docker.", dns.TypeA, []uint16{dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNXNAME}},
+		"nodata":      {"web.docker.", dns.TypeAAAA, []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC}},
+		"apex nodata": {"docker.", dns.TypeA, []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := serveDO(t, d, tt.qname, tt.qtype, true)
+			if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 {
+				t.Fatalf("expected an empty NOERROR answer, got %v", m)
+			}
+			i := slices.IndexFunc(m.Ns, func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeNSEC })
+			if i < 0 {
+				t.Fatalf("expected an NSEC record, got %v", m.Ns)
+			}
+			nsec := m.Ns[i].(*dns.NSEC)
+			if nsec.NextDomain != `\000.`+tt.qname || !slices.Equal(nsec.TypeBitMap, tt.want) {
+				t.Errorf("expected NSEC to \\000.%s with %v, got %v", tt.qname, tt.want, nsec)
+			}
+			got := verifySection(t, d, "docker.", m.Ns)
+			slices.Sort(got)
+			if !slices.Equal(got, []uint16{dns.TypeSOA, dns.TypeNSEC}) {
+				t.Errorf("expected the SOA and NSEC to be signed, got %v", m.Ns)
+			}
+		})
+	}
+
+	// Without the DO bit, a missing name is still NXDOMAIN.
+	if m := serveDO(t, d, "missing.docker.", dns.TypeA, false); m.Rcode != dns.RcodeNameError {
+		t.Errorf("expected NXDOMAIN without the DO bit, got %s", dns.RcodeToString[m.Rcode])
+	}
+}
+
+func TestDNSSECSignatureCache(t *testing.T) {
+	d := newDNSSECTestDocker(t)
+	first := serveDO(t, d, "web.docker.", dns.TypeA, true)
+	second := serveDO(t, d, "WEB.docker.", dns.TypeA, true)
+	sig := func(m *dns.Msg) *dns.RRSIG {
+		for _, rr := range m.Answer {
+			if sig, ok := rr.(*dns.RRSIG); ok {
+				return sig
+			}
+		}
+		t.Fatalf("expected a signature, got %v", m.Answer)
+		return nil
+	}
+	if sig(first).Signature != sig(second).Signature {
+		t.Errorf("expected the signature to be reused")
+	}
+	if name := sig(second).Header().Name; name != "WEB.docker." {
+		t.Errorf("expected the cached signature to take the query's casing, got %s", name)
+	}
+}
+
+func TestNewSignerKeyDir(t *testing.T) {
+	dir := t.TempDir()
+	zones := []string{"docker.", "internal.docker."}
+	first, err := newSigner(zones, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newSigner(zones, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, zone := range zones {
+		if first.keys[zone].ksks[0].tag != second.keys[zone].ksks[0].tag || first.keys[zone].zsks[0].tag != second.keys[zone].zsks[0].tag {
+			t.Errorf("expected the keys written for %s to be reused", zone)
+		}
+		dsset, err := os.ReadFile(filepath.Join(dir, "dsset-"+zone))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := dns.NewRR(string(dsset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ds, ok := rr.(*dns.DS); !ok || ds.KeyTag != first.keys[zone].ksks[0].tag {
+			t.Errorf("expected the DS of the KSK in dsset-%s, got %v", zone, rr)
+		}
+	}
+}
+
+func TestNewSignerKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	var bases []string
+	for _, zone := range []string{"docker.", "other."} {
+		k, err := generateKey(zone, dns.ZONE|dns.SEP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeKeyFile(dir, k); err != nil {
+			t.Fatal(err)
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "K"+zone+"+*.key"))
+		bases = append(bases, matches[0])
+	}
+
+	s, err := newSigner([]string{"docker.", "other."}, bases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A single key is a combined signing key.
+	z := s.keys["docker."]
+	if len(z.signing(dns.TypeA)) != 1 || z.signing(dns.TypeA)[0] != z.signing(dns.TypeDNSKEY)[0] {
+		t.Errorf("expected the only key to sign everything, got %+v", z)
+	}
+
+	if _, err := newSigner([]string{"docker.", "missing."}, bases[:1]); err == nil {
+		t.Errorf("expected an error for a zone without a key")
+	}
+	if _, err := newSigner([]string{"docker."}, bases); err == nil {
+		t.Errorf("expected an error for a key of an unconfigured zone")
+	}
+	if _, err := newSigner([]string{"docker."}, []string{filepath.Join(dir, "Kmissing")}); err == nil {
+		t.Errorf("expected an error for a missing key file")
+	}
+}
+
+func TestParseDNSSEC(t *testing.T) {
+	d := &Docker{}
+	if err := parse(caddy.NewTestController("dns", "docker {\ndnssec /etc/keys/Kdocker.+013+12345\n}"), d); err != nil {
+		t.Fatal(err)
+	}
+	if !d.dnssec || !slices.Equal(d.dnssecKeys, []string{"/etc/keys/Kdocker.+013+12345"}) {
+		t.Errorf("expected dnssec with one key file, got %t %v", d.dnssec, d.dnssecKeys)
+	}
+
+	d = &Docker{}
+	if err := parse(caddy.NewTestController("dns", "docker {\ndnssec\n}"), d); err != nil {
+		t.Fatal(err)
+	}
+	if !d.dnssec || len(d.dnssecKeys) != 0 {
+		t.Errorf("expected dnssec with generated keys, got %t %v", d.dnssec, d.dnssecKeys)
+	}
+}
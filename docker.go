@@ -2,12 +2,14 @@ package docker
 
 import (
 	"context"
+	"maps"
 	"net"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -16,11 +18,10 @@ import (
 	"github.com/coredns/coredns/plugin/pkg/fall"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/network"
-	"github.com/docker/docker/client"
 	"github.com/miekg/dns"
 )
 
@@ -33,30 +34,129 @@ var (
 // DefaultTTL is the default TTL for DNS records.
 const DefaultTTL = uint32(30)
 
+// defaultSyncMaxDelayFactor caps a sync_debounce burst at this many
+// windows when no explicit max delay is configured.
+const defaultSyncMaxDelayFactor = 5
+
+// defaultInspectGrace is how long a container's previous records are
+// carried forward while inspecting it keeps failing, when inspect_grace
+// is not configured.
+const defaultInspectGrace = time.Minute
+
 // Docker is a plugin that serves records for Docker containers
 type Docker struct {
 	Next plugin.Handler
 
 	Fall fall.F
 
-	ttl           uint32
-	client        *client.Client
-	zones         []string
-	labelPrefix   string
-	maxBackoff    time.Duration
-	networks      []string
-	hostMode      bool
-	hostModePTR   bool
-	nameTemplates []*template.Template
-
-	mu           sync.RWMutex
-	records      map[string][]net.IP
-	srvs         map[string][]srvRecord
-	ptrs         map[string][]string   // reverse-arpa FQDN -> container FQDNs
-	cnames       map[string]string     // FQDN -> canonical target (trailing dot)
-	txts         map[string][][]string // FQDN -> list of TXT RRs; each inner slice is one RR's character-strings
-	connected    bool
-	lastSyncTime time.Time
+	// name and subzone are set on named endpoints. Their records are
+	// generated under subzone.zone; see configureEndpoints.
+	name    string
+	subzone string
+	// endpoints holds the named endpoint blocks. When there are any, d
+	// runs no client of its own and only aggregates their records;
+	// parent points back from each endpoint.
+	endpoints []*Docker
+	parent    *Docker
+	// loaded holds the records read from snapshot_file, served alongside
+	// the endpoints' records until each endpoint has synced once.
+	loaded *recordSet
+	// persister writes the published records to snapshot_file.
+	persister snapshotWriter
+
+	ttl            uint32
+	client         daemonClient
+	zones          []string
+	labelPrefix    string
+	maxBackoff     time.Duration
+	networks       []string
+	hostMode       bool
+	hostModePTR    bool
+	linkLocal      bool
+	lazyConnect    bool
+	snapshotFile   string
+	sourceDir      string
+	runtime        string
+	namespace      string
+	endpoint       string
+	tlsCA          string
+	tlsCert        string
+	tlsKey         string
+	apiVersion     string
+	nameTemplates  []*template.Template
+	syncDebounce   time.Duration
+	syncMaxDelay   time.Duration
+	resyncInterval time.Duration
+	apiTimeout     time.Duration
+	// heartbeatInterval enables the daemon watchdog; heartbeatTimeout
+	// bounds each heartbeat probe.
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	// inspectConcurrency bounds parallel inspects during a full sync;
+	// inspectCache skips inspects for containers that have not changed.
+	inspectConcurrency int
+	inspectCache       *inspectCache
+	// inspectGrace is how long a container's previous records are served
+	// while inspecting it keeps failing.
+	inspectGrace time.Duration
+	// swarmInterval enables swarm mode and sets how often services and
+	// tasks are relisted. swarmSet holds the records they produced,
+	// guarded by syncMu; swarmErr is the last listing error, for logging.
+	swarmInterval time.Duration
+	swarmSet      *recordSet
+	swarmErr      string
+	// source, when set, is where containers come from instead of client;
+	// see containerSource.
+	source ContainerSource
+	// nsNames, soaMbox and the soa* timers set the fields of the
+	// synthetic SOA and NS records, and negativeTTL, when set, the TTL of
+	// negative answers; see nameservers, mbox, soaTimers and negTTL. glue
+	// holds the addresses served for NS names inside a zone, detected from
+	// the listen addresses at setup when glueAuto is set.
+	nsNames                         []string
+	soaMbox                         string
+	soaRefresh, soaRetry, soaExpire uint32
+	negativeTTL                     *uint32
+	glue                            []net.IP
+	glueAuto                        bool
+	// dnssec is set by the dnssec directive, with dnssecKeys its key
+	// files or key directory. signer, loaded from them at setup, signs
+	// answers for clients that set the DO bit.
+	dnssec     bool
+	dnssecKeys []string
+	signer     *signer
+	// updateKeys, keyed by TSIG key name, may send dynamic updates, whose
+	// records overlay holds; updateLease is how long they are served when
+	// the update asks for no lease. updateMu serializes updates.
+	updateKeys  map[string]updateKey
+	updateLease time.Duration
+	updateMu    sync.Mutex
+	overlay     overlay
+	// xfr, when the server block has a transfer plugin, is sent NOTIFY
+	// for each zone whose records change.
+	xfr notifier
+	// watcher, when set, owns client and shares it, its events and its
+	// inspected containers with other instances using the same endpoint.
+	watcher *watcher
+	// podman is set while the daemon is Podman, detected on every
+	// (re)connect; libpod lists its pods, and pods holds the latest list.
+	podman atomic.Bool
+	libpod podLister
+	pods   atomic.Pointer[podIndex]
+
+	// snap holds the record tables and connection state ServeDNS reads;
+	// mu serializes the writers that publish a new one.
+	mu   sync.Mutex
+	snap atomic.Pointer[snapshot]
+
+	// syncMu serializes full and per-container syncs and guards the
+	// per-container record sets they build the tables above from.
+	syncMu         sync.Mutex
+	containerSets  map[string]*recordSet // container ID -> records it contributes
+	containerOrder []string              // container IDs in merge order
+	// inspectFailures holds when each container's current run of failed
+	// inspects started.
+	inspectFailures map[string]time.Time
 }
 
 type srvRecord struct {
@@ -71,17 +171,20 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	defer func() {
 		requestDuration.WithLabelValues(metrics.WithServer(ctx), dns.TypeToString[state.QType()]).Observe(time.Since(start).Seconds())
 	}()
+	if r.Opcode == dns.OpcodeUpdate {
+		return d.serveUpdate(ctx, w, r)
+	}
 	qname := strings.ToLower(state.Name())
 	qtype := state.QType()
 	log.Debugf("Query: qname=%s qtype=%s", qname, dns.TypeToString[qtype])
 
+	snap := d.loadSnapshot()
+
 	// Handle PTR queries (reverse DNS) before zone check.
 	// PTR queries use in-addr.arpa/ip6.arpa zones which are outside our configured zones.
 	if qtype == dns.TypePTR {
-		d.mu.RLock()
-		ptrs, ptrOk := d.ptrs[qname]
-		isConnected := d.connected
-		d.mu.RUnlock()
+		ptrs, ptrOk := snap.answers.ptr(qname)
+		isConnected := snap.fresh(qname)
 
 		if !ptrOk {
 			log.Debugf("No PTR records for %s, passing to next plugin", qname)
@@ -102,20 +205,11 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 			requestStaleCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
 		}
 
-		for _, fqdn := range ptrs {
-			m.Answer = append(m.Answer, &dns.PTR{
-				Hdr: dns.RR_Header{
-					Name:   state.QName(),
-					Rrtype: dns.TypePTR,
-					Class:  dns.ClassINET,
-					Ttl:    ttl,
-				},
-				Ptr: fqdn,
-			})
-		}
+		m.Answer = patchedRRs(ptrs, state.QName(), ttl)
 
 		log.Debugf("Response for %s PTR: %d answer(s)", qname, len(m.Answer))
 
+		d.secure(state, plugin.Zones(d.zones).Matches(qname), m, nil)
 		if err := w.WriteMsg(m); err != nil {
 			log.Errorf("Failed to write message: %v", err)
 			requestFailedCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
@@ -138,7 +232,8 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		m := new(dns.Msg)
 		m.SetReply(r)
 		m.Authoritative = true
-		m.Answer = []dns.RR{d.soa(zone)}
+		m.Answer = []dns.RR{d.apexSOA(snap, zone)}
+		d.secure(state, zone, m, nil)
 		if err := w.WriteMsg(m); err != nil {
 			log.Errorf("Failed to write message: %v", err)
 			requestFailedCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
@@ -154,7 +249,26 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		m := new(dns.Msg)
 		m.SetReply(r)
 		m.Authoritative = true
-		m.Answer = []dns.RR{d.ns(zone)}
+		m.Answer = d.apexNS(snap, zone)
+		m.Extra = d.additionalGlue(snap, zone)
+		d.secure(state, zone, m, nil)
+		if err := w.WriteMsg(m); err != nil {
+			log.Errorf("Failed to write message: %v", err)
+			requestFailedCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
+		} else {
+			requestSuccessCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
+		}
+		return dns.RcodeSuccess, nil
+	}
+
+	// Handle DNSKEY query at zone apex when signing
+	if qtype == dns.TypeDNSKEY && qname == zone && d.signer != nil {
+		log.Debugf("DNSKEY query at zone apex for %s", zone)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Answer = d.signer.keys[zone].dnskeys(state.QName(), d.ttl)
+		d.secure(state, zone, m, nil)
 		if err := w.WriteMsg(m); err != nil {
 			log.Errorf("Failed to write message: %v", err)
 			requestFailedCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
@@ -164,12 +278,9 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		return dns.RcodeSuccess, nil
 	}
 
-	d.mu.RLock()
-	ips, ok := d.records[qname]
-	srvs, srvOk := d.srvs[qname]
-	cnameTarget, cnameOk := d.cnames[qname]
-	txts, txtOk := d.txts[qname]
-	if !ok && !srvOk && !cnameOk && !txtOk {
+	answers, ok := snap.answers.lookup(qname)
+	matched := qname
+	if !ok {
 		// Try wildcard: replace the first non-underscore label with *
 		// This handles both plain names (foo.web.docker. → *.web.docker.)
 		// and SRV-prefixed names (_http._tcp.foo.web.docker. → _http._tcp.*.web.docker.)
@@ -183,20 +294,17 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 			copy(wildcardLabels, labels)
 			wildcardLabels[wildcardIdx] = "*"
 			wildcardName := strings.Join(wildcardLabels, ".") + "."
-			ips, ok = d.records[wildcardName]
-			srvs, srvOk = d.srvs[wildcardName]
-			cnameTarget, cnameOk = d.cnames[wildcardName]
-			txts, txtOk = d.txts[wildcardName]
-			if ok || srvOk || cnameOk || txtOk {
+			answers, ok = snap.answers.lookup(wildcardName)
+			if ok {
 				log.Debugf("Wildcard match for %s via %s", qname, wildcardName)
+				matched = wildcardName
 			}
 		}
 	}
-	isConnected := d.connected
-	d.mu.RUnlock()
-	log.Debugf("Lookup results for %s: A/AAAA records=%d, SRV records=%d, CNAME=%t, TXT records=%d, connected=%t", qname, len(ips), len(srvs), cnameOk, len(txts), isConnected)
+	isConnected := snap.fresh(matched)
+	log.Debugf("Lookup results for %s: A/AAAA records=%d, SRV records=%d, CNAME=%t, TXT records=%d, connected=%t", qname, answers.count(dns.TypeA)+answers.count(dns.TypeAAAA), answers.count(dns.TypeSRV), answers != nil && answers.cname != nil, answers.count(dns.TypeTXT), isConnected)
 
-	if !ok && !srvOk && !cnameOk && !txtOk {
+	if !ok {
 		if d.Fall.Through(qname) {
 			log.Debugf("No records found for %s, falling through to next plugin", qname)
 			requestFallthroughCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
@@ -208,7 +316,8 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		m.SetReply(r)
 		m.Authoritative = true
 		m.Rcode = dns.RcodeNameError
-		m.Ns = []dns.RR{d.soa(zone)}
+		m.Ns = []dns.RR{d.negativeSOA(snap, zone)}
+		d.secure(state, zone, m, nil)
 		if err := w.WriteMsg(m); err != nil {
 			log.Errorf("Failed to write message: %v", err)
 			requestFailedCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
@@ -230,71 +339,18 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		requestStaleCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
 	}
 
-	header := dns.RR_Header{
-		Name:   state.QName(),
-		Rrtype: qtype,
-		Class:  dns.ClassINET,
-		Ttl:    ttl,
-	}
-
-	found := false
-	if cnameOk {
-		// A CNAME name has no other record types (RFC 1034). Return the
-		// CNAME for any query type — the resolver will re-query for the
-		// target if it wants A/AAAA. Use a CNAME-typed header so the RR
-		// serializes correctly regardless of the original qtype.
-		m.Answer = append(m.Answer, &dns.CNAME{
-			Hdr: dns.RR_Header{
-				Name:   state.QName(),
-				Rrtype: dns.TypeCNAME,
-				Class:  dns.ClassINET,
-				Ttl:    ttl,
-			},
-			Target: cnameTarget,
-		})
-		found = true
-	} else if qtype == dns.TypeSRV {
-		for _, srv := range srvs {
-			m.Answer = append(m.Answer, &dns.SRV{
-				Hdr:      header,
-				Priority: 10,
-				Weight:   10,
-				Port:     srv.port,
-				Target:   srv.target,
-			})
-			found = true
-		}
-	} else if qtype == dns.TypeTXT {
-		for _, strs := range txts {
-			m.Answer = append(m.Answer, &dns.TXT{
-				Hdr: header,
-				Txt: strs,
-			})
-			found = true
-		}
-	} else {
-		for _, ip := range ips {
-			if qtype == dns.TypeA && ip.To4() != nil {
-				m.Answer = append(m.Answer, &dns.A{
-					Hdr: header,
-					A:   ip,
-				})
-				found = true
-			} else if qtype == dns.TypeAAAA && ip.To4() == nil {
-				m.Answer = append(m.Answer, &dns.AAAA{
-					Hdr:  header,
-					AAAA: ip,
-				})
-				found = true
-			}
-		}
-	}
+	// A CNAME name has no other record types (RFC 1034), so its CNAME
+	// answers any query type; the resolver re-queries for the target if
+	// it wants A/AAAA.
+	m.Answer = patchedRRs(answers.answers(qtype), state.QName(), ttl)
+	found := len(m.Answer) > 0
 	log.Debugf("Response for %s %s: %d answer(s)", qname, dns.TypeToString[qtype], len(m.Answer))
 
 	if !found && (qtype == dns.TypeA || qtype == dns.TypeAAAA || qtype == dns.TypeSRV || qtype == dns.TypeTXT) {
 		// NODATA
 		log.Debugf("NODATA response for %s type %s: name exists but no matching records", qname, dns.TypeToString[qtype])
-		m.Ns = []dns.RR{d.soa(zone)}
+		m.Ns = []dns.RR{d.negativeSOA(snap, zone)}
+		d.secure(state, zone, m, answers)
 		if err := w.WriteMsg(m); err != nil {
 			log.Errorf("Failed to write message: %v", err)
 			requestFailedCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
@@ -313,7 +369,8 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 
 		// NODATA: name exists but no records for this query type
 		log.Debugf("No handler for type %s on %s, returning NODATA", dns.TypeToString[qtype], qname)
-		m.Ns = []dns.RR{d.soa(zone)}
+		m.Ns = []dns.RR{d.negativeSOA(snap, zone)}
+		d.secure(state, zone, m, answers)
 		if err := w.WriteMsg(m); err != nil {
 			log.Errorf("Failed to write message: %v", err)
 			requestFailedCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
@@ -323,6 +380,7 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		return dns.RcodeSuccess, nil
 	}
 
+	d.secure(state, zone, m, answers)
 	if err := w.WriteMsg(m); err != nil {
 		log.Errorf("Failed to write message: %v", err)
 		requestFailedCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
@@ -335,128 +393,178 @@ func (d *Docker) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 // Name implements the Handler interface.
 func (d *Docker) Name() string { return pluginName }
 
-// ns returns a synthetic NS record for the given zone.
-func (d *Docker) ns(zone string) *dns.NS {
-	return &dns.NS{
-		Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: d.ttl},
-		Ns:  "ns.dns." + zone,
+// ns returns the synthetic NS records for the given zone.
+func (d *Docker) ns(zone string) []dns.RR {
+	var rrs []dns.RR
+	for _, name := range d.nameservers(zone) {
+		rrs = append(rrs, &dns.NS{
+			Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: d.ttl},
+			Ns:  name,
+		})
 	}
+	return rrs
 }
 
-// soa returns a synthetic SOA record for the given zone.
-func (d *Docker) soa(zone string) *dns.SOA {
+// soa returns a synthetic SOA record for the given zone and serial.
+func (d *Docker) soa(zone string, serial uint32) *dns.SOA {
+	refresh, retry, expire := d.soaTimers()
 	return &dns.SOA{
 		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: d.ttl},
-		Ns:      "ns.dns." + zone,
-		Mbox:    "hostmaster." + zone,
-		Serial:  uint32(time.Now().Unix()),
-		Refresh: 7200,
-		Retry:   1800,
-		Expire:  86400,
-		Minttl:  d.ttl,
+		Ns:      d.nameservers(zone)[0],
+		Mbox:    d.mbox(zone),
+		Serial:  serial,
+		Refresh: refresh,
+		Retry:   retry,
+		Expire:  expire,
+		Minttl:  d.negTTL(),
 	}
 }
 
-func (d *Docker) startEventLoop(ctx context.Context) {
-	log.Infof("Starting Docker event loop")
-
+// eventFilter returns the Docker events filter the event loop subscribes
+// with: every container lifecycle event that can change a container's
+// names, addresses, or eligibility to be served, plus network
+// connect/disconnect events, which change a container's addresses and
+// aliases without a container event. Podman names some of these events
+// differently; with podman set its names are subscribed to as well.
+func eventFilter(podman bool) filters.Args {
 	filter := filters.NewArgs()
-	filter.Add("type", "container")
-	filter.Add("event", "start")
-	filter.Add("event", "die")
-	filter.Add("event", "destroy")
-	filter.Add("event", "stop")
-	filter.Add("event", "create")
-	filter.Add("event", "restart")
+	filter.Add("type", string(events.ContainerEventType))
+	filter.Add("type", string(events.NetworkEventType))
+	for _, action := range []events.Action{
+		events.ActionStart,
+		events.ActionDie,
+		events.ActionDestroy,
+		events.ActionStop,
+		events.ActionCreate,
+		events.ActionRestart,
+		events.ActionRename,
+		events.ActionPause,
+		events.ActionUnPause,
+		events.ActionHealthStatus,
+		events.ActionConnect,
+		events.ActionDisconnect,
+	} {
+		filter.Add("event", string(action))
+	}
+	if podman {
+		for action := range podmanEventActions {
+			filter.Add("event", string(action))
+		}
+	}
+	return filter
+}
+
+func (d *Docker) startEventLoop(ctx context.Context) {
+	if d.name != "" {
+		log.Infof("Starting Docker event loop for endpoint %s", d.name)
+	} else {
+		log.Infof("Starting Docker event loop")
+	}
 
 	backoff := 1 * time.Second
 
+	var coalescer *eventCoalescer
+	if d.syncDebounce > 0 {
+		coalescer = newEventCoalescer(d.syncDebounce, d.syncMaxDelay)
+		defer coalescer.stop()
+	}
+
 	for {
-		// Sync records whenever we (re)connect
-		d.syncRecords(ctx)
+		// Each connection gets its own context so the heartbeat can tear
+		// down a silent event stream and force a reconnect.
+		connCtx, connCancel := context.WithCancel(ctx)
+
+		// The heartbeat starts before the sync so it can also tear down a
+		// sync stuck on a daemon that stopped answering. Until the sync
+		// succeeds, its failures are not reported: the failed sync already
+		// is, and a daemon that stays down would otherwise be reported by
+		// every beat while the loop backs off.
+		var synced atomic.Bool
+		if d.heartbeatInterval > 0 && d.client != nil {
+			go d.runHeartbeat(connCtx, d.client, &synced, connCancel)
+		}
 
-		msgs, errs := d.client.Events(ctx, events.ListOptions{
-			Filters: filter,
-		})
+		// The daemon behind the endpoint may have changed since the last
+		// connection, so check whether it is Podman before syncing.
+		d.detectBackend(connCtx)
+
+		// Sync records whenever we (re)connect. The daemon only counts as
+		// connected once a sync has succeeded; until then, back off and
+		// try again.
+		if err := d.syncRecords(connCtx); err != nil {
+			connCancel()
+			d.setConnected(false)
+			if !d.waitBackoff(ctx, &backoff) {
+				return
+			}
+			continue
+		}
+		synced.Store(true)
 
-		d.mu.Lock()
-		d.connected = true
-		d.mu.Unlock()
-		connectedGauge.Set(1)
+		evs, errs := d.containerSource().Watch(connCtx)
+
+		d.setConnected(true)
 		log.Debugf("Connected to Docker daemon")
 
 		stopped := false
 		for !stopped {
 			select {
 			case <-ctx.Done():
+				connCancel()
 				return
 			case err := <-errs:
 				if err != nil && err != context.Canceled {
 					log.Errorf("Docker event error: %v", err)
 				}
-				d.mu.Lock()
-				d.connected = false
-				d.mu.Unlock()
-				connectedGauge.Set(0)
+				d.setConnected(false)
 				stopped = true
-			case msg := <-msgs:
-				log.Debugf("Docker event: %s %s %s", msg.Type, msg.Action, msg.Actor.ID)
-				d.syncRecords(ctx)
+			case ev := <-evs:
+				if coalescer != nil {
+					coalescer.add(ev, time.Now())
+				} else {
+					d.applyContainerEvents(connCtx, d.containerSource(), []ContainerEvent{ev})
+				}
 				backoff = 1 * time.Second // Reset backoff on successful event
+			case <-coalescer.C():
+				batch := coalescer.drain()
+				log.Debugf("Applying %d coalesced Docker event(s)", len(batch))
+				syncCoalescedCount.Add(float64(len(batch) - 1))
+				d.applyContainerEvents(connCtx, d.containerSource(), batch)
 			}
 		}
 
-		select {
-		case <-ctx.Done():
+		connCancel()
+
+		// Events queued before the disconnect are covered by the full
+		// sync that runs on reconnect.
+		if coalescer != nil {
+			coalescer.stop()
+		}
+
+		if !d.waitBackoff(ctx, &backoff) {
 			return
-		case <-time.After(backoff):
-			log.Infof("Attempting to reconnect to Docker daemon after %v...", backoff)
-			backoff *= 2
-			if backoff > d.maxBackoff {
-				backoff = d.maxBackoff
-			}
 		}
 	}
 }
 
-func (d *Docker) syncRecords(ctx context.Context) {
-	syncStart := time.Now()
-	containers, err := d.client.ContainerList(ctx, container.ListOptions{})
-	if err != nil {
-		log.Errorf("Failed to list containers: %v", err)
-		syncErrorCount.Inc()
-		return
+// waitBackoff waits out the current reconnect backoff and doubles it, up
+// to max_backoff. It returns false if ctx is cancelled first.
+func (d *Docker) waitBackoff(ctx context.Context, backoff *time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(*backoff):
+		if d.name != "" {
+			log.Infof("Attempting to reconnect to Docker endpoint %s after %v...", d.name, *backoff)
+		} else {
+			log.Infof("Attempting to reconnect to Docker daemon after %v...", *backoff)
+		}
+		*backoff *= 2
+		if *backoff > d.maxBackoff {
+			*backoff = d.maxBackoff
+		}
+		return true
 	}
-	log.Debugf("Found %d running containers", len(containers))
-
-	newRecords, newSrvs, newPtrs, newCnames, newTxts := generateRecords(ctx, GenerateRecordsInput{
-		Containers:    containers,
-		Zones:         d.zones,
-		Inspector:     d.client,
-		LabelPrefix:   d.labelPrefix,
-		Networks:      d.networks,
-		HostMode:      d.hostMode,
-		HostModePTR:   d.hostModePTR,
-		NameTemplates: d.nameTemplates,
-	})
-
-	d.mu.Lock()
-	d.records = newRecords
-	d.srvs = newSrvs
-	d.ptrs = newPtrs
-	d.cnames = newCnames
-	d.txts = newTxts
-	d.lastSyncTime = time.Now()
-	d.mu.Unlock()
-	lastSyncTimestamp.Set(float64(time.Now().Unix()))
-	syncDuration.Observe(time.Since(syncStart).Seconds())
-	recordsCount.Set(float64(len(newRecords)))
-	srvRecordsCount.Set(float64(len(newSrvs)))
-	ptrRecordsCount.Set(float64(len(newPtrs)))
-	cnameRecordsCount.Set(float64(len(newCnames)))
-	txtRecordsCount.Set(float64(len(newTxts)))
-	containersCount.Set(float64(len(containers)))
-	log.Debugf("Synced %d records, %d SRV records, %d PTR records, %d CNAME records, and %d TXT records", len(newRecords), len(newSrvs), len(newPtrs), len(newCnames), len(newTxts))
 }
 
 // ContainerInspector is an interface for inspecting containers.
@@ -464,11 +572,27 @@ type ContainerInspector interface {
 	ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error)
 }
 
+// daemonClient is the subset of the Docker client the event loop, syncs
+// and heartbeat use. The Docker client implements it directly;
+// containerdClient adapts containerd to it.
+type daemonClient interface {
+	ContainerInspector
+	daemonProber
+	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
+	ServerVersion(ctx context.Context) (types.Version, error)
+	Close() error
+}
+
 // GenerateRecordsInput is the input for the generateRecords function.
 type GenerateRecordsInput struct {
-	// Client is the Docker client to use.
-	Inspector ContainerInspector
-	// Containers is the list of containers to generate records for.
+	// Source inspects the containers to generate records for.
+	Source ContainerSource
+	// Refs is the list of containers to generate records for.
+	Refs []ContainerRef
+	// Inspector and Containers are the Docker form of Source and Refs,
+	// used when Source is nil.
+	Inspector  ContainerInspector
 	Containers []container.Summary
 	// Zones is the list of domains to generate records for.
 	Zones []string
@@ -490,6 +614,16 @@ type GenerateRecordsInput struct {
 	// joins the container's name set alongside container name, network
 	// aliases, DNSNames, Compose project.service, and hostname labels.
 	NameTemplates []*template.Template
+	// LinkLocal additionally publishes the link-local addresses configured
+	// on each network endpoint (IPAMConfig.LinkLocalIPs). Off by default
+	// because link-local addresses are only reachable from the same link.
+	LinkLocal bool
+	// Concurrency caps how many containers are inspected in parallel.
+	// Zero or one inspects them one at a time.
+	Concurrency int
+	// Cache, when set, serves inspected containers whose fingerprint has
+	// not changed since they were last inspected.
+	Cache *inspectCache
 }
 
 // unescapeTxtCharString processes RFC 1035 §5.1 character-string
@@ -572,538 +706,589 @@ func parseTxtValue(v string) []string {
 
 // generateRecords generates the records for the containers.
 func generateRecords(ctx context.Context, input GenerateRecordsInput) (map[string][]net.IP, map[string][]srvRecord, map[string][]string, map[string]string, map[string][][]string) {
-	newRecords := make(map[string][]net.IP)
-	newSrvs := make(map[string][]srvRecord)
-	newPtrs := make(map[string][]string)
-	newCnames := make(map[string]string)
-	newTxts := make(map[string][][]string)
-
-	for _, c := range input.Containers {
-		inspect, err := input.Inspector.ContainerInspect(ctx, c.ID)
-		if err != nil {
-			log.Errorf("Failed to inspect container %s: %v", c.ID, err)
+	order, sets := generateRecordSets(ctx, input)
+	merged := mergeRecordSets(order, sets)
+	return merged.records, merged.srvs, merged.ptrs, merged.cnames, merged.txts
+}
+
+// generateRecordSets inspects every container in the input and returns
+// each container's records keyed by container ID, along with the IDs in
+// input order. Containers that fail inspection are left out; containers
+// that are inspected but produce no records map to a nil set.
+func generateRecordSets(ctx context.Context, input GenerateRecordsInput) ([]string, map[string]*recordSet) {
+	if input.Source == nil {
+		src := &dockerSource{inspector: input.Inspector}
+		input.Source, input.Refs = src, src.refs(input.Containers)
+	}
+	order := make([]string, 0, len(input.Refs))
+	sets := make(map[string]*recordSet, len(input.Refs))
+	results := inspectContainers(ctx, input)
+	for i, result := range results {
+		id := input.Refs[i].ID
+		if result.err != nil {
+			log.Errorf("Failed to inspect container %s: %v", id, result.err)
 			continue
 		}
+		order = append(order, id)
+		sets[id] = containerRecords(result.container, input)
+	}
+	return order, sets
+}
 
-		// Guard against nil pointer fields in the inspect response
-		if inspect.ContainerJSONBase == nil || inspect.ContainerJSONBase.HostConfig == nil {
-			log.Debugf("Container %s has incomplete inspect response, skipping", c.ID)
-			continue
+// composeProjectService returns a container's Compose project and service
+// from the Docker Compose labels, falling back to the ones podman-compose
+// sets.
+func composeProjectService(labels map[string]string) (project, service string) {
+	project = labels["com.docker.compose.project"]
+	service = labels["com.docker.compose.service"]
+	if project == "" && service == "" {
+		project = labels["io.podman.compose.project"]
+		service = labels["io.podman.compose.service"]
+	}
+	return project, service
+}
+
+// containerRecords generates the records a single inspected container
+// contributes. It returns nil when the container produces no records
+// (no networks, not on a selected network, and so on).
+func containerRecords(c Container, input GenerateRecordsInput) *recordSet {
+	id := c.ID
+	if c.Networks == nil {
+		log.Debugf("Container %s has no network settings, skipping", id)
+		return nil
+	}
+
+	// Paused containers cannot answer traffic, so they are not published.
+	if c.Paused {
+		log.Debugf("Container %s is paused, skipping", id)
+		return nil
+	}
+
+	primaryNetworkName := c.PrimaryNetwork
+
+	// Determine which networks to process
+	type networkEntry struct {
+		name     string
+		settings ContainerNetwork
+	}
+	var networksToProcess []networkEntry
+
+	if len(input.Networks) > 0 {
+		// Filter mode: check ALL attached networks against the allowed list
+		allowedSet := make(map[string]bool, len(input.Networks))
+		for _, n := range input.Networks {
+			allowedSet[n] = true
 		}
-		if inspect.NetworkSettings == nil || inspect.NetworkSettings.Networks == nil {
-			log.Debugf("Container %s has no network settings, skipping", c.ID)
-			continue
+		// Sort network names for deterministic output
+		netNames := make([]string, 0, len(c.Networks))
+		for netName := range c.Networks {
+			netNames = append(netNames, netName)
 		}
-		if inspect.Config == nil {
-			inspect.Config = &container.Config{Labels: map[string]string{}}
+		sort.Strings(netNames)
+		for _, netName := range netNames {
+			if allowedSet[netName] {
+				networksToProcess = append(networksToProcess, networkEntry{name: netName, settings: c.Networks[netName]})
+			}
 		}
-
-		// Determine the primary network name from NetworkMode
-		primaryNetworkName := string(inspect.HostConfig.NetworkMode)
-		if primaryNetworkName == "" || primaryNetworkName == "default" {
-			primaryNetworkName = "bridge"
+		if len(networksToProcess) == 0 {
+			log.Debugf("Container %s not on any allowed network", id)
+			return nil
 		}
-
-		// Determine which networks to process
-		type networkEntry struct {
-			name     string
-			settings *network.EndpointSettings
+	} else {
+		// No filter: use the primary network only
+		netSettings, ok := c.Networks[primaryNetworkName]
+		if !ok {
+			log.Debugf("Container %s not on network %s", id, primaryNetworkName)
+			return nil
 		}
-		var networksToProcess []networkEntry
+		networksToProcess = []networkEntry{{name: primaryNetworkName, settings: netSettings}}
+	}
 
-		if len(input.Networks) > 0 {
-			// Filter mode: check ALL attached networks against the allowed list
-			allowedSet := make(map[string]bool, len(input.Networks))
-			for _, n := range input.Networks {
-				allowedSet[n] = true
-			}
-			// Sort network names for deterministic output
-			netNames := make([]string, 0, len(inspect.NetworkSettings.Networks))
-			for netName := range inspect.NetworkSettings.Networks {
-				netNames = append(netNames, netName)
-			}
-			sort.Strings(netNames)
-			for _, netName := range netNames {
-				netSettings := inspect.NetworkSettings.Networks[netName]
-				if allowedSet[netName] && netSettings != nil {
-					networksToProcess = append(networksToProcess, networkEntry{name: netName, settings: netSettings})
-				}
-			}
-			if len(networksToProcess) == 0 {
-				log.Debugf("Container %s not on any allowed network", c.ID)
-				continue
+	rs := newRecordSet()
+
+	// Compute per-container data once
+	baseName := c.Name
+
+	project, service := composeProjectService(c.Labels)
+
+	// Parse hostname label for additional DNS names
+	hostnameLabel := input.LabelPrefix + "/hostname"
+	if input.LabelPrefix == "" {
+		hostnameLabel = "hostname"
+	}
+	var hostnameNames []string
+	if hostnameValue, ok := c.Labels[hostnameLabel]; ok && hostnameValue != "" {
+		for _, h := range strings.Split(hostnameValue, ",") {
+			h = strings.TrimSpace(h)
+			if h != "" {
+				hostnameNames = append(hostnameNames, h)
 			}
-		} else {
-			// No filter: use the primary network only
-			netSettings, ok := inspect.NetworkSettings.Networks[primaryNetworkName]
-			if !ok || netSettings == nil {
-				log.Debugf("Container %s not on network %s", c.ID, primaryNetworkName)
-				continue
+		}
+	}
+
+	// Render every configured name_from_labels template against this
+	// container's labels. Results join the same name set as container
+	// name, network aliases, DNSNames, Compose project.service, and
+	// hostname-label names; the existing case-insensitive dedup below
+	// drops overlap.
+	templatedNames := renderNameTemplates(input.NameTemplates, baseName, id, c.Labels)
+
+	// Parse wildcard label
+	wildcardLabel := input.LabelPrefix + "/wildcard"
+	if input.LabelPrefix == "" {
+		wildcardLabel = "wildcard"
+	}
+	enableWildcard := c.Labels[wildcardLabel] == "true"
+
+	// Parse cname label. If set, the container is fully aliased to the
+	// target: we emit only CNAME records for all of its names, and skip
+	// A/AAAA, SRV, and PTR records (RFC 1034 disallows a CNAME name from
+	// having other record types).
+	cnameLabel := input.LabelPrefix + "/cname"
+	if input.LabelPrefix == "" {
+		cnameLabel = "cname"
+	}
+	var containerCname string
+	if raw, ok := c.Labels[cnameLabel]; ok {
+		trimmed := strings.TrimSpace(raw)
+		if trimmed != "" {
+			lower := strings.ToLower(trimmed)
+			if !strings.HasSuffix(lower, ".") {
+				lower += "."
 			}
-			networksToProcess = []networkEntry{{name: primaryNetworkName, settings: netSettings}}
+			containerCname = lower
 		}
+	}
 
-		// Compute per-container data once
-		baseName := strings.TrimPrefix(inspect.Name, "/")
+	// Parse TXT labels. Two forms are supported:
+	//   <prefix>/txt=<value>        -> TXT at the container's own FQDN
+	//   <prefix>/txt.<key>=<value>  -> TXT at <key>.<container>.<zone>
+	// Values starting with `"` are parsed as RFC 1035 master-file TXT
+	// rdata (multiple character-strings, backslash escapes); all other
+	// values are stored verbatim as a single character-string. See
+	// parseTxtValue for details.
+	txtPrefix := input.LabelPrefix + "/txt"
+	if input.LabelPrefix == "" {
+		txtPrefix = "txt"
+	}
+	containerTxts := make(map[string][][]string)
+	for _, k := range slices.Sorted(maps.Keys(c.Labels)) {
+		v := c.Labels[k]
+		if k == txtPrefix {
+			containerTxts[""] = append(containerTxts[""], parseTxtValue(v))
+			continue
+		}
+		if !strings.HasPrefix(k, txtPrefix+".") {
+			continue
+		}
+		suffix := strings.TrimPrefix(k, txtPrefix+".")
+		if suffix == "" {
+			continue
+		}
+		key := strings.ToLower(suffix)
+		containerTxts[key] = append(containerTxts[key], parseTxtValue(v))
+	}
 
-		project := inspect.Config.Labels["com.docker.compose.project"]
-		service := inspect.Config.Labels["com.docker.compose.service"]
+	// Add SRV records based on labels
+	srvPrefix := input.LabelPrefix + "/srv."
+	if input.LabelPrefix == "" {
+		srvPrefix = "srv."
+	}
 
-		// Parse hostname label for additional DNS names
-		hostnameLabel := input.LabelPrefix + "/hostname"
-		if input.LabelPrefix == "" {
-			hostnameLabel = "hostname"
+	if containerCname != "" {
+		// Union names across all selected networks (same rule as host mode),
+		// because a CNAME has no IP affinity — the destination is the
+		// external name the user asked us to alias to.
+		names := []string{baseName}
+		for _, ne := range networksToProcess {
+			names = append(names, ne.settings.Aliases...)
+			names = append(names, ne.settings.DNSNames...)
 		}
-		var hostnameNames []string
-		if hostnameValue, ok := inspect.Config.Labels[hostnameLabel]; ok && hostnameValue != "" {
-			for _, h := range strings.Split(hostnameValue, ",") {
-				h = strings.TrimSpace(h)
-				if h != "" {
-					hostnameNames = append(hostnameNames, h)
-				}
+		if project != "" && service != "" {
+			names = append(names, project+"."+service)
+		}
+		names = append(names, hostnameNames...)
+		names = append(names, templatedNames...)
+		names = append(names, c.Aliases...)
+
+		seen := make(map[string]bool, len(names))
+		uniqueNames := names[:0]
+		for _, name := range names {
+			lower := strings.ToLower(name)
+			if lower == "" || seen[lower] {
+				continue
 			}
+			seen[lower] = true
+			uniqueNames = append(uniqueNames, name)
 		}
+		names = uniqueNames
 
-		// Render every configured name_from_labels template against this
-		// container's labels. Results join the same name set as container
-		// name, network aliases, DNSNames, Compose project.service, and
-		// hostname-label names; the existing case-insensitive dedup below
-		// drops overlap.
-		templatedNames := renderNameTemplates(input.NameTemplates, baseName, c.ID, inspect.Config.Labels)
-
-		// Parse wildcard label
-		wildcardLabel := input.LabelPrefix + "/wildcard"
-		if input.LabelPrefix == "" {
-			wildcardLabel = "wildcard"
-		}
-		enableWildcard := inspect.Config.Labels[wildcardLabel] == "true"
-
-		// Parse cname label. If set, the container is fully aliased to the
-		// target: we emit only CNAME records for all of its names, and skip
-		// A/AAAA, SRV, and PTR records (RFC 1034 disallows a CNAME name from
-		// having other record types).
-		cnameLabel := input.LabelPrefix + "/cname"
-		if input.LabelPrefix == "" {
-			cnameLabel = "cname"
-		}
-		var containerCname string
-		if raw, ok := inspect.Config.Labels[cnameLabel]; ok {
-			trimmed := strings.TrimSpace(raw)
-			if trimmed != "" {
-				lower := strings.ToLower(trimmed)
-				if !strings.HasSuffix(lower, ".") {
-					lower += "."
+		for _, name := range names {
+			for _, zone := range input.Zones {
+				fqdn := strings.ToLower(name + "." + zone)
+				if !strings.HasSuffix(fqdn, ".") {
+					fqdn += "."
+				}
+				// CNAME targets are last-write-wins if two containers happen
+				// to claim the same name; same semantics as conflicting A
+				// records today.
+				rs.cnames[fqdn] = containerCname
+				if enableWildcard {
+					rs.cnames["*."+fqdn] = containerCname
 				}
-				containerCname = lower
 			}
 		}
 
-		// Parse TXT labels. Two forms are supported:
-		//   <prefix>/txt=<value>        -> TXT at the container's own FQDN
-		//   <prefix>/txt.<key>=<value>  -> TXT at <key>.<container>.<zone>
-		// Values starting with `"` are parsed as RFC 1035 master-file TXT
-		// rdata (multiple character-strings, backslash escapes); all other
-		// values are stored verbatim as a single character-string. See
-		// parseTxtValue for details.
-		txtPrefix := input.LabelPrefix + "/txt"
-		if input.LabelPrefix == "" {
-			txtPrefix = "txt"
-		}
-		containerTxts := make(map[string][][]string)
-		for k, v := range inspect.Config.Labels {
-			if k == txtPrefix {
-				containerTxts[""] = append(containerTxts[""], parseTxtValue(v))
-				continue
-			}
-			if !strings.HasPrefix(k, txtPrefix+".") {
-				continue
-			}
-			suffix := strings.TrimPrefix(k, txtPrefix+".")
-			if suffix == "" {
-				continue
-			}
-			key := strings.ToLower(suffix)
-			containerTxts[key] = append(containerTxts[key], parseTxtValue(v))
-		}
+		return rs
+	}
 
-		// Add SRV records based on labels
-		srvPrefix := input.LabelPrefix + "/srv."
-		if input.LabelPrefix == "" {
-			srvPrefix = "srv."
+	if input.HostMode {
+		// In host mode, IPs and ports come from the container's host port
+		// bindings (Ports) rather than its internal network
+		// IP. This is for setups where CoreDNS runs outside Docker and the
+		// container networks aren't directly reachable.
+		type hostBinding struct {
+			ip   net.IP
+			port uint16
 		}
 
-		if containerCname != "" {
-			// Union names across all selected networks (same rule as host mode),
-			// because a CNAME has no IP affinity — the destination is the
-			// external name the user asked us to alias to.
-			names := []string{baseName}
-			for _, ne := range networksToProcess {
-				names = append(names, ne.settings.Aliases...)
-				names = append(names, ne.settings.DNSNames...)
-			}
-			if project != "" && service != "" {
-				names = append(names, project+"."+service)
-			}
-			names = append(names, hostnameNames...)
-			names = append(names, templatedNames...)
-
-			seen := make(map[string]bool, len(names))
-			uniqueNames := names[:0]
-			for _, name := range names {
-				lower := strings.ToLower(name)
-				if lower == "" || seen[lower] {
+		// Collect bindings keyed by container port spec (e.g. "80/tcp").
+		bindingsByPort := make(map[string][]hostBinding)
+		var uniqueHostIPs []net.IP
+		seenHostIP := make(map[string]bool)
+		for _, portSpec := range sortedPortSpecs(c.Ports) {
+			for _, b := range c.Ports[portSpec] {
+				hostIPStr := b.HostIP
+				if hostIPStr == "" || hostIPStr == "0.0.0.0" {
+					hostIPStr = "127.0.0.1"
+				} else if hostIPStr == "::" {
+					hostIPStr = "::1"
+				}
+				ip := net.ParseIP(hostIPStr)
+				if ip == nil {
+					log.Debugf("Container %s has unparseable host IP %q for port %s", id, b.HostIP, portSpec)
 					continue
 				}
-				seen[lower] = true
-				uniqueNames = append(uniqueNames, name)
-			}
-			names = uniqueNames
-
-			for _, name := range names {
-				for _, zone := range input.Zones {
-					fqdn := strings.ToLower(name + "." + zone)
-					if !strings.HasSuffix(fqdn, ".") {
-						fqdn += "."
-					}
-					// CNAME targets are last-write-wins if two containers happen
-					// to claim the same name; same semantics as conflicting A
-					// records today.
-					newCnames[fqdn] = containerCname
-					if enableWildcard {
-						newCnames["*."+fqdn] = containerCname
-					}
+				hp, err := strconv.Atoi(b.HostPort)
+				if err != nil || hp < 1 || hp > 65535 {
+					log.Debugf("Container %s has invalid host port %q for port %s", id, b.HostPort, portSpec)
+					continue
+				}
+				bindingsByPort[portSpec] = append(bindingsByPort[portSpec], hostBinding{
+					ip:   ip,
+					port: uint16(hp),
+				})
+				key := ip.String()
+				if !seenHostIP[key] {
+					seenHostIP[key] = true
+					uniqueHostIPs = append(uniqueHostIPs, ip)
 				}
 			}
+		}
 
-			continue
+		if len(uniqueHostIPs) == 0 {
+			log.Debugf("Container %s has no host port bindings, skipping in host mode", id)
+			return nil
 		}
 
-		if input.HostMode {
-			// In host mode, IPs and ports come from the container's host port
-			// bindings (NetworkSettings.Ports) rather than its internal network
-			// IP. This is for setups where CoreDNS runs outside Docker and the
-			// container networks aren't directly reachable.
-			type hostBinding struct {
-				ip   net.IP
-				port uint16
-			}
+		// Deterministic IP ordering so record output is stable.
+		sort.Slice(uniqueHostIPs, func(i, j int) bool {
+			return uniqueHostIPs[i].String() < uniqueHostIPs[j].String()
+		})
 
-			// Collect bindings keyed by container port spec (e.g. "80/tcp").
-			bindingsByPort := make(map[string][]hostBinding)
-			var uniqueHostIPs []net.IP
-			seenHostIP := make(map[string]bool)
-			for portSpec, bindings := range inspect.NetworkSettings.Ports {
-				for _, b := range bindings {
-					hostIPStr := b.HostIP
-					if hostIPStr == "" || hostIPStr == "0.0.0.0" {
-						hostIPStr = "127.0.0.1"
-					} else if hostIPStr == "::" {
-						hostIPStr = "::1"
-					}
-					ip := net.ParseIP(hostIPStr)
-					if ip == nil {
-						log.Debugf("Container %s has unparseable host IP %q for port %s", c.ID, b.HostIP, portSpec)
-						continue
-					}
-					hp, err := strconv.Atoi(b.HostPort)
-					if err != nil || hp < 1 || hp > 65535 {
-						log.Debugf("Container %s has invalid host port %q for port %s", c.ID, b.HostPort, portSpec)
-						continue
-					}
-					portStr := string(portSpec)
-					bindingsByPort[portStr] = append(bindingsByPort[portStr], hostBinding{
-						ip:   ip,
-						port: uint16(hp),
-					})
-					key := ip.String()
-					if !seenHostIP[key] {
-						seenHostIP[key] = true
-						uniqueHostIPs = append(uniqueHostIPs, ip)
-					}
-				}
+		// In host mode there is only one logical destination, so union
+		// names across all selected networks.
+		names := []string{baseName}
+		for _, ne := range networksToProcess {
+			names = append(names, ne.settings.Aliases...)
+			names = append(names, ne.settings.DNSNames...)
+		}
+		if project != "" && service != "" {
+			names = append(names, project+"."+service)
+		}
+		names = append(names, hostnameNames...)
+		names = append(names, templatedNames...)
+		names = append(names, c.Aliases...)
+
+		// Deduplicate names (same rules as the default path).
+		seen := make(map[string]bool, len(names))
+		uniqueNames := names[:0]
+		for _, name := range names {
+			lower := strings.ToLower(name)
+			if lower == "" || seen[lower] {
+				continue
 			}
-
-			if len(uniqueHostIPs) == 0 {
-				log.Debugf("Container %s has no host port bindings, skipping in host mode", c.ID)
+			seen[lower] = true
+			uniqueNames = append(uniqueNames, name)
+		}
+		names = uniqueNames
+
+		// Build host-mode SRV entries from labels, translating each label's
+		// container port into its bound host port(s). Multiple bindings for
+		// the same container port produce multiple SRV records.
+		hostSrvs := make(map[string][]uint16)
+		hasSrvLabel := false
+		for _, k := range slices.Sorted(maps.Keys(c.Labels)) {
+			v := c.Labels[k]
+			if !strings.HasPrefix(k, srvPrefix) {
 				continue
 			}
-
-			// Deterministic IP ordering so record output is stable.
-			sort.Slice(uniqueHostIPs, func(i, j int) bool {
-				return uniqueHostIPs[i].String() < uniqueHostIPs[j].String()
-			})
-
-			// In host mode there is only one logical destination, so union
-			// names across all selected networks.
-			names := []string{baseName}
-			for _, ne := range networksToProcess {
-				names = append(names, ne.settings.Aliases...)
-				names = append(names, ne.settings.DNSNames...)
+			parts := strings.Split(strings.TrimPrefix(k, srvPrefix), ".")
+			if len(parts) != 2 {
+				log.Debugf("Container %s has invalid SRV label %s", id, k)
+				continue
 			}
-			if project != "" && service != "" {
-				names = append(names, project+"."+service)
+			containerPort, err := strconv.Atoi(v)
+			if err != nil {
+				log.Debugf("Container %s has invalid SRV port %s", id, v)
+				continue
 			}
-			names = append(names, hostnameNames...)
-			names = append(names, templatedNames...)
-
-			// Deduplicate names (same rules as the default path).
-			seen := make(map[string]bool, len(names))
-			uniqueNames := names[:0]
-			for _, name := range names {
-				lower := strings.ToLower(name)
-				if lower == "" || seen[lower] {
-					continue
-				}
-				seen[lower] = true
-				uniqueNames = append(uniqueNames, name)
+			if containerPort < 1 || containerPort > 65535 {
+				log.Debugf("Container %s has SRV port %d not in valid range 1-65535", id, containerPort)
+				continue
 			}
-			names = uniqueNames
-
-			// Build host-mode SRV entries from labels, translating each label's
-			// container port into its bound host port(s). Multiple bindings for
-			// the same container port produce multiple SRV records.
-			hostSrvs := make(map[string][]uint16)
-			hasSrvLabel := false
-			for k, v := range inspect.Config.Labels {
-				if !strings.HasPrefix(k, srvPrefix) {
-					continue
-				}
-				parts := strings.Split(strings.TrimPrefix(k, srvPrefix), ".")
-				if len(parts) != 2 {
-					log.Debugf("Container %s has invalid SRV label %s", c.ID, k)
-					continue
-				}
-				containerPort, err := strconv.Atoi(v)
+			hasSrvLabel = true
+			// parts[0] = "_tcp"/"_udp", parts[1] = "_http" etc.
+			proto := strings.TrimPrefix(strings.ToLower(parts[0]), "_")
+			lookup := strconv.Itoa(containerPort) + "/" + proto
+			bindingsForPort, ok := bindingsByPort[lookup]
+			if !ok || len(bindingsForPort) == 0 {
+				log.Debugf("Container %s has SRV label %s=%d but no host binding for %s in host mode", id, k, containerPort, lookup)
+				continue
+			}
+			srvKey := strings.ToLower(parts[1] + "." + parts[0])
+			for _, b := range bindingsForPort {
+				hostSrvs[srvKey] = append(hostSrvs[srvKey], b.port)
+			}
+		}
+
+		// Fallback: if no SRV labels were present at all, derive SRV
+		// records from the bound port specs themselves, mirroring the
+		// default code path but using host ports.
+		if !hasSrvLabel {
+			for _, portStr := range sortedPortSpecs(bindingsByPort) {
+				bindings := bindingsByPort[portStr]
+				parts := strings.Split(portStr, "/")
+				cp, err := strconv.Atoi(parts[0])
 				if err != nil {
-					log.Debugf("Container %s has invalid SRV port %s", c.ID, v)
+					log.Debugf("Container %s has invalid port %s", id, portStr)
 					continue
 				}
-				if containerPort < 1 || containerPort > 65535 {
-					log.Debugf("Container %s has SRV port %d not in valid range 1-65535", c.ID, containerPort)
+				if cp < 1 || cp > 65535 {
+					log.Debugf("Container %s has port %d not in valid range 1-65535", id, cp)
 					continue
 				}
-				hasSrvLabel = true
-				// parts[0] = "_tcp"/"_udp", parts[1] = "_http" etc.
-				proto := strings.TrimPrefix(strings.ToLower(parts[0]), "_")
-				lookup := strconv.Itoa(containerPort) + "/" + proto
-				bindingsForPort, ok := bindingsByPort[lookup]
-				if !ok || len(bindingsForPort) == 0 {
-					log.Debugf("Container %s has SRV label %s=%d but no host binding for %s in host mode", c.ID, k, containerPort, lookup)
-					continue
+				var srvKeys []string
+				if len(parts) > 1 {
+					proto := strings.ToLower(parts[1])
+					srvKeys = []string{"_" + proto + "._" + proto}
+				} else {
+					srvKeys = []string{"_tcp._tcp", "_udp._udp"}
 				}
-				srvKey := strings.ToLower(parts[1] + "." + parts[0])
-				for _, b := range bindingsForPort {
-					hostSrvs[srvKey] = append(hostSrvs[srvKey], b.port)
+				for _, srvKey := range srvKeys {
+					for _, b := range bindings {
+						hostSrvs[srvKey] = append(hostSrvs[srvKey], b.port)
+					}
 				}
 			}
+		}
 
-			// Fallback: if no SRV labels were present at all, derive SRV
-			// records from the bound port specs themselves, mirroring the
-			// default code path but using host ports.
-			if !hasSrvLabel {
-				for portStr, bindings := range bindingsByPort {
-					parts := strings.Split(portStr, "/")
-					cp, err := strconv.Atoi(parts[0])
-					if err != nil {
-						log.Debugf("Container %s has invalid port %s", c.ID, portStr)
-						continue
-					}
-					if cp < 1 || cp > 65535 {
-						log.Debugf("Container %s has port %d not in valid range 1-65535", c.ID, cp)
-						continue
-					}
-					var srvKeys []string
-					if len(parts) > 1 {
-						proto := strings.ToLower(parts[1])
-						srvKeys = []string{"_" + proto + "._" + proto}
-					} else {
-						srvKeys = []string{"_tcp._tcp", "_udp._udp"}
-					}
-					for _, srvKey := range srvKeys {
-						for _, b := range bindings {
-							hostSrvs[srvKey] = append(hostSrvs[srvKey], b.port)
-						}
-					}
+		// Several port specs can feed one SRV name; keep its ports in a
+		// fixed order so every build of the container's records is equal.
+		for _, ports := range hostSrvs {
+			slices.Sort(ports)
+		}
+
+		// Emit records for each (name, zone) pair.
+		for _, name := range names {
+			for _, zone := range input.Zones {
+				fqdn := strings.ToLower(name + "." + zone)
+				if !strings.HasSuffix(fqdn, ".") {
+					fqdn += "."
 				}
-			}
 
-			// Emit records for each (name, zone) pair.
-			for _, name := range names {
-				for _, zone := range input.Zones {
-					fqdn := strings.ToLower(name + "." + zone)
-					if !strings.HasSuffix(fqdn, ".") {
-						fqdn += "."
+				for _, ip := range uniqueHostIPs {
+					if !slices.ContainsFunc(rs.records[fqdn], ip.Equal) {
+						rs.records[fqdn] = append(rs.records[fqdn], ip)
 					}
-
-					for _, ip := range uniqueHostIPs {
-						if !slices.ContainsFunc(newRecords[fqdn], ip.Equal) {
-							newRecords[fqdn] = append(newRecords[fqdn], ip)
-						}
-						if enableWildcard {
-							wildcardFqdn := "*." + fqdn
-							if !slices.ContainsFunc(newRecords[wildcardFqdn], ip.Equal) {
-								newRecords[wildcardFqdn] = append(newRecords[wildcardFqdn], ip)
-							}
+					if enableWildcard {
+						wildcardFqdn := "*." + fqdn
+						if !slices.ContainsFunc(rs.records[wildcardFqdn], ip.Equal) {
+							rs.records[wildcardFqdn] = append(rs.records[wildcardFqdn], ip)
 						}
+					}
 
-						if input.HostModePTR {
-							arpa, arpaErr := dns.ReverseAddr(ip.String())
-							if arpaErr == nil && !slices.Contains(newPtrs[arpa], fqdn) {
-								newPtrs[arpa] = append(newPtrs[arpa], fqdn)
-							}
+					if input.HostModePTR {
+						arpa, arpaErr := dns.ReverseAddr(ip.String())
+						if arpaErr == nil && !slices.Contains(rs.ptrs[arpa], fqdn) {
+							rs.ptrs[arpa] = append(rs.ptrs[arpa], fqdn)
 						}
 					}
+				}
 
-					for srvKey, ports := range hostSrvs {
-						srvName := srvKey + "." + fqdn
-						for _, port := range ports {
-							isDupSrv := slices.ContainsFunc(newSrvs[srvName], func(existing srvRecord) bool {
+				for srvKey, ports := range hostSrvs {
+					srvName := srvKey + "." + fqdn
+					for _, port := range ports {
+						isDupSrv := slices.ContainsFunc(rs.srvs[srvName], func(existing srvRecord) bool {
+							return existing.target == fqdn && existing.port == port
+						})
+						if !isDupSrv {
+							rs.srvs[srvName] = append(rs.srvs[srvName], srvRecord{
+								target: fqdn,
+								port:   port,
+							})
+						}
+
+						if enableWildcard {
+							wildcardSrvName := srvKey + ".*." + fqdn
+							isDupWildcardSrv := slices.ContainsFunc(rs.srvs[wildcardSrvName], func(existing srvRecord) bool {
 								return existing.target == fqdn && existing.port == port
 							})
-							if !isDupSrv {
-								newSrvs[srvName] = append(newSrvs[srvName], srvRecord{
+							if !isDupWildcardSrv {
+								rs.srvs[wildcardSrvName] = append(rs.srvs[wildcardSrvName], srvRecord{
 									target: fqdn,
 									port:   port,
 								})
 							}
-
-							if enableWildcard {
-								wildcardSrvName := srvKey + ".*." + fqdn
-								isDupWildcardSrv := slices.ContainsFunc(newSrvs[wildcardSrvName], func(existing srvRecord) bool {
-									return existing.target == fqdn && existing.port == port
-								})
-								if !isDupWildcardSrv {
-									newSrvs[wildcardSrvName] = append(newSrvs[wildcardSrvName], srvRecord{
-										target: fqdn,
-										port:   port,
-									})
-								}
-							}
 						}
 					}
+				}
 
-					for txtKey, rrs := range containerTxts {
-						var txtFqdn string
+				for txtKey, rrs := range containerTxts {
+					var txtFqdn string
+					if txtKey == "" {
+						txtFqdn = fqdn
+					} else {
+						txtFqdn = txtKey + "." + fqdn
+					}
+					rs.txts[txtFqdn] = append(rs.txts[txtFqdn], rrs...)
+					if enableWildcard {
+						var wildcardTxtFqdn string
 						if txtKey == "" {
-							txtFqdn = fqdn
+							wildcardTxtFqdn = "*." + fqdn
 						} else {
-							txtFqdn = txtKey + "." + fqdn
-						}
-						newTxts[txtFqdn] = append(newTxts[txtFqdn], rrs...)
-						if enableWildcard {
-							var wildcardTxtFqdn string
-							if txtKey == "" {
-								wildcardTxtFqdn = "*." + fqdn
-							} else {
-								wildcardTxtFqdn = txtKey + ".*." + fqdn
-							}
-							newTxts[wildcardTxtFqdn] = append(newTxts[wildcardTxtFqdn], rrs...)
+							wildcardTxtFqdn = txtKey + ".*." + fqdn
 						}
+						rs.txts[wildcardTxtFqdn] = append(rs.txts[wildcardTxtFqdn], rrs...)
 					}
 				}
 			}
+		}
 
+		return rs
+	}
+
+	containerSrvs := make(map[string]uint16)
+	for _, k := range slices.Sorted(maps.Keys(c.Labels)) {
+		v := c.Labels[k]
+		if !strings.HasPrefix(k, srvPrefix) {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(k, srvPrefix), ".")
+		if len(parts) != 2 {
+			log.Debugf("Container %s has invalid SRV label %s", id, k)
+			continue
+		}
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			log.Debugf("Container %s has invalid SRV port %s", id, v)
+			continue
+		}
+		if port < 1 || port > 65535 {
+			log.Debugf("Container %s has SRV port %d not in valid range 1-65535", id, port)
 			continue
 		}
 
-		containerSrvs := make(map[string]uint16)
-		for k, v := range inspect.Config.Labels {
-			if !strings.HasPrefix(k, srvPrefix) {
-				continue
-			}
-			parts := strings.Split(strings.TrimPrefix(k, srvPrefix), ".")
-			if len(parts) != 2 {
-				log.Debugf("Container %s has invalid SRV label %s", c.ID, k)
-				continue
+		// key: _service._proto
+		containerSrvs[strings.ToLower(parts[1]+"."+parts[0])] = uint16(port)
+	}
+
+	// Fallback to the container's ports if no labels found. An SRV name
+	// holds a single port here, so the lowest port of each protocol is
+	// used.
+	if len(containerSrvs) == 0 {
+		setSrv := func(key string, port uint16) {
+			if _, ok := containerSrvs[key]; !ok {
+				containerSrvs[key] = port
 			}
-			port, err := strconv.Atoi(v)
+		}
+		for _, portStr := range sortedPortSpecs(c.Ports) {
+			parts := strings.Split(portStr, "/")
+			port, err := strconv.Atoi(parts[0])
 			if err != nil {
-				log.Debugf("Container %s has invalid SRV port %s", c.ID, v)
+				log.Debugf("Container %s has invalid port %s", id, portStr)
 				continue
 			}
 			if port < 1 || port > 65535 {
-				log.Debugf("Container %s has SRV port %d not in valid range 1-65535", c.ID, port)
+				log.Debugf("Container %s has port %d not in valid range 1-65535", id, port)
 				continue
 			}
 
-			// key: _service._proto
-			containerSrvs[strings.ToLower(parts[1]+"."+parts[0])] = uint16(port)
+			if len(parts) > 1 {
+				// key: _proto._proto
+				proto := strings.ToLower(parts[1])
+				setSrv("_"+proto+"._"+proto, uint16(port))
+			} else {
+				setSrv("_tcp._tcp", uint16(port))
+				setSrv("_udp._udp", uint16(port))
+			}
 		}
+	}
 
-		// Fallback to NetworkSettings.Ports if no labels found
-		if len(containerSrvs) == 0 {
-			for p := range inspect.NetworkSettings.Ports {
-				portStr := string(p)
-				parts := strings.Split(portStr, "/")
-				port, err := strconv.Atoi(parts[0])
-				if err != nil {
-					log.Debugf("Container %s has invalid port %s", c.ID, portStr)
-					continue
-				}
-				if port < 1 || port > 65535 {
-					log.Debugf("Container %s has port %d not in valid range 1-65535", c.ID, port)
-					continue
-				}
-
-				if len(parts) > 1 {
-					// key: _proto._proto
-					proto := strings.ToLower(parts[1])
-					containerSrvs["_"+proto+"._"+proto] = uint16(port)
-				} else {
-					containerSrvs["_tcp._tcp"] = uint16(port)
-					containerSrvs["_udp._udp"] = uint16(port)
-				}
-			}
+	// Track which FQDNs we have already emitted TXT records for within
+	// this container, so that a multi-network container does not
+	// emit the same TXT RRs twice for a name that appears on every
+	// network (e.g. the container's base name). TXT records have no
+	// natural dedup key (unlike A/SRV which dedup by IP/port), so we
+	// rely on a per-container set here. Different containers claiming
+	// the same FQDN still accumulate independently.
+	txtEmitted := make(map[string]bool)
+
+	// Generate records for each matching network
+	for _, ne := range networksToProcess {
+		ips := endpointIPs(ne.settings, input.LinkLocal)
+		if len(ips) == 0 {
+			log.Debugf("Container %s has no valid IP address on network %s (IPv4 %q, IPv6 %q)", id, ne.name, ne.settings.IPAddress, ne.settings.GlobalIPv6Address)
+			continue
 		}
 
-		// Track which FQDNs we have already emitted TXT records for within
-		// this container, so that a multi-network container does not
-		// emit the same TXT RRs twice for a name that appears on every
-		// network (e.g. the container's base name). TXT records have no
-		// natural dedup key (unlike A/SRV which dedup by IP/port), so we
-		// rely on a per-container set here. Different containers claiming
-		// the same FQDN still accumulate independently.
-		txtEmitted := make(map[string]bool)
+		names := []string{baseName}
+		names = append(names, ne.settings.Aliases...)
+		names = append(names, ne.settings.DNSNames...)
 
-		// Generate records for each matching network
-		for _, ne := range networksToProcess {
-			ip := net.ParseIP(ne.settings.IPAddress)
-			if ip == nil {
-				log.Debugf("Container %s has invalid IP address %s on network %s", c.ID, ne.settings.IPAddress, ne.name)
+		if project != "" && service != "" {
+			names = append(names, project+"."+service)
+		}
+
+		names = append(names, hostnameNames...)
+		names = append(names, templatedNames...)
+		names = append(names, c.Aliases...)
+
+		// Deduplicate names (case-insensitive, preserving insertion order) to
+		// avoid producing duplicate records when a container's name overlaps
+		// with its aliases or DNSNames (common on Docker 25+ user-defined
+		// networks where Aliases and DNSNames contain the container name).
+		seen := make(map[string]bool, len(names))
+		uniqueNames := names[:0]
+		for _, name := range names {
+			lower := strings.ToLower(name)
+			if lower == "" || seen[lower] {
 				continue
 			}
+			seen[lower] = true
+			uniqueNames = append(uniqueNames, name)
+		}
+		names = uniqueNames
 
+		// Each address on the endpoint (IPv4, global IPv6, and optionally
+		// link-local) is published under every name. SRV and TXT records
+		// are keyed by name rather than address, so the dedup checks below
+		// keep them from being emitted once per address.
+		for _, ip := range ips {
 			arpa, arpaErr := dns.ReverseAddr(ip.String())
 			if arpaErr != nil {
-				log.Debugf("Container %s has IP %s that cannot be reversed: %v", c.ID, ip.String(), arpaErr)
-			}
-
-			names := []string{baseName}
-			names = append(names, ne.settings.Aliases...)
-			names = append(names, ne.settings.DNSNames...)
-
-			if project != "" && service != "" {
-				names = append(names, project+"."+service)
-			}
-
-			names = append(names, hostnameNames...)
-			names = append(names, templatedNames...)
-
-			// Deduplicate names (case-insensitive, preserving insertion order) to
-			// avoid producing duplicate records when a container's name overlaps
-			// with its aliases or DNSNames (common on Docker 25+ user-defined
-			// networks where Aliases and DNSNames contain the container name).
-			seen := make(map[string]bool, len(names))
-			uniqueNames := names[:0]
-			for _, name := range names {
-				lower := strings.ToLower(name)
-				if lower == "" || seen[lower] {
-					continue
-				}
-				seen[lower] = true
-				uniqueNames = append(uniqueNames, name)
+				log.Debugf("Container %s has IP %s that cannot be reversed: %v", id, ip.String(), arpaErr)
 			}
-			names = uniqueNames
 
 			for _, name := range names {
 				for _, zone := range input.Zones {
@@ -1113,27 +1298,27 @@ func generateRecords(ctx context.Context, input GenerateRecordsInput) (map[strin
 					}
 					// Dedup at the record level to also cover the edge case where
 					// two networks assign the same IP to the same container.
-					if !slices.ContainsFunc(newRecords[fqdn], ip.Equal) {
-						newRecords[fqdn] = append(newRecords[fqdn], ip)
+					if !slices.ContainsFunc(rs.records[fqdn], ip.Equal) {
+						rs.records[fqdn] = append(rs.records[fqdn], ip)
 					}
-					if arpaErr == nil && !slices.Contains(newPtrs[arpa], fqdn) {
-						newPtrs[arpa] = append(newPtrs[arpa], fqdn)
+					if arpaErr == nil && !slices.Contains(rs.ptrs[arpa], fqdn) {
+						rs.ptrs[arpa] = append(rs.ptrs[arpa], fqdn)
 					}
 
 					if enableWildcard {
 						wildcardFqdn := "*." + fqdn
-						if !slices.ContainsFunc(newRecords[wildcardFqdn], ip.Equal) {
-							newRecords[wildcardFqdn] = append(newRecords[wildcardFqdn], ip)
+						if !slices.ContainsFunc(rs.records[wildcardFqdn], ip.Equal) {
+							rs.records[wildcardFqdn] = append(rs.records[wildcardFqdn], ip)
 						}
 					}
 
 					for srvKey, port := range containerSrvs {
 						srvName := srvKey + "." + fqdn
-						isDupSrv := slices.ContainsFunc(newSrvs[srvName], func(existing srvRecord) bool {
+						isDupSrv := slices.ContainsFunc(rs.srvs[srvName], func(existing srvRecord) bool {
 							return existing.target == fqdn && existing.port == port
 						})
 						if !isDupSrv {
-							newSrvs[srvName] = append(newSrvs[srvName], srvRecord{
+							rs.srvs[srvName] = append(rs.srvs[srvName], srvRecord{
 								target: fqdn,
 								port:   port,
 							})
@@ -1141,11 +1326,11 @@ func generateRecords(ctx context.Context, input GenerateRecordsInput) (map[strin
 
 						if enableWildcard {
 							wildcardSrvName := srvKey + ".*." + fqdn
-							isDupWildcardSrv := slices.ContainsFunc(newSrvs[wildcardSrvName], func(existing srvRecord) bool {
+							isDupWildcardSrv := slices.ContainsFunc(rs.srvs[wildcardSrvName], func(existing srvRecord) bool {
 								return existing.target == fqdn && existing.port == port
 							})
 							if !isDupWildcardSrv {
-								newSrvs[wildcardSrvName] = append(newSrvs[wildcardSrvName], srvRecord{
+								rs.srvs[wildcardSrvName] = append(rs.srvs[wildcardSrvName], srvRecord{
 									target: fqdn,
 									port:   port,
 								})
@@ -1161,7 +1346,7 @@ func generateRecords(ctx context.Context, input GenerateRecordsInput) (map[strin
 							txtFqdn = txtKey + "." + fqdn
 						}
 						if !txtEmitted[txtFqdn] {
-							newTxts[txtFqdn] = append(newTxts[txtFqdn], rrs...)
+							rs.txts[txtFqdn] = append(rs.txts[txtFqdn], rrs...)
 							txtEmitted[txtFqdn] = true
 						}
 						if enableWildcard {
@@ -1172,7 +1357,7 @@ func generateRecords(ctx context.Context, input GenerateRecordsInput) (map[strin
 								wildcardTxtFqdn = txtKey + ".*." + fqdn
 							}
 							if !txtEmitted[wildcardTxtFqdn] {
-								newTxts[wildcardTxtFqdn] = append(newTxts[wildcardTxtFqdn], rrs...)
+								rs.txts[wildcardTxtFqdn] = append(rs.txts[wildcardTxtFqdn], rrs...)
 								txtEmitted[wildcardTxtFqdn] = true
 							}
 						}
@@ -1182,5 +1367,51 @@ func generateRecords(ctx context.Context, input GenerateRecordsInput) (map[strin
 		}
 	}
 
-	return newRecords, newSrvs, newPtrs, newCnames, newTxts
+	return rs
+}
+
+// endpointIPs returns the addresses a container has on a single network
+// endpoint: the IPv4 address, the global IPv6 address on IPv6-enabled
+// networks, and, when linkLocal is set, any link-local addresses
+// configured through IPAM. Unparseable and duplicate addresses are
+// dropped, and IPv4 addresses sort before IPv6 ones.
+func endpointIPs(settings ContainerNetwork, linkLocal bool) []net.IP {
+	candidates := []string{settings.IPAddress, settings.GlobalIPv6Address}
+	if linkLocal {
+		candidates = append(candidates, settings.LinkLocalIPs...)
+	}
+
+	var ips []net.IP
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		ip := net.ParseIP(candidate)
+		if ip == nil {
+			continue
+		}
+		if !slices.ContainsFunc(ips, ip.Equal) {
+			ips = append(ips, ip)
+		}
+	}
+	sort.SliceStable(ips, func(i, j int) bool {
+		return ips[i].To4() != nil && ips[j].To4() == nil
+	})
+	return ips
+}
+
+// sortedPortSpecs returns the port specs ("80/tcp", "80") of ports ordered
+// by port number, then by spec, so records built from them do not depend
+// on map order.
+func sortedPortSpecs[V any](ports map[string]V) []string {
+	specs := slices.Collect(maps.Keys(ports))
+	slices.SortFunc(specs, func(a, b string) int {
+		pa, _ := strconv.Atoi(strings.SplitN(a, "/", 2)[0])
+		pb, _ := strconv.Atoi(strings.SplitN(b, "/", 2)[0])
+		if pa != pb {
+			return pa - pb
+		}
+		return strings.Compare(a, b)
+	})
+	return specs
 }
@@ -23,52 +23,63 @@ import (
 	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
+// storeSnapshot precomputes the answers for s the way publishLocked does
+// and makes it d's current snapshot.
+func storeSnapshot(d *Docker, s *snapshot) {
+	s.answers = d.buildAnswers(&s.recordSet)
+	d.snap.Store(s)
+}
+
 func TestDocker(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.":          {net.ParseIP("172.17.0.2")},
-			"db.docker.":           {net.ParseIP("172.17.0.3")},
-			"ipv6.docker.":         {net.ParseIP("2001:db8::1")},
-			"multi.docker.":        {net.ParseIP("172.17.0.4"), net.ParseIP("172.17.0.5")},
-			"myproj.mysvc.docker.": {net.ParseIP("172.17.0.6")},
-		},
-		srvs: map[string][]srvRecord{
-			"_http._tcp.web.docker.": {
-				{target: "web.docker.", port: 80},
-			},
-			"_tcp._tcp.db.docker.": {
-				{target: "db.docker.", port: 5432},
-			},
-			"_udp._udp.db.docker.": {
-				{target: "db.docker.", port: 5432},
-			},
-		},
-		ptrs: map[string][]string{
-			"2.0.17.172.in-addr.arpa.":                                                      {"web.docker."},
-			"3.0.17.172.in-addr.arpa.":                                                      {"db.docker."},
-			"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.": {"ipv6.docker."},
-			"4.0.17.172.in-addr.arpa.":                                                      {"multi.docker."},
-			"5.0.17.172.in-addr.arpa.":                                                      {"multi.docker."},
-			"6.0.17.172.in-addr.arpa.":                                                      {"myproj.mysvc.docker."},
-		},
-		txts: map[string][][]string{
-			// TXT on a name that also has an A record (web.docker.)
-			"web.docker.": {{"v=spf1 -all"}},
-			// Keyed TXT at a TXT-only FQDN — no A record here. Proves that
-			// txtOk participates in the ServeDNS "does this name exist"
-			// check so the query does not fall through to NXDOMAIN.
-			"info.web.docker.": {{"version=1.0.0"}},
-			// Multiple TXT RRs on the same FQDN — response contains N answers.
-			"multi-txt.docker.": {{"one"}, {"two"}},
-			// One TXT RR containing two character-strings — response contains
-			// a single answer whose Txt slice has length 2.
-			"multi-str.docker.": {{"part1", "part2"}},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.":          {net.ParseIP("172.17.0.2")},
+				"db.docker.":           {net.ParseIP("172.17.0.3")},
+				"ipv6.docker.":         {net.ParseIP("2001:db8::1")},
+				"multi.docker.":        {net.ParseIP("172.17.0.4"), net.ParseIP("172.17.0.5")},
+				"myproj.mysvc.docker.": {net.ParseIP("172.17.0.6")},
+			},
+			srvs: map[string][]srvRecord{
+				"_http._tcp.web.docker.": {
+					{target: "web.docker.", port: 80},
+				},
+				"_tcp._tcp.db.docker.": {
+					{target: "db.docker.", port: 5432},
+				},
+				"_udp._udp.db.docker.": {
+					{target: "db.docker.", port: 5432},
+				},
+			},
+			ptrs: map[string][]string{
+				"2.0.17.172.in-addr.arpa.": {"web.docker."},
+				"3.0.17.172.in-addr.arpa.": {"db.docker."},
+				"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.": {"ipv6.docker."},
+				"4.0.17.172.in-addr.arpa.": {"multi.docker."},
+				"5.0.17.172.in-addr.arpa.": {"multi.docker."},
+				"6.0.17.172.in-addr.arpa.": {"myproj.mysvc.docker."},
+			},
+			txts: map[string][][]string{
+				// TXT on a name that also has an A record (web.docker.)
+				"web.docker.": {{"v=spf1 -all"}},
+				// Keyed TXT at a TXT-only FQDN — no A record here. Proves that
+				// txtOk participates in the ServeDNS "does this name exist"
+				// check so the query does not fall through to NXDOMAIN.
+				"info.web.docker.": {{"version=1.0.0"}},
+				// Multiple TXT RRs on the same FQDN — response contains N answers.
+				"multi-txt.docker.": {{"one"}, {"two"}},
+				// One TXT RR containing two character-strings — response contains
+				// a single answer whose Txt slice has length 2.
+				"multi-str.docker.": {{"part1", "part2"}},
+			},
 		},
-	}
+	})
 
 	var cases = []test.Case{
 		{
@@ -326,19 +337,22 @@ func TestDocker(t *testing.T) {
 
 func TestDockerPTR(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
-			"app.docker.": {net.ParseIP("172.17.0.2")},
-		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{
-			"2.0.17.172.in-addr.arpa.": {"web.docker.", "app.docker."},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+				"app.docker.": {net.ParseIP("172.17.0.2")},
+			},
+			ptrs: map[string][]string{
+				"2.0.17.172.in-addr.arpa.": {"web.docker.", "app.docker."},
+			},
 		},
-	}
+	})
 
 	var cases = []test.Case{
 		{
@@ -387,16 +401,18 @@ func TestDockerPTR(t *testing.T) {
 
 func TestDockerPTRStaleTTL(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: false,
-		zones:     []string{"docker."},
-		records:   map[string][]net.IP{},
-		srvs:      map[string][]srvRecord{},
-		ptrs: map[string][]string{
-			"2.0.17.172.in-addr.arpa.": {"web.docker."},
+		recordSet: recordSet{
+			ptrs: map[string][]string{
+				"2.0.17.172.in-addr.arpa.": {"web.docker."},
+			},
 		},
-	}
+	})
 
 	tc := test.Case{
 		Qname: "2.0.17.172.in-addr.arpa.",
@@ -424,16 +440,19 @@ func TestDockerEmptyPrefix(t *testing.T) {
 	d := &Docker{
 		Next:        test.ErrorHandler(),
 		ttl:         DefaultTTL,
-		connected:   true,
 		zones:       []string{"docker."},
 		labelPrefix: "",
-		srvs: map[string][]srvRecord{
-			"_http._tcp.web.docker.": {
-				{target: "web.docker.", port: 80},
+	}
+	storeSnapshot(d, &snapshot{
+		connected: true,
+		recordSet: recordSet{
+			srvs: map[string][]srvRecord{
+				"_http._tcp.web.docker.": {
+					{target: "web.docker.", port: 80},
+				},
 			},
 		},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	tc := test.Case{
 		Qname: "_http._tcp.web.docker.",
@@ -459,25 +478,28 @@ func TestDockerEmptyPrefix(t *testing.T) {
 
 func TestDockerWildcard(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.":   {net.ParseIP("172.17.0.2")},
-			"*.web.docker.": {net.ParseIP("172.17.0.2")},
-			"db.docker.":    {net.ParseIP("172.17.0.3")},
-		},
-		srvs: map[string][]srvRecord{
-			"_http._tcp.web.docker.": {
-				{target: "web.docker.", port: 80},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.":   {net.ParseIP("172.17.0.2")},
+				"*.web.docker.": {net.ParseIP("172.17.0.2")},
+				"db.docker.":    {net.ParseIP("172.17.0.3")},
 			},
-			"_http._tcp.*.web.docker.": {
-				{target: "web.docker.", port: 80},
+			srvs: map[string][]srvRecord{
+				"_http._tcp.web.docker.": {
+					{target: "web.docker.", port: 80},
+				},
+				"_http._tcp.*.web.docker.": {
+					{target: "web.docker.", port: 80},
+				},
 			},
 		},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	var cases = []test.Case{
 		{
@@ -566,21 +588,23 @@ func TestDockerWildcard(t *testing.T) {
 
 func TestDockerCNAME(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"other.docker.": {net.ParseIP("172.17.0.3")},
-		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-		cnames: map[string]string{
-			"web.docker.":     "external.example.com.",
-			"alias.docker.":   "external.example.com.",
-			"*.wild.docker.":  "external.example.com.",
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"other.docker.": {net.ParseIP("172.17.0.3")},
+			},
+			cnames: map[string]string{
+				"web.docker.":    "external.example.com.",
+				"alias.docker.":  "external.example.com.",
+				"*.wild.docker.": "external.example.com.",
+			},
 		},
-	}
+	})
 
 	var cases = []test.Case{
 		{
@@ -666,17 +690,18 @@ func TestDockerCNAME(t *testing.T) {
 
 func TestDockerCNAMEStaleTTL(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: false,
-		zones:     []string{"docker."},
-		records:   map[string][]net.IP{},
-		srvs:      map[string][]srvRecord{},
-		ptrs:      map[string][]string{},
-		cnames: map[string]string{
-			"web.docker.": "external.example.com.",
+		recordSet: recordSet{
+			cnames: map[string]string{
+				"web.docker.": "external.example.com.",
+			},
 		},
-	}
+	})
 
 	tc := test.Case{
 		Qname: "web.docker.",
@@ -702,17 +727,19 @@ func TestDockerCNAMEStaleTTL(t *testing.T) {
 
 func TestDockerFallthrough(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+		Fall:  fall.Root,
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		Fall:      fall.Root,
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	var cases = []test.Case{
 		{
@@ -760,17 +787,19 @@ func TestDockerFallthrough(t *testing.T) {
 
 func TestDockerFallthroughZoneSpecific(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+		Fall:  fall.F{Zones: []string{"other."}},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		Fall:      fall.F{Zones: []string{"other."}},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	// Nonexistent name in docker. zone does NOT match fallthrough zone "other."
 	// so it should return NXDOMAIN, not fall through
@@ -799,24 +828,27 @@ func TestDockerFallthroughZoneSpecific(t *testing.T) {
 
 func TestDockerMultiZone(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker.", "internal."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker.", "internal."},
-		records: map[string][]net.IP{
-			"web.docker.":   {net.ParseIP("172.17.0.2")},
-			"web.internal.": {net.ParseIP("172.17.0.2")},
-		},
-		srvs: map[string][]srvRecord{
-			"_http._tcp.web.docker.": {
-				{target: "web.docker.", port: 80},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.":   {net.ParseIP("172.17.0.2")},
+				"web.internal.": {net.ParseIP("172.17.0.2")},
 			},
-			"_http._tcp.web.internal.": {
-				{target: "web.internal.", port: 80},
+			srvs: map[string][]srvRecord{
+				"_http._tcp.web.docker.": {
+					{target: "web.docker.", port: 80},
+				},
+				"_http._tcp.web.internal.": {
+					{target: "web.internal.", port: 80},
+				},
 			},
 		},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	var cases = []test.Case{
 		{
@@ -887,17 +919,19 @@ func TestDockerMultiZone(t *testing.T) {
 
 func TestDockerSOAMultiZone(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker.", "internal."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker.", "internal."},
-		records: map[string][]net.IP{
-			"web.docker.":   {net.ParseIP("172.17.0.2")},
-			"web.internal.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.":   {net.ParseIP("172.17.0.2")},
+				"web.internal.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	var cases = []test.Case{
 		{
@@ -1005,20 +1039,23 @@ func TestDockerSOAMultiZone(t *testing.T) {
 
 func TestDockerStaleTTL(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: false,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
-		},
-		srvs: map[string][]srvRecord{
-			"_http._tcp.web.docker.": {
-				{target: "web.docker.", port: 80},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
+			srvs: map[string][]srvRecord{
+				"_http._tcp.web.docker.": {
+					{target: "web.docker.", port: 80},
+				},
 			},
 		},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	var cases = []test.Case{
 		{
@@ -1059,16 +1096,18 @@ func TestDockerStaleTTL(t *testing.T) {
 
 func TestDockerStaleTTLLowTTL(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       3,
+		Next:  test.ErrorHandler(),
+		ttl:   3,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: false,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	tc := test.Case{
 		Qname: "web.docker.",
@@ -1094,16 +1133,18 @@ func TestDockerStaleTTLLowTTL(t *testing.T) {
 
 func TestDockerConnectedNormalTTL(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	tc := test.Case{
 		Qname: "web.docker.",
@@ -1136,16 +1177,18 @@ func getHistogramSampleCount(obs prometheus.Observer) uint64 {
 
 func TestServeDNSRequestDurationMetric(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	obs := requestDuration.WithLabelValues("", "A")
 	beforeCount := getHistogramSampleCount(obs)
@@ -1167,16 +1210,18 @@ func TestServeDNSRequestDurationMetric(t *testing.T) {
 
 func TestServeDNSStaleRequestMetric(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: false,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	beforeCount := testutil.ToFloat64(requestStaleCount.WithLabelValues(""))
 
@@ -1197,14 +1242,13 @@ func TestServeDNSStaleRequestMetric(t *testing.T) {
 
 func TestServeDNSNXDOMAINSuccessMetric(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
-		connected: true,
-		zones:     []string{"docker."},
-		records:   map[string][]net.IP{},
-		srvs:      map[string][]srvRecord{},
-		ptrs:      map[string][]string{},
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
 	}
+	storeSnapshot(d, &snapshot{
+		connected: true,
+	})
 
 	beforeSuccess := testutil.ToFloat64(requestSuccessCount.WithLabelValues(""))
 	beforeFailed := testutil.ToFloat64(requestFailedCount.WithLabelValues(""))
@@ -1230,16 +1274,18 @@ func TestServeDNSNXDOMAINSuccessMetric(t *testing.T) {
 
 func TestServeDNSNODATATypeMismatchSuccessMetric(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	beforeSuccess := testutil.ToFloat64(requestSuccessCount.WithLabelValues(""))
 	beforeFailed := testutil.ToFloat64(requestFailedCount.WithLabelValues(""))
@@ -1265,16 +1311,18 @@ func TestServeDNSNODATATypeMismatchSuccessMetric(t *testing.T) {
 
 func TestServeDNSNODATAUnsupportedTypeSuccessMetric(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	beforeSuccess := testutil.ToFloat64(requestSuccessCount.WithLabelValues(""))
 	beforeFailed := testutil.ToFloat64(requestFailedCount.WithLabelValues(""))
@@ -1300,15 +1348,14 @@ func TestServeDNSNODATAUnsupportedTypeSuccessMetric(t *testing.T) {
 
 func TestServeDNSFallthroughNXDOMAINMetric(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
-		connected: true,
-		zones:     []string{"docker."},
-		Fall:      fall.Root,
-		records:   map[string][]net.IP{},
-		srvs:      map[string][]srvRecord{},
-		ptrs:      map[string][]string{},
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+		Fall:  fall.Root,
 	}
+	storeSnapshot(d, &snapshot{
+		connected: true,
+	})
 
 	beforeFallthrough := testutil.ToFloat64(requestFallthroughCount.WithLabelValues(""))
 	beforeSuccess := testutil.ToFloat64(requestSuccessCount.WithLabelValues(""))
@@ -1331,17 +1378,19 @@ func TestServeDNSFallthroughNXDOMAINMetric(t *testing.T) {
 
 func TestServeDNSFallthroughUnsupportedTypeMetric(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+		Fall:  fall.Root,
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		Fall:      fall.Root,
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
 		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{},
-	}
+	})
 
 	beforeFallthrough := testutil.ToFloat64(requestFallthroughCount.WithLabelValues(""))
 
@@ -1359,14 +1408,13 @@ func TestServeDNSFallthroughUnsupportedTypeMetric(t *testing.T) {
 
 func TestServeDNSFallthroughZoneMissMetric(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
-		connected: true,
-		zones:     []string{"docker."},
-		records:   map[string][]net.IP{},
-		srvs:      map[string][]srvRecord{},
-		ptrs:      map[string][]string{},
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
 	}
+	storeSnapshot(d, &snapshot{
+		connected: true,
+	})
 
 	beforeFallthrough := testutil.ToFloat64(requestFallthroughCount.WithLabelValues(""))
 
@@ -1384,14 +1432,13 @@ func TestServeDNSFallthroughZoneMissMetric(t *testing.T) {
 
 func TestServeDNSFallthroughPTRNotFoundMetric(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
-		connected: true,
-		zones:     []string{"docker."},
-		records:   map[string][]net.IP{},
-		srvs:      map[string][]srvRecord{},
-		ptrs:      map[string][]string{},
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
 	}
+	storeSnapshot(d, &snapshot{
+		connected: true,
+	})
 
 	beforeFallthrough := testutil.ToFloat64(requestFallthroughCount.WithLabelValues(""))
 
@@ -1424,16 +1471,18 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
+		}
+		storeSnapshot(d, &snapshot{
 			connected: true,
-			zones:     []string{"docker."},
-			records: map[string][]net.IP{
-				"web.docker.": {net.ParseIP("172.17.0.2")},
+			recordSet: recordSet{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("172.17.0.2")},
+				},
 			},
-			srvs: map[string][]srvRecord{},
-			ptrs: map[string][]string{},
-		}
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("web.docker.", dns.TypeA)
@@ -1450,14 +1499,13 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
-			connected: true,
-			zones:     []string{"docker."},
-			records:   map[string][]net.IP{},
-			srvs:      map[string][]srvRecord{},
-			ptrs:      map[string][]string{},
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
 		}
+		storeSnapshot(d, &snapshot{
+			connected: true,
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("web.other.", dns.TypeA)
@@ -1474,16 +1522,18 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
+		}
+		storeSnapshot(d, &snapshot{
 			connected: true,
-			zones:     []string{"docker."},
-			records: map[string][]net.IP{
-				"web.docker.": {net.ParseIP("172.17.0.2")},
+			recordSet: recordSet{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("172.17.0.2")},
+				},
 			},
-			srvs: map[string][]srvRecord{},
-			ptrs: map[string][]string{},
-		}
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("web.docker.", dns.TypeA)
@@ -1500,14 +1550,13 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
-			connected: true,
-			zones:     []string{"docker."},
-			records:   map[string][]net.IP{},
-			srvs:      map[string][]srvRecord{},
-			ptrs:      map[string][]string{},
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
 		}
+		storeSnapshot(d, &snapshot{
+			connected: true,
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("nonexistent.docker.", dns.TypeA)
@@ -1524,15 +1573,14 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
-			connected: true,
-			zones:     []string{"docker."},
-			Fall:      fall.Root,
-			records:   map[string][]net.IP{},
-			srvs:      map[string][]srvRecord{},
-			ptrs:      map[string][]string{},
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
+			Fall:  fall.Root,
 		}
+		storeSnapshot(d, &snapshot{
+			connected: true,
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("nonexistent.docker.", dns.TypeA)
@@ -1549,16 +1597,18 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
+		}
+		storeSnapshot(d, &snapshot{
 			connected: true,
-			zones:     []string{"docker."},
-			records: map[string][]net.IP{
-				"web.docker.": {net.ParseIP("172.17.0.2")},
+			recordSet: recordSet{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("172.17.0.2")},
+				},
 			},
-			srvs: map[string][]srvRecord{},
-			ptrs: map[string][]string{},
-		}
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("web.docker.", dns.TypeAAAA)
@@ -1575,16 +1625,18 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
+		}
+		storeSnapshot(d, &snapshot{
 			connected: true,
-			zones:     []string{"docker."},
-			records: map[string][]net.IP{
-				"web.docker.": {net.ParseIP("172.17.0.2")},
+			recordSet: recordSet{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("172.17.0.2")},
+				},
 			},
-			srvs: map[string][]srvRecord{},
-			ptrs: map[string][]string{},
-		}
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("web.docker.", dns.TypeA)
@@ -1601,16 +1653,18 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
+		}
+		storeSnapshot(d, &snapshot{
 			connected: true,
-			zones:     []string{"docker."},
-			records: map[string][]net.IP{
-				"web.docker.": {net.ParseIP("172.17.0.2")},
+			recordSet: recordSet{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("172.17.0.2")},
+				},
 			},
-			srvs: map[string][]srvRecord{},
-			ptrs: map[string][]string{},
-		}
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("web.docker.", dns.TypeMX)
@@ -1627,16 +1681,18 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
+		}
+		storeSnapshot(d, &snapshot{
 			connected: true,
-			zones:     []string{"docker."},
-			records: map[string][]net.IP{
-				"*.web.docker.": {net.ParseIP("172.17.0.2")},
+			recordSet: recordSet{
+				records: map[string][]net.IP{
+					"*.web.docker.": {net.ParseIP("172.17.0.2")},
+				},
 			},
-			srvs: map[string][]srvRecord{},
-			ptrs: map[string][]string{},
-		}
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("foo.web.docker.", dns.TypeA)
@@ -1653,14 +1709,13 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
-			connected: true,
-			zones:     []string{"docker."},
-			records:   map[string][]net.IP{},
-			srvs:      map[string][]srvRecord{},
-			ptrs:      map[string][]string{},
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
 		}
+		storeSnapshot(d, &snapshot{
+			connected: true,
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("docker.", dns.TypeSOA)
@@ -1677,14 +1732,13 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
-			connected: true,
-			zones:     []string{"docker."},
-			records:   map[string][]net.IP{},
-			srvs:      map[string][]srvRecord{},
-			ptrs:      map[string][]string{},
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
 		}
+		storeSnapshot(d, &snapshot{
+			connected: true,
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("docker.", dns.TypeNS)
@@ -1701,16 +1755,18 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
+		}
+		storeSnapshot(d, &snapshot{
 			connected: true,
-			zones:     []string{"docker."},
-			records:   map[string][]net.IP{},
-			srvs:      map[string][]srvRecord{},
-			ptrs: map[string][]string{
-				"2.0.17.172.in-addr.arpa.": {"web.docker."},
+			recordSet: recordSet{
+				ptrs: map[string][]string{
+					"2.0.17.172.in-addr.arpa.": {"web.docker."},
+				},
 			},
-		}
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("2.0.17.172.in-addr.arpa.", dns.TypePTR)
@@ -1727,14 +1783,13 @@ func TestServeDNSDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			Next:      test.ErrorHandler(),
-			ttl:       DefaultTTL,
-			connected: true,
-			zones:     []string{"docker."},
-			records:   map[string][]net.IP{},
-			srvs:      map[string][]srvRecord{},
-			ptrs:      map[string][]string{},
+			Next:  test.ErrorHandler(),
+			ttl:   DefaultTTL,
+			zones: []string{"docker."},
 		}
+		storeSnapshot(d, &snapshot{
+			connected: true,
+		})
 
 		m := new(dns.Msg)
 		m.SetQuestion("9.9.9.9.in-addr.arpa.", dns.TypePTR)
@@ -1764,18 +1819,21 @@ var errWriteMsgFailed = errors.New("simulated WriteMsg failure")
 
 func TestServeDNSWriteMsgErrorPaths(t *testing.T) {
 	d := &Docker{
-		Next:      test.ErrorHandler(),
-		ttl:       DefaultTTL,
+		Next:  test.ErrorHandler(),
+		ttl:   DefaultTTL,
+		zones: []string{"docker."},
+	}
+	storeSnapshot(d, &snapshot{
 		connected: true,
-		zones:     []string{"docker."},
-		records: map[string][]net.IP{
-			"web.docker.": {net.ParseIP("172.17.0.2")},
-		},
-		srvs: map[string][]srvRecord{},
-		ptrs: map[string][]string{
-			"2.0.17.172.in-addr.arpa.": {"web.docker."},
+		recordSet: recordSet{
+			records: map[string][]net.IP{
+				"web.docker.": {net.ParseIP("172.17.0.2")},
+			},
+			ptrs: map[string][]string{
+				"2.0.17.172.in-addr.arpa.": {"web.docker."},
+			},
 		},
-	}
+	})
 
 	cases := []struct {
 		name  string
@@ -1839,9 +1897,11 @@ func TestReadyDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			client:    &client.Client{},
-			connected: true,
+			client: &client.Client{},
 		}
+		storeSnapshot(d, &snapshot{
+			connected: true,
+		})
 		d.Ready()
 
 		output := buf.String()
@@ -1866,10 +1926,12 @@ func TestReadyDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			client:       &client.Client{},
+			client: &client.Client{},
+		}
+		storeSnapshot(d, &snapshot{
 			connected:    false,
 			lastSyncTime: time.Now(),
-		}
+		})
 		d.Ready()
 
 		output := buf.String()
@@ -1882,9 +1944,11 @@ func TestReadyDebugLogging(t *testing.T) {
 		buf := enableDebugLog(t)
 
 		d := &Docker{
-			client:    &client.Client{},
-			connected: false,
+			client: &client.Client{},
 		}
+		storeSnapshot(d, &snapshot{
+			connected: false,
+		})
 		d.Ready()
 
 		output := buf.String()
@@ -0,0 +1,121 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/docker/docker/client"
+)
+
+// endpointAuto is the endpoint value that probes the usual rootless and
+// Docker Desktop socket locations before falling back to the environment.
+const endpointAuto = "auto"
+
+// apiVersionPattern matches a Docker Engine API version such as 1.47.
+var apiVersionPattern = regexp.MustCompile(`^v?[0-9]+\.[0-9]+$`)
+
+// validateEndpoint checks that host is a Docker host URL the client can
+// dial: unix://PATH, tcp://HOST:PORT or npipe://PATH.
+func validateEndpoint(host string) (scheme string, err error) {
+	u, err := client.ParseHostURL(host)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "unix", "tcp", "npipe":
+		return u.Scheme, nil
+	}
+	return "", fmt.Errorf("unsupported endpoint scheme %q, expected unix, tcp or npipe", u.Scheme)
+}
+
+// rootlessSocketCandidates returns the socket paths endpoint auto probes,
+// in order: rootless dockerd under the runtime dir, Docker Desktop under
+// the home directory, and finally the system socket.
+func rootlessSocketCandidates(getenv func(string) string, uid int) []string {
+	var paths []string
+	if dir := getenv("XDG_RUNTIME_DIR"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "docker.sock"))
+	}
+	paths = append(paths, filepath.Join("/run/user", strconv.Itoa(uid), "docker.sock"))
+	if home := getenv("HOME"); home != "" {
+		paths = append(paths,
+			filepath.Join(home, ".docker", "run", "docker.sock"),
+			filepath.Join(home, ".docker", "desktop", "docker.sock"),
+		)
+	}
+	return append(paths, "/var/run/docker.sock")
+}
+
+// findDockerSocket returns the first candidate that is a unix socket.
+func findDockerSocket(candidates []string) (string, bool) {
+	for _, path := range candidates {
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// clientOptions returns the options the Docker client is built with. The
+// environment (DOCKER_HOST, DOCKER_CERT_PATH, DOCKER_API_VERSION, ...)
+// still applies, but anything set in the Corefile takes precedence.
+func (d *Docker) clientOptions() []client.Opt {
+	opts := []client.Opt{client.FromEnv}
+
+	host := d.endpoint
+	if host == endpointAuto {
+		host = ""
+		if path, ok := findDockerSocket(rootlessSocketCandidates(os.Getenv, os.Getuid())); ok {
+			host = "unix://" + path
+			log.Infof("endpoint auto: using Docker socket %s", path)
+		} else {
+			log.Warningf("endpoint auto: no Docker socket found, falling back to the environment")
+		}
+	}
+	if host != "" {
+		opts = append(opts, client.WithHost(host))
+	}
+	if d.tlsCA != "" || d.tlsCert != "" || d.tlsKey != "" {
+		opts = append(opts, client.WithTLSClientConfig(d.tlsCA, d.tlsCert, d.tlsKey))
+	}
+	if d.apiVersion != "" {
+		opts = append(opts, client.WithVersion(d.apiVersion))
+	}
+	return append(opts, client.WithAPIVersionNegotiation())
+}
+
+// runtimeName returns the container runtime d talks to.
+func (d *Docker) runtimeName() string {
+	if d.runtime == "" {
+		return runtimeDocker
+	}
+	return d.runtime
+}
+
+// newClient creates the client for d's runtime: the Docker client, which
+// also serves Podman, or the containerd adapter.
+func (d *Docker) newClient() (daemonClient, error) {
+	if d.runtime == runtimeContainerd {
+		address := d.endpoint
+		if address == "" {
+			address = defaultContainerdSocket
+		}
+		namespace := d.namespace
+		if namespace == "" {
+			namespace = defaultContainerdNamespace
+		}
+		cli, err := newContainerdClient(address, namespace, defaultCNIResultsDir)
+		if err != nil {
+			return nil, err
+		}
+		return cli, nil
+	}
+	cli, err := client.NewClientWithOpts(d.clientOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/client"
+)
+
+func TestValidateEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"unix:///var/run/docker.sock":    "unix",
+		"tcp://10.0.0.5:2376":            "tcp",
+		"npipe:////./pipe/docker_engine": "npipe",
+		"ssh://user@host":                "",
+		"http://10.0.0.5:2375":           "",
+		"/var/run/docker.sock":           "",
+		"tcp://":                         "",
+	}
+	for host, want := range tests {
+		scheme, err := validateEndpoint(host)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error", host)
+			}
+			continue
+		}
+		if err != nil || scheme != want {
+			t.Errorf("%s: expected scheme %s, got %q (%v)", host, want, scheme, err)
+		}
+	}
+}
+
+func TestRootlessSocketCandidates(t *testing.T) {
+	env := map[string]string{"XDG_RUNTIME_DIR": "/run/user/1000", "HOME": "/home/dev"}
+	got := rootlessSocketCandidates(func(k string) string { return env[k] }, 1000)
+	want := []string{
+		"/run/user/1000/docker.sock",
+		"/run/user/1000/docker.sock",
+		"/home/dev/.docker/run/docker.sock",
+		"/home/dev/.docker/desktop/docker.sock",
+		"/var/run/docker.sock",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	got = rootlessSocketCandidates(func(string) string { return "" }, 1001)
+	want = []string{"/run/user/1001/docker.sock", "/var/run/docker.sock"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v without XDG_RUNTIME_DIR and HOME, got %v", want, got)
+	}
+}
+
+func TestFindDockerSocket(t *testing.T) {
+	dir := t.TempDir()
+	regular := filepath.Join(dir, "regular.sock")
+	if err := os.WriteFile(regular, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("cannot create a unix socket: %v", err)
+	}
+	defer l.Close()
+
+	got, ok := findDockerSocket([]string{filepath.Join(dir, "missing.sock"), regular, socket})
+	if !ok || got != socket {
+		t.Errorf("expected %s, got %q", socket, got)
+	}
+	if _, ok := findDockerSocket([]string{regular}); ok {
+		t.Errorf("expected a regular file not to count as a socket")
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "unix:///from/env.sock")
+	t.Setenv("DOCKER_API_VERSION", "")
+	t.Setenv("DOCKER_CERT_PATH", "")
+
+	tests := []struct {
+		name        string
+		d           *Docker
+		wantHost    string
+		wantVersion string
+	}{
+		{
+			name:     "environment",
+			d:        &Docker{},
+			wantHost: "unix:///from/env.sock",
+		},
+		{
+			name:        "endpoint and api_version",
+			d:           &Docker{endpoint: "tcp://10.0.0.5:2375", apiVersion: "1.41"},
+			wantHost:    "tcp://10.0.0.5:2375",
+			wantVersion: "1.41",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cli, err := client.NewClientWithOpts(test.d.clientOptions()...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cli.Close()
+			if got := cli.DaemonHost(); got != test.wantHost {
+				t.Errorf("expected host %s, got %s", test.wantHost, got)
+			}
+			if test.wantVersion != "" && cli.ClientVersion() != test.wantVersion {
+				t.Errorf("expected API version %s, got %s", test.wantVersion, cli.ClientVersion())
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package docker
+
+import "time"
+
+// defaultEndpointName labels the single endpoint's metrics when no named
+// endpoint blocks are configured.
+const defaultEndpointName = "default"
+
+// configureEndpoints finishes the named endpoint blocks once the whole
+// Corefile block has been parsed: each endpoint gets its own copy of the
+// shared options, its zones prefixed with its subzone, and a link back to
+// d, which from then on only aggregates their records.
+func (d *Docker) configureEndpoints() {
+	for _, e := range d.endpoints {
+		e.parent = d
+		e.ttl = d.ttl
+		e.zones = d.zones
+		if e.subzone != "" {
+			e.zones = make([]string, len(d.zones))
+			for i, zone := range d.zones {
+				e.zones[i] = e.subzone + "." + zone
+			}
+		}
+		e.labelPrefix = d.labelPrefix
+		e.maxBackoff = d.maxBackoff
+		e.networks = d.networks
+		e.hostMode = d.hostMode
+		e.hostModePTR = d.hostModePTR
+		e.linkLocal = d.linkLocal
+		e.nameTemplates = d.nameTemplates
+		e.syncDebounce = d.syncDebounce
+		e.syncMaxDelay = d.syncMaxDelay
+		e.resyncInterval = d.resyncInterval
+		e.apiTimeout = d.apiTimeout
+		e.heartbeatInterval = d.heartbeatInterval
+		e.heartbeatTimeout = d.heartbeatTimeout
+		e.inspectConcurrency = d.inspectConcurrency
+		e.inspectCache = newInspectCache()
+		e.inspectGrace = d.inspectGrace
+		e.swarmInterval = d.swarmInterval
+	}
+}
+
+// daemons returns the Docker endpoints that run a client and an event
+// loop: the named endpoints if there are any, otherwise d itself.
+func (d *Docker) daemons() []*Docker {
+	if len(d.endpoints) > 0 {
+		return d.endpoints
+	}
+	return []*Docker{d}
+}
+
+// endpointName returns the name d's per-endpoint metrics and logs use.
+func (d *Docker) endpointName() string {
+	if d.name == "" {
+		return defaultEndpointName
+	}
+	return d.name
+}
+
+// publishEndpoints merges the snapshots of the named endpoints, in the
+// order they are configured, into the snapshot ServeDNS reads. The plugin
+// counts as connected while any endpoint is; names contributed by
+// endpoints that are down are listed in staleNames so they alone get the
+// stale TTL. Records loaded from snapshot_file are merged in, and count
+// as stale, until every endpoint has synced once.
+func (d *Docker) publishEndpoints() {
+	var merged *recordSet
+	var prev, next *answerTable
+	d.updateSnapshot(func(s *snapshot) {
+		prev = s.answers
+		merged = newRecordSet()
+		var stale []*recordSet
+		connected, synced := false, true
+		containers := 0
+		for _, e := range d.endpoints {
+			es := e.loadSnapshot()
+			merged.merge(&es.recordSet)
+			containers += es.containers
+			if es.connected {
+				connected = true
+			} else {
+				stale = append(stale, &es.recordSet)
+			}
+			if es.lastSyncTime.IsZero() {
+				synced = false
+			} else if es.lastSyncTime.After(s.lastSyncTime) {
+				s.lastSyncTime = es.lastSyncTime
+			}
+		}
+		if !synced && d.loaded != nil {
+			merged.merge(d.loaded)
+			stale = append(stale, d.loaded)
+		}
+
+		next = d.buildAnswers(merged)
+		s.recordSet = *merged
+		s.answers = next
+		s.connected = connected
+		s.containers = containers
+		s.staleNames = nil
+		if connected && len(stale) > 0 {
+			s.staleNames = staleNames(stale)
+		}
+		if synced {
+			d.persistLocked(merged, s.lastSyncTime)
+		}
+		setConnectedGauge(connected)
+		if !s.lastSyncTime.IsZero() {
+			lastSyncTimestamp.Set(float64(s.lastSyncTime.Unix()))
+		}
+	})
+	d.notifyChanges(prev, next)
+	d.recordGauges(merged, d.loadSnapshot().containers)
+}
+
+// staleNames returns every owner name and reverse-arpa name in sets.
+func staleNames(sets []*recordSet) map[string]struct{} {
+	names := make(map[string]struct{})
+	for _, rs := range sets {
+		for name := range rs.records {
+			names[name] = struct{}{}
+		}
+		for name := range rs.srvs {
+			names[name] = struct{}{}
+		}
+		for name := range rs.ptrs {
+			names[name] = struct{}{}
+		}
+		for name := range rs.cnames {
+			names[name] = struct{}{}
+		}
+		for name := range rs.txts {
+			names[name] = struct{}{}
+		}
+	}
+	return names
+}
+
+// recordEndpointSync updates the per-endpoint last sync gauge.
+func (d *Docker) recordEndpointSync(now time.Time) {
+	endpointLastSyncTimestamp.WithLabelValues(d.endpointName()).Set(float64(now.Unix()))
+}
@@ -0,0 +1,291 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/docker/docker/api/types/container"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseNamedEndpoints(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+		endpoint rootful unix:///var/run/docker.sock
+		endpoint rootless unix:///run/user/1000/docker.sock {
+			subzone
+		}
+		endpoint build tcp://10.0.0.5:2375 {
+			subzone ci.Build.
+			api_version 1.41
+		}
+		zone docker. internal.
+		ttl 60
+	}`)
+	d := &Docker{zones: []string{"docker."}}
+	if err := parse(c, d); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.endpoints) != 3 {
+		t.Fatalf("expected 3 endpoints, got %d", len(d.endpoints))
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		zones      []string
+		apiVersion string
+	}{
+		{"rootful", "unix:///var/run/docker.sock", []string{"docker.", "internal."}, ""},
+		{"rootless", "unix:///run/user/1000/docker.sock", []string{"rootless.docker.", "rootless.internal."}, ""},
+		{"build", "tcp://10.0.0.5:2375", []string{"ci.build.docker.", "ci.build.internal."}, "1.41"},
+	}
+	for i, want := range tests {
+		e := d.endpoints[i]
+		if e.name != want.name || e.endpoint != want.url || e.apiVersion != want.apiVersion {
+			t.Errorf("endpoint %d: expected %s %s %q, got %s %s %q", i, want.name, want.url, want.apiVersion, e.name, e.endpoint, e.apiVersion)
+		}
+		if len(e.zones) != len(want.zones) || e.zones[0] != want.zones[0] || e.zones[1] != want.zones[1] {
+			t.Errorf("endpoint %s: expected zones %v, got %v", e.name, want.zones, e.zones)
+		}
+		if e.parent != d || e.ttl != 60 || e.inspectCache == nil {
+			t.Errorf("endpoint %s: expected the shared options to be copied from the plugin", e.name)
+		}
+	}
+}
+
+func TestParseNamedEndpointErrors(t *testing.T) {
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"duplicate name": `docker {
+			endpoint a unix:///a.sock
+			endpoint a unix:///b.sock
+		}`,
+		"mixed with top-level endpoint": `docker {
+			endpoint unix:///var/run/docker.sock
+			endpoint a unix:///a.sock
+		}`,
+		"mixed with top-level api_version": `docker {
+			api_version 1.41
+			endpoint a unix:///a.sock
+		}`,
+		"invalid url": `docker {
+			endpoint a ssh://host
+		}`,
+		"too many arguments": `docker {
+			endpoint a unix:///a.sock extra
+		}`,
+		"unknown block property": `docker {
+			endpoint a unix:///a.sock {
+				zone other.
+			}
+		}`,
+		"tls on unix socket": `docker {
+			endpoint a unix:///a.sock {
+				tls_ca ` + ca + `
+			}
+		}`,
+		"unterminated block": `docker {
+			endpoint a unix:///a.sock {
+				subzone a`,
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", input)
+			if err := parse(c, &Docker{zones: []string{"docker."}}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+// newEndpointTestDocker returns a plugin with two named endpoints, a and
+// b, where b publishes under the b subzone.
+func newEndpointTestDocker() *Docker {
+	d := newSyncTestDocker()
+	d.Next = test.ErrorHandler()
+	d.endpoints = []*Docker{{name: "a"}, {name: "b", subzone: "b"}}
+	d.configureEndpoints()
+	return d
+}
+
+// syncEndpoint publishes the given containers as e's records.
+func syncEndpoint(t *testing.T, e *Docker, inspections map[string]container.InspectResponse) {
+	t.Helper()
+	var containers []container.Summary
+	for id := range inspections {
+		containers = append(containers, container.Summary{ID: id})
+	}
+	e.replaceContainers(context.Background(), containers, &mockContainerInspector{inspections: inspections})
+}
+
+func queryTTL(t *testing.T, d *Docker, qname string) (int, uint32) {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(qname, dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := d.ServeDNS(context.Background(), rec, m); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Msg.Answer) == 0 {
+		return rec.Msg.Rcode, 0
+	}
+	return rec.Msg.Rcode, rec.Msg.Answer[0].Header().Ttl
+}
+
+func TestEndpointsMergeRecords(t *testing.T) {
+	d := newEndpointTestDocker()
+	a, b := d.endpoints[0], d.endpoints[1]
+
+	syncEndpoint(t, a, map[string]container.InspectResponse{"c1": runningContainer("web", "172.17.0.2", nil)})
+	syncEndpoint(t, b, map[string]container.InspectResponse{"c1": runningContainer("web", "172.18.0.2", nil)})
+	a.setConnected(true)
+	b.setConnected(true)
+
+	snap := d.loadSnapshot()
+	if !snap.connected {
+		t.Errorf("expected the plugin to be connected")
+	}
+	if ips := snap.records["web.docker."]; len(ips) != 1 || !ips[0].Equal(net.ParseIP("172.17.0.2")) {
+		t.Errorf("expected web.docker. from endpoint a, got %v", ips)
+	}
+	if ips := snap.records["web.b.docker."]; len(ips) != 1 || !ips[0].Equal(net.ParseIP("172.18.0.2")) {
+		t.Errorf("expected web.b.docker. from endpoint b, got %v", ips)
+	}
+	if snap.containers != 2 {
+		t.Errorf("expected 2 containers across endpoints, got %d", snap.containers)
+	}
+	if got := testutil.ToFloat64(endpointConnectedGauge.WithLabelValues("b")); got != 1 {
+		t.Errorf("expected endpoint b to be reported connected, got %f", got)
+	}
+	if got := testutil.ToFloat64(endpointLastSyncTimestamp.WithLabelValues("a")); got == 0 {
+		t.Errorf("expected endpoint a to report its last sync")
+	}
+
+	if _, ttl := queryTTL(t, d, "web.b.docker."); ttl != DefaultTTL {
+		t.Errorf("expected normal TTL while both endpoints are connected, got %d", ttl)
+	}
+}
+
+func TestEndpointsStaleOnlyForDisconnectedEndpoint(t *testing.T) {
+	d := newEndpointTestDocker()
+	a, b := d.endpoints[0], d.endpoints[1]
+	syncEndpoint(t, a, map[string]container.InspectResponse{"c1": runningContainer("web", "172.17.0.2", nil)})
+	syncEndpoint(t, b, map[string]container.InspectResponse{"c1": runningContainer("api", "172.18.0.2", nil)})
+	a.setConnected(true)
+	b.setConnected(true)
+
+	b.setConnected(false)
+	if !d.loadSnapshot().connected {
+		t.Errorf("expected the plugin to stay connected while endpoint a is up")
+	}
+	if _, ttl := queryTTL(t, d, "web.docker."); ttl != DefaultTTL {
+		t.Errorf("expected normal TTL for endpoint a's records, got %d", ttl)
+	}
+	if _, ttl := queryTTL(t, d, "api.b.docker."); ttl != 5 {
+		t.Errorf("expected stale TTL for endpoint b's records, got %d", ttl)
+	}
+
+	a.setConnected(false)
+	if d.loadSnapshot().connected {
+		t.Errorf("expected the plugin to be disconnected once every endpoint is down")
+	}
+	if _, ttl := queryTTL(t, d, "web.docker."); ttl != 5 {
+		t.Errorf("expected stale TTL once every endpoint is down, got %d", ttl)
+	}
+	if !d.Ready() {
+		t.Errorf("expected the plugin to stay ready on previously synced records")
+	}
+
+	b.setConnected(true)
+	if _, ttl := queryTTL(t, d, "api.b.docker."); ttl != DefaultTTL {
+		t.Errorf("expected normal TTL once endpoint b reconnects, got %d", ttl)
+	}
+}
+
+func TestEndpointsServeLoadedRecordsUntilAllSynced(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	saved := &recordSet{records: map[string][]net.IP{
+		"old.docker.":   {net.ParseIP("172.17.0.9")},
+		"old.b.docker.": {net.ParseIP("172.18.0.9")},
+	}}
+	if err := writeSnapshotFile(path, saved, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newEndpointTestDocker()
+	d.snapshotFile = path
+	if !d.loadSnapshotFile() {
+		t.Fatal("expected the snapshot file to load")
+	}
+	if d.Ready() {
+		t.Errorf("expected the plugin not to be ready on loaded records alone")
+	}
+	a, b := d.endpoints[0], d.endpoints[1]
+
+	syncEndpoint(t, a, map[string]container.InspectResponse{"c1": runningContainer("web", "172.17.0.2", nil)})
+	a.setConnected(true)
+	if rcode, ttl := queryTTL(t, d, "old.b.docker."); rcode != dns.RcodeSuccess || ttl != 5 {
+		t.Errorf("expected loaded records to be served stale until b syncs, got rcode %d ttl %d", rcode, ttl)
+	}
+	if _, ttl := queryTTL(t, d, "web.docker."); ttl != DefaultTTL {
+		t.Errorf("expected endpoint a's live records at the normal TTL, got %d", ttl)
+	}
+
+	syncEndpoint(t, b, map[string]container.InspectResponse{})
+	if rcode, _ := queryTTL(t, d, "old.b.docker."); rcode != dns.RcodeNameError {
+		t.Errorf("expected loaded records to be dropped once every endpoint synced, got rcode %d", rcode)
+	}
+	d.persister.flush()
+	rs, _, err := readSnapshotFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rs.records["web.docker."]; !ok || len(rs.records) != 1 {
+		t.Errorf("expected the merged live records to be persisted, got %v", rs.records)
+	}
+}
+
+func TestSetupNamedEndpointsUnreachable(t *testing.T) {
+	dir := t.TempDir()
+	input := `docker {
+		endpoint a unix://` + filepath.Join(dir, "a.sock") + `
+		endpoint b unix://` + filepath.Join(dir, "b.sock") + `
+	}`
+
+	err := setup(caddy.NewTestController("dns", input))
+	if err == nil || !strings.Contains(err.Error(), "endpoint a") {
+		t.Errorf("expected setup to fail naming endpoint a, got %v", err)
+	}
+
+	c := caddy.NewTestController("dns", strings.Replace(input, "docker {", "docker {\n\t\tlazy_connect", 1))
+	if err := setup(c); err != nil {
+		t.Fatalf("expected lazy_connect setup to succeed, got %v", err)
+	}
+	d := dnsserver.GetConfig(c).Plugin[0](nil).(*Docker)
+	defer releaseWatchers(d.daemons())
+	for _, e := range d.endpoints {
+		if e.client == nil {
+			t.Errorf("expected endpoint %s to get its own client", e.name)
+		}
+	}
+	if d.client != nil {
+		t.Errorf("expected the plugin itself to run no client when endpoints are configured")
+	}
+	if d.Ready() {
+		t.Errorf("expected the plugin not to be ready before any endpoint synced")
+	}
+}
@@ -0,0 +1,231 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+)
+
+// sourceFile is the source kind that reads docker inspect dumps from a
+// directory instead of talking to a daemon.
+const sourceFile = "file"
+
+// fileSourcePollInterval is how often a file source checks its directory
+// for changes.
+const fileSourcePollInterval = 2 * time.Second
+
+// fileSource is a ContainerSource that reads `docker inspect` JSON from
+// the *.json files in a directory. Each file holds either the array
+// docker inspect prints or a single inspect object. Containers go through
+// the same conversion as ones inspected from the daemon, so a host's
+// container set can be replayed without Docker. Changes to the directory
+// are picked up by polling.
+type fileSource struct {
+	d        *Docker
+	dir      string
+	interval time.Duration
+
+	// containers holds the last directory contents List or Watch read,
+	// keyed by container ID; order lists the IDs in file order.
+	mu         sync.Mutex
+	containers map[string]fileContainer
+	order      []string
+}
+
+// fileContainer is one container read from a file source.
+type fileContainer struct {
+	inspect     container.InspectResponse
+	fingerprint uint64
+}
+
+// newFileSource returns a fileSource for dir.
+func newFileSource(d *Docker, dir string) *fileSource {
+	return &fileSource{d: d, dir: dir, interval: fileSourcePollInterval}
+}
+
+// load reads every *.json file in the directory, in name order. A
+// container that appears in more than one file takes its last
+// appearance.
+func (s *fileSource) load() (map[string]fileContainer, []string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+	containers := make(map[string]fileContainer)
+	var order []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		raws, err := splitInspectJSON(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, raw := range raws {
+			var inspect container.InspectResponse
+			if err := json.Unmarshal(raw, &inspect); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if inspect.ContainerJSONBase == nil || inspect.ID == "" {
+				return nil, nil, fmt.Errorf("%s: container without an Id", path)
+			}
+			if _, ok := containers[inspect.ID]; !ok {
+				order = append(order, inspect.ID)
+			}
+			h := fnv.New64a()
+			h.Write(raw)
+			containers[inspect.ID] = fileContainer{inspect: inspect, fingerprint: h.Sum64()}
+		}
+	}
+	return containers, order, nil
+}
+
+// splitInspectJSON returns the inspect objects in a file: the elements of
+// the array docker inspect prints, or the file itself if it holds a
+// single object.
+func splitInspectJSON(data []byte) ([]json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return nil, err
+		}
+		return raws, nil
+	}
+	var raw json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{raw}, nil
+}
+
+// List implements ContainerSource. It rereads the directory and lists the
+// containers whose dump says they are running.
+func (s *fileSource) List(ctx context.Context) ([]ContainerRef, error) {
+	containers, order, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.containers, s.order = containers, order
+	s.mu.Unlock()
+
+	var refs []ContainerRef
+	for _, id := range order {
+		fc := containers[id]
+		if !fileContainerRunning(fc) {
+			continue
+		}
+		refs = append(refs, ContainerRef{ID: id, Name: fileContainerName(id, fc), Fingerprint: fc.fingerprint})
+	}
+	return refs, nil
+}
+
+// ContainerInspect implements ContainerInspector from the last directory
+// contents read.
+func (s *fileSource) ContainerInspect(ctx context.Context, id string) (container.InspectResponse, error) {
+	s.mu.Lock()
+	fc, ok := s.containers[id]
+	s.mu.Unlock()
+	if !ok {
+		return container.InspectResponse{}, fmt.Errorf("container %s not found in %s: %w", id, s.dir, cerrdefs.ErrNotFound)
+	}
+	return fc.inspect, nil
+}
+
+// Inspect implements ContainerSource, converting the dump the same way a
+// daemon's inspect response is.
+func (s *fileSource) Inspect(ctx context.Context, id string) (Container, error) {
+	return s.d.newDockerSource(nil, s).Inspect(ctx, id)
+}
+
+// Watch implements ContainerSource. It polls the directory and reports
+// every container that was added, changed or removed since the last
+// read. A directory that fails to read, such as one with a file that is
+// still being written, is retried on the next poll.
+func (s *fileSource) Watch(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	out := make(chan ContainerEvent)
+	errs := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			case <-ticker.C:
+			}
+			evs, err := s.changes()
+			if err != nil {
+				log.Warningf("Failed to read container dumps from %s: %v", s.dir, err)
+				continue
+			}
+			for _, ev := range evs {
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				}
+			}
+		}
+	}()
+	return out, errs
+}
+
+// changes rereads the directory and returns events for the containers
+// that differ from the last read.
+func (s *fileSource) changes() ([]ContainerEvent, error) {
+	containers, order, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	prev, prevOrder := s.containers, s.order
+	s.containers, s.order = containers, order
+	s.mu.Unlock()
+
+	var evs []ContainerEvent
+	for _, id := range order {
+		fc := containers[id]
+		if old, ok := prev[id]; ok && old.fingerprint == fc.fingerprint {
+			continue
+		}
+		evs = append(evs, ContainerEvent{ID: id, Name: fileContainerName(id, fc), Remove: !fileContainerRunning(fc)})
+	}
+	for _, id := range prevOrder {
+		if _, ok := containers[id]; !ok {
+			evs = append(evs, ContainerEvent{ID: id, Name: fileContainerName(id, prev[id]), Remove: true})
+		}
+	}
+	return evs, nil
+}
+
+// fileContainerRunning reports whether a dump says the container is
+// running, which is what the daemon would list.
+func fileContainerRunning(fc fileContainer) bool {
+	return fc.inspect.State != nil && fc.inspect.State.Running
+}
+
+// fileContainerName returns a dumped container's name, falling back to
+// its short ID.
+func fileContainerName(id string, fc fileContainer) string {
+	if name := strings.TrimPrefix(fc.inspect.Name, "/"); name != "" {
+		return name
+	}
+	return shortID(id)
+}
@@ -0,0 +1,216 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/docker/docker/api/types/container"
+)
+
+// writeInspectDump writes the given containers to dir/name as docker
+// inspect prints them.
+func writeInspectDump(t *testing.T, dir, name string, inspections ...container.InspectResponse) {
+	t.Helper()
+	data, err := json.Marshal(inspections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dumpedContainer returns a running container with an ID, as found in a
+// docker inspect dump.
+func dumpedContainer(id, name, ip string) container.InspectResponse {
+	inspect := runningContainer(name, ip, nil)
+	inspect.ID = id
+	return inspect
+}
+
+func TestFileSourceReplaysInspectDumps(t *testing.T) {
+	dir := t.TempDir()
+	stopped := dumpedContainer("c3", "old", "172.17.0.9")
+	stopped.State.Running = false
+	writeInspectDump(t, dir, "host.json", dumpedContainer("c1", "web", "172.17.0.2"), stopped)
+	// A single object, as saved from the API, works as well as the array
+	// docker inspect prints.
+	api := `{
+		"Id": "c2",
+		"Name": "/api",
+		"State": {"Running": true},
+		"HostConfig": {"NetworkMode": "app"},
+		"Config": {"Labels": {"com.dokku.coredns-docker/hostname": "backend"}},
+		"NetworkSettings": {"Networks": {"app": {"IPAddress": "172.18.0.2", "Aliases": ["api-alias"]}}}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "api.json"), []byte(api), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a dump"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newSyncTestDocker()
+	d.source = newFileSource(d, dir)
+	if _, err := d.fullSync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+	assertRecordIPs(t, d, "api.docker.", "172.18.0.2")
+	assertRecordIPs(t, d, "api-alias.docker.", "172.18.0.2")
+	assertRecordIPs(t, d, "backend.docker.", "172.18.0.2")
+	assertRecordIPs(t, d, "old.docker.")
+}
+
+func TestFileSourceErrors(t *testing.T) {
+	ctx := context.Background()
+	tests := map[string]string{
+		"invalid json": `[{"Id": `,
+		"missing id":   `[{"Name": "/web"}]`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "host.json"), []byte(body), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := newFileSource(newSyncTestDocker(), dir).List(ctx); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+
+	s := newFileSource(newSyncTestDocker(), t.TempDir())
+	if _, err := s.Inspect(ctx, "c1"); !cerrdefs.IsNotFound(err) {
+		t.Errorf("expected a not found error for an unknown container, got %v", err)
+	}
+}
+
+func TestFileSourceWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeInspectDump(t, dir, "host.json", dumpedContainer("c1", "web", "172.17.0.2"), dumpedContainer("c2", "api", "172.17.0.3"))
+
+	s := newFileSource(newSyncTestDocker(), dir)
+	s.interval = 10 * time.Millisecond
+	if _, err := s.List(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	evs, errs := s.Watch(ctx)
+	next := func() ContainerEvent {
+		t.Helper()
+		select {
+		case ev := <-evs:
+			return ev
+		case err := <-errs:
+			t.Fatalf("unexpected watch error: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("expected an event")
+		}
+		return ContainerEvent{}
+	}
+
+	// c1 changes its address, c2 is dropped from the dump.
+	writeInspectDump(t, dir, "host.json", dumpedContainer("c1", "web", "172.17.0.5"))
+	if ev := next(); ev.ID != "c1" || ev.Name != "web" || ev.Remove {
+		t.Errorf("expected c1 to be reported changed, got %+v", ev)
+	}
+	if ev := next(); ev.ID != "c2" || !ev.Remove {
+		t.Errorf("expected c2 to be reported removed, got %+v", ev)
+	}
+
+	stopped := dumpedContainer("c1", "web", "172.17.0.5")
+	stopped.State.Running = false
+	writeInspectDump(t, dir, "host.json", stopped)
+	if ev := next(); ev.ID != "c1" || !ev.Remove {
+		t.Errorf("expected a stopped c1 to be reported removed, got %+v", ev)
+	}
+
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("expected the watch to end with context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the watch to end when its context is cancelled")
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	dir := t.TempDir()
+	c := caddy.NewTestController("dns", `docker {
+		source file `+dir+`
+	}`)
+	d := &Docker{}
+	if err := parse(c, d); err != nil {
+		t.Fatal(err)
+	}
+	if d.sourceDir != dir {
+		t.Errorf("expected source directory %s, got %q", dir, d.sourceDir)
+	}
+
+	c = caddy.NewTestController("dns", `docker {
+		source docker
+	}`)
+	d = &Docker{}
+	if err := parse(c, d); err != nil || d.sourceDir != "" {
+		t.Errorf("expected source docker to read from the daemon, got %q, %v", d.sourceDir, err)
+	}
+}
+
+func TestParseSourceErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "host.json")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"missing":          `docker { source }`,
+		"unknown":          `docker { source kubernetes }`,
+		"no directory":     `docker { source file }`,
+		"missing dir":      `docker { source file ` + filepath.Join(dir, "missing") + ` }`,
+		"not a directory":  `docker { source file ` + file + ` }`,
+		"docker arguments": `docker { source docker ` + dir + ` }`,
+		"with endpoint":    "docker {\nsource file " + dir + "\nendpoint unix:///var/run/docker.sock\n}",
+		"with heartbeat":   "docker {\nsource file " + dir + "\nheartbeat 10s\n}",
+		"with named endpoints": `docker {
+			source file ` + dir + `
+			endpoint a unix:///a.sock
+		}`,
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", input)
+			if err := parse(c, &Docker{zones: []string{"docker."}}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestSetupFileSource(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "unix://"+filepath.Join(t.TempDir(), "docker.sock"))
+	dir := t.TempDir()
+	c := caddy.NewTestController("dns", `docker {
+		source file `+dir+`
+	}`)
+	if err := setup(c); err != nil {
+		t.Fatalf("expected setup to succeed without a daemon, got %v", err)
+	}
+	d := dnsserver.GetConfig(c).Plugin[0](nil).(*Docker)
+	if d.client != nil {
+		t.Errorf("expected no Docker client for a file source")
+	}
+	if _, ok := d.source.(*fileSource); !ok {
+		t.Errorf("expected a file source, got %T", d.source)
+	}
+}
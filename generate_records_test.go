@@ -4696,6 +4696,287 @@ func TestGenerateRecords(t *testing.T) {
 				ptrs: map[string][]string{},
 			},
 		},
+		{
+			name: "dual-stack container gets A and AAAA records",
+			input: GenerateRecordsInput{
+				Inspector: &mockContainerInspector{
+					inspections: map[string]container.InspectResponse{
+						"container1": {
+							ContainerJSONBase: &container.ContainerJSONBase{
+								Name: "/web",
+								HostConfig: &container.HostConfig{
+									NetworkMode: container.NetworkMode("bridge"),
+								},
+							},
+							Config: &container.Config{
+								Labels: map[string]string{},
+							},
+							NetworkSettings: &container.NetworkSettings{
+								Networks: map[string]*network.EndpointSettings{
+									"bridge": {
+										IPAddress:         "172.17.0.2",
+										GlobalIPv6Address: "2001:db8::2",
+									},
+								},
+							},
+						},
+					},
+				},
+				Containers: []container.Summary{
+					{ID: "container1"},
+				},
+				Zones:       []string{"docker."},
+				LabelPrefix: "com.dokku.coredns-docker",
+			},
+			expected: generateRecordsExpected{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("172.17.0.2"), net.ParseIP("2001:db8::2")},
+				},
+				srvs: map[string][]srvRecord{},
+				ptrs: map[string][]string{
+					mustReverseAddr("172.17.0.2"):  {"web.docker."},
+					mustReverseAddr("2001:db8::2"): {"web.docker."},
+				},
+			},
+		},
+		{
+			name: "IPv6-only container gets AAAA record",
+			input: GenerateRecordsInput{
+				Inspector: &mockContainerInspector{
+					inspections: map[string]container.InspectResponse{
+						"container1": {
+							ContainerJSONBase: &container.ContainerJSONBase{
+								Name: "/web",
+								HostConfig: &container.HostConfig{
+									NetworkMode: container.NetworkMode("bridge"),
+								},
+							},
+							Config: &container.Config{
+								Labels: map[string]string{},
+							},
+							NetworkSettings: &container.NetworkSettings{
+								Networks: map[string]*network.EndpointSettings{
+									"bridge": {
+										GlobalIPv6Address: "2001:db8::2",
+									},
+								},
+							},
+						},
+					},
+				},
+				Containers: []container.Summary{
+					{ID: "container1"},
+				},
+				Zones:       []string{"docker."},
+				LabelPrefix: "com.dokku.coredns-docker",
+			},
+			expected: generateRecordsExpected{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("2001:db8::2")},
+				},
+				srvs: map[string][]srvRecord{},
+				ptrs: map[string][]string{
+					mustReverseAddr("2001:db8::2"): {"web.docker."},
+				},
+			},
+		},
+		{
+			name: "dual-stack container with SRV label emits one SRV per target",
+			input: GenerateRecordsInput{
+				Inspector: &mockContainerInspector{
+					inspections: map[string]container.InspectResponse{
+						"container1": {
+							ContainerJSONBase: &container.ContainerJSONBase{
+								Name: "/web",
+								HostConfig: &container.HostConfig{
+									NetworkMode: container.NetworkMode("bridge"),
+								},
+							},
+							Config: &container.Config{
+								Labels: map[string]string{
+									"com.dokku.coredns-docker/srv._tcp._http": "80",
+								},
+							},
+							NetworkSettings: &container.NetworkSettings{
+								Networks: map[string]*network.EndpointSettings{
+									"bridge": {
+										IPAddress:         "172.17.0.2",
+										GlobalIPv6Address: "2001:db8::2",
+									},
+								},
+							},
+						},
+					},
+				},
+				Containers: []container.Summary{
+					{ID: "container1"},
+				},
+				Zones:       []string{"docker."},
+				LabelPrefix: "com.dokku.coredns-docker",
+			},
+			expected: generateRecordsExpected{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("172.17.0.2"), net.ParseIP("2001:db8::2")},
+				},
+				srvs: map[string][]srvRecord{
+					"_http._tcp.web.docker.": {
+						{target: "web.docker.", port: 80},
+					},
+				},
+				ptrs: map[string][]string{
+					mustReverseAddr("172.17.0.2"):  {"web.docker."},
+					mustReverseAddr("2001:db8::2"): {"web.docker."},
+				},
+			},
+		},
+		{
+			name: "link-local addresses are ignored by default",
+			input: GenerateRecordsInput{
+				Inspector: &mockContainerInspector{
+					inspections: map[string]container.InspectResponse{
+						"container1": {
+							ContainerJSONBase: &container.ContainerJSONBase{
+								Name: "/web",
+								HostConfig: &container.HostConfig{
+									NetworkMode: container.NetworkMode("bridge"),
+								},
+							},
+							Config: &container.Config{
+								Labels: map[string]string{},
+							},
+							NetworkSettings: &container.NetworkSettings{
+								Networks: map[string]*network.EndpointSettings{
+									"bridge": {
+										IPAddress:         "172.17.0.2",
+										GlobalIPv6Address: "2001:db8::2",
+										IPAMConfig: &network.EndpointIPAMConfig{
+											LinkLocalIPs: []string{"fe80::2", "169.254.0.2"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				Containers: []container.Summary{
+					{ID: "container1"},
+				},
+				Zones:       []string{"docker."},
+				LabelPrefix: "com.dokku.coredns-docker",
+			},
+			expected: generateRecordsExpected{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("172.17.0.2"), net.ParseIP("2001:db8::2")},
+				},
+				srvs: map[string][]srvRecord{},
+				ptrs: map[string][]string{
+					mustReverseAddr("172.17.0.2"):  {"web.docker."},
+					mustReverseAddr("2001:db8::2"): {"web.docker."},
+				},
+			},
+		},
+		{
+			name: "link-local addresses published when enabled",
+			input: GenerateRecordsInput{
+				Inspector: &mockContainerInspector{
+					inspections: map[string]container.InspectResponse{
+						"container1": {
+							ContainerJSONBase: &container.ContainerJSONBase{
+								Name: "/web",
+								HostConfig: &container.HostConfig{
+									NetworkMode: container.NetworkMode("bridge"),
+								},
+							},
+							Config: &container.Config{
+								Labels: map[string]string{},
+							},
+							NetworkSettings: &container.NetworkSettings{
+								Networks: map[string]*network.EndpointSettings{
+									"bridge": {
+										IPAddress:         "172.17.0.2",
+										GlobalIPv6Address: "2001:db8::2",
+										IPAMConfig: &network.EndpointIPAMConfig{
+											LinkLocalIPs: []string{"fe80::2", "169.254.0.2", "not-an-ip"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				Containers: []container.Summary{
+					{ID: "container1"},
+				},
+				Zones:       []string{"docker."},
+				LabelPrefix: "com.dokku.coredns-docker",
+				LinkLocal:   true,
+			},
+			expected: generateRecordsExpected{
+				records: map[string][]net.IP{
+					"web.docker.": {
+						net.ParseIP("172.17.0.2"),
+						net.ParseIP("169.254.0.2"),
+						net.ParseIP("2001:db8::2"),
+						net.ParseIP("fe80::2"),
+					},
+				},
+				srvs: map[string][]srvRecord{},
+				ptrs: map[string][]string{
+					mustReverseAddr("172.17.0.2"):  {"web.docker."},
+					mustReverseAddr("169.254.0.2"): {"web.docker."},
+					mustReverseAddr("2001:db8::2"): {"web.docker."},
+					mustReverseAddr("fe80::2"):     {"web.docker."},
+				},
+			},
+		},
+		{
+			name: "network filtering publishes IPv6 addresses on each allowed network",
+			input: GenerateRecordsInput{
+				Inspector: &mockContainerInspector{
+					inspections: map[string]container.InspectResponse{
+						"container1": {
+							ContainerJSONBase: &container.ContainerJSONBase{
+								Name: "/web",
+								HostConfig: &container.HostConfig{
+									NetworkMode: container.NetworkMode("frontend"),
+								},
+							},
+							Config: &container.Config{
+								Labels: map[string]string{},
+							},
+							NetworkSettings: &container.NetworkSettings{
+								Networks: map[string]*network.EndpointSettings{
+									"frontend": {
+										IPAddress:         "172.18.0.2",
+										GlobalIPv6Address: "fd00:18::2",
+									},
+									"backend": {
+										GlobalIPv6Address: "fd00:19::2",
+									},
+								},
+							},
+						},
+					},
+				},
+				Containers: []container.Summary{
+					{ID: "container1"},
+				},
+				Zones:       []string{"docker."},
+				LabelPrefix: "com.dokku.coredns-docker",
+				Networks:    []string{"frontend", "backend"},
+			},
+			expected: generateRecordsExpected{
+				records: map[string][]net.IP{
+					"web.docker.": {net.ParseIP("fd00:19::2"), net.ParseIP("172.18.0.2"), net.ParseIP("fd00:18::2")},
+				},
+				srvs: map[string][]srvRecord{},
+				ptrs: map[string][]string{
+					mustReverseAddr("fd00:19::2"): {"web.docker."},
+					mustReverseAddr("172.18.0.2"): {"web.docker."},
+					mustReverseAddr("fd00:18::2"): {"web.docker."},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
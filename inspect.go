@@ -0,0 +1,228 @@
+package docker
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// defaultInspectConcurrency is the number of containers a full sync
+// inspects in parallel when inspect_concurrency is not configured.
+const defaultInspectConcurrency = 8
+
+// inspectResult is the outcome of inspecting one container.
+type inspectResult struct {
+	container Container
+	err       error
+}
+
+// inspectContainers inspects every container in the input and returns the
+// results in input order. Up to input.Concurrency inspects run at once;
+// a limit of 1 or less inspects serially. Containers whose fingerprint
+// is unchanged since they were last inspected are served from
+// input.Cache without a round trip to the source.
+func inspectContainers(ctx context.Context, input GenerateRecordsInput) []inspectResult {
+	results := make([]inspectResult, len(input.Refs))
+	inspect := func(i int) {
+		ref := input.Refs[i]
+		cacheable := ref.Fingerprint != 0
+		if cacheable {
+			if cached, ok := input.Cache.get(ref.ID, ref.Fingerprint); ok {
+				results[i] = inspectResult{container: cached}
+				return
+			}
+		}
+		c, err := input.Source.Inspect(ctx, ref.ID)
+		if err == nil && cacheable {
+			input.Cache.put(ref.ID, ref.Fingerprint, c)
+		}
+		results[i] = inspectResult{container: c, err: err}
+	}
+
+	workers := min(input.Concurrency, len(input.Refs))
+	if workers <= 1 {
+		for i := range input.Refs {
+			inspect(i)
+		}
+	} else {
+		next := make(chan int)
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					inspect(i)
+				}
+			}()
+		}
+		for i := range input.Refs {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	}
+
+	if input.Cache != nil {
+		ids := make([]string, len(input.Refs))
+		for i, ref := range input.Refs {
+			ids[i] = ref.ID
+		}
+		input.Cache.retain(ids)
+	}
+	return results
+}
+
+// inspectCache remembers the last inspected state of each container,
+// keyed by the fingerprint its source listed it with. For Docker that is
+// a hash of the container's list summary, so a full resync only
+// re-inspects containers whose summary changed since the last one:
+// anything that affects records (names, state, health, labels, network
+// endpoints and addresses, ports) shows up in the summary, while the
+// uptime in its Status string does not count. A nil cache is valid and
+// never hits.
+type inspectCache struct {
+	mu      sync.Mutex
+	entries map[string]inspectCacheEntry
+}
+
+type inspectCacheEntry struct {
+	fingerprint uint64
+	container   Container
+}
+
+// newInspectCache returns an empty inspectCache.
+func newInspectCache() *inspectCache {
+	return &inspectCache{entries: make(map[string]inspectCacheEntry)}
+}
+
+// get returns the cached state of a container if its fingerprint still
+// matches, and counts the lookup as a hit or miss.
+func (c *inspectCache) get(id string, fingerprint uint64) (Container, bool) {
+	if c == nil {
+		return Container{}, false
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[id]
+	c.mu.Unlock()
+	if !ok || entry.fingerprint != fingerprint {
+		inspectCacheMissCount.Inc()
+		return Container{}, false
+	}
+	inspectCacheHitCount.Inc()
+	return entry.container, true
+}
+
+// put stores a container's state under the given fingerprint.
+func (c *inspectCache) put(id string, fingerprint uint64, ctr Container) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.entries[id] = inspectCacheEntry{fingerprint: fingerprint, container: ctr}
+	c.mu.Unlock()
+}
+
+// invalidate drops a container's cached state, so the next full sync
+// inspects it again. Container events call this for the container they
+// name.
+func (c *inspectCache) invalidate(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.mu.Unlock()
+}
+
+// retain drops cached state for every container not in ids.
+func (c *inspectCache) retain(ids []string) {
+	if c == nil {
+		return
+	}
+	keep := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		keep[id] = struct{}{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.entries {
+		if _, ok := keep[id]; !ok {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// summaryFingerprint hashes the parts of a container's list summary that
+// change whenever its inspect response changes in a way that matters for
+// records. Restarts and network connect/disconnect give the container a
+// new endpoint ID, so they change the fingerprint even when the
+// container's IPs stay the same.
+func summaryFingerprint(c container.Summary) uint64 {
+	h := fnv.New64a()
+	field := func(s string) {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+
+	for _, name := range c.Names {
+		field(name)
+	}
+	field(string(c.State))
+	field(summaryHealth(c.Status))
+	field(c.HostConfig.NetworkMode)
+
+	keys := make([]string, 0, len(c.Labels))
+	for k := range c.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		field(k)
+		field(c.Labels[k])
+	}
+
+	if c.NetworkSettings != nil {
+		names := make([]string, 0, len(c.NetworkSettings.Networks))
+		for name := range c.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field(name)
+			if ep := c.NetworkSettings.Networks[name]; ep != nil {
+				field(ep.EndpointID)
+				field(ep.IPAddress)
+				field(ep.GlobalIPv6Address)
+			}
+		}
+	}
+
+	// The daemon does not list ports in a stable order.
+	ports := make([]string, 0, len(c.Ports))
+	for _, p := range c.Ports {
+		ports = append(ports, p.IP+"|"+strconv.Itoa(int(p.PrivatePort))+"|"+strconv.Itoa(int(p.PublicPort))+"|"+p.Type)
+	}
+	sort.Strings(ports)
+	for _, p := range ports {
+		field(p)
+	}
+	return h.Sum64()
+}
+
+// summaryHealth extracts the healthcheck status Docker appends to a list
+// summary's Status string, such as "(healthy)" in "Up 5 minutes
+// (healthy)", or "" when the container has no healthcheck.
+func summaryHealth(status string) string {
+	start := strings.LastIndexByte(status, '(')
+	if start < 0 || !strings.HasSuffix(status, ")") {
+		return ""
+	}
+	return status[start:]
+}
@@ -0,0 +1,275 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// countingInspector wraps mockContainerInspector, counting inspects per
+// container and tracking the peak number of inspects in flight.
+type countingInspector struct {
+	mockContainerInspector
+	delay time.Duration
+
+	mu       sync.Mutex
+	calls    map[string]int
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (c *countingInspector) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	n := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	for {
+		peak := c.peak.Load()
+		if n <= peak || c.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	c.mu.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]int)
+	}
+	c.calls[containerID]++
+	c.mu.Unlock()
+	time.Sleep(c.delay)
+	return c.mockContainerInspector.ContainerInspect(ctx, containerID)
+}
+
+func (c *countingInspector) callCount(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[id]
+}
+
+// runningSummary returns the list summary for a running container on the
+// default bridge network.
+func runningSummary(id, name, endpointID string) container.Summary {
+	return container.Summary{
+		ID:     id,
+		Names:  []string{"/" + name},
+		State:  container.StateRunning,
+		Status: "Up 5 minutes",
+		NetworkSettings: &container.NetworkSettingsSummary{
+			Networks: map[string]*network.EndpointSettings{
+				"bridge": {EndpointID: endpointID},
+			},
+		},
+	}
+}
+
+func TestGenerateRecordSetsParallelKeepsOrder(t *testing.T) {
+	const count = 20
+	inspector := &countingInspector{
+		mockContainerInspector: mockContainerInspector{
+			inspections: map[string]container.InspectResponse{},
+			errors:      map[string]error{"c5": errInspectFailed},
+		},
+		delay: 5 * time.Millisecond,
+	}
+	var containers []container.Summary
+	var want []string
+	for i := range count {
+		id := fmt.Sprintf("c%d", i)
+		name := fmt.Sprintf("web%d", i)
+		inspector.inspections[id] = runningContainer(name, fmt.Sprintf("172.17.0.%d", i+2), nil)
+		containers = append(containers, runningSummary(id, name, "ep-"+id))
+		if id != "c5" {
+			want = append(want, id)
+		}
+	}
+
+	d := newSyncTestDocker()
+	d.inspectConcurrency = 4
+	input := d.generateInput(containers, inspector)
+	order, sets := generateRecordSets(context.Background(), input)
+
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("expected order %v, got %v", want, order)
+	}
+	if _, ok := sets["c5"]; ok {
+		t.Errorf("expected failed inspect to be left out")
+	}
+	if got := inspector.peak.Load(); got > 4 {
+		t.Errorf("expected at most 4 concurrent inspects, got %d", got)
+	}
+	if got := inspector.peak.Load(); got < 2 {
+		t.Errorf("expected inspects to run in parallel, peak was %d", got)
+	}
+
+	input.Concurrency = 1
+	serialRecords, _, _, _, _ := generateRecords(context.Background(), input)
+	input.Concurrency = 4
+	parallelRecords, _, _, _, _ := generateRecords(context.Background(), input)
+	if fmt.Sprint(serialRecords) != fmt.Sprint(parallelRecords) {
+		t.Errorf("expected parallel and serial inspection to produce the same records")
+	}
+}
+
+func TestInspectCacheSkipsUnchangedContainers(t *testing.T) {
+	inspector := &countingInspector{
+		mockContainerInspector: mockContainerInspector{
+			inspections: map[string]container.InspectResponse{
+				"c1": runningContainer("web1", "172.17.0.2", nil),
+				"c2": runningContainer("web2", "172.17.0.3", nil),
+			},
+		},
+	}
+	d := newSyncTestDocker()
+	d.inspectCache = newInspectCache()
+	containers := []container.Summary{
+		runningSummary("c1", "web1", "ep-1"),
+		runningSummary("c2", "web2", "ep-2"),
+	}
+
+	hitsBefore := testutil.ToFloat64(inspectCacheHitCount)
+	missesBefore := testutil.ToFloat64(inspectCacheMissCount)
+
+	generateRecordSets(context.Background(), d.generateInput(containers, inspector))
+	// Only the uptime changed: both containers come from the cache.
+	containers[0].Status = "Up 6 minutes"
+	containers[1].Status = "Up 6 minutes"
+	generateRecordSets(context.Background(), d.generateInput(containers, inspector))
+
+	if got := inspector.callCount("c1"); got != 1 {
+		t.Errorf("expected c1 to be inspected once, got %d", got)
+	}
+	if got := testutil.ToFloat64(inspectCacheHitCount) - hitsBefore; got != 2 {
+		t.Errorf("expected 2 cache hits, got %f", got)
+	}
+	if got := testutil.ToFloat64(inspectCacheMissCount) - missesBefore; got != 2 {
+		t.Errorf("expected 2 cache misses, got %f", got)
+	}
+
+	// A restart gives c1 a new endpoint; c2's health changes.
+	containers[0] = runningSummary("c1", "web1", "ep-1b")
+	containers[1].Status = "Up 6 minutes (unhealthy)"
+	generateRecordSets(context.Background(), d.generateInput(containers, inspector))
+	if got := inspector.callCount("c1"); got != 2 {
+		t.Errorf("expected c1 to be re-inspected after its endpoint changed, got %d inspects", got)
+	}
+	if got := inspector.callCount("c2"); got != 2 {
+		t.Errorf("expected c2 to be re-inspected after its health changed, got %d inspects", got)
+	}
+}
+
+func TestInspectCacheDropsGoneContainers(t *testing.T) {
+	inspector := &countingInspector{
+		mockContainerInspector: mockContainerInspector{
+			inspections: map[string]container.InspectResponse{
+				"c1": runningContainer("web1", "172.17.0.2", nil),
+				"c2": runningContainer("web2", "172.17.0.3", nil),
+			},
+			errors: map[string]error{},
+		},
+	}
+	d := newSyncTestDocker()
+	d.inspectCache = newInspectCache()
+	both := []container.Summary{
+		runningSummary("c1", "web1", "ep-1"),
+		runningSummary("c2", "web2", "ep-2"),
+	}
+
+	generateRecordSets(context.Background(), d.generateInput(both, inspector))
+	generateRecordSets(context.Background(), d.generateInput(both[:1], inspector))
+	if _, ok := d.inspectCache.entries["c2"]; ok {
+		t.Errorf("expected c2 to be dropped from the cache once it is no longer listed")
+	}
+
+	// Failed inspects are not cached.
+	inspector.errors["c2"] = errInspectFailed
+	generateRecordSets(context.Background(), d.generateInput(both, inspector))
+	if _, ok := d.inspectCache.entries["c2"]; ok {
+		t.Errorf("expected a failed inspect not to be cached")
+	}
+}
+
+func TestApplyEventsInvalidatesInspectCache(t *testing.T) {
+	inspector := &countingInspector{
+		mockContainerInspector: mockContainerInspector{
+			inspections: map[string]container.InspectResponse{
+				"c1": runningContainer("web1", "172.17.0.2", nil),
+			},
+		},
+	}
+	d := newSyncTestDocker()
+	d.inspectCache = newInspectCache()
+	containers := []container.Summary{runningSummary("c1", "web1", "ep-1")}
+
+	generateRecordSets(context.Background(), d.generateInput(containers, inspector))
+	d.handleEvent(context.Background(), inspector, containerEvent(events.ActionHealthStatus, "c1"))
+	generateRecordSets(context.Background(), d.generateInput(containers, inspector))
+
+	// One inspect for the first sync, one for the event, and one for the
+	// sync after the event invalidated the cache.
+	if got := inspector.callCount("c1"); got != 3 {
+		t.Errorf("expected 3 inspects, got %d", got)
+	}
+}
+
+func TestSummaryFingerprint(t *testing.T) {
+	base := runningSummary("c1", "web1", "ep-1")
+	base.Labels = map[string]string{"a": "1", "b": "2"}
+	base.Ports = []container.Port{
+		{PrivatePort: 80, PublicPort: 8080, Type: "tcp"},
+		{PrivatePort: 443, PublicPort: 8443, Type: "tcp"},
+	}
+	fp := summaryFingerprint(base)
+
+	same := base
+	same.Status = "Up 2 hours"
+	same.Ports = []container.Port{base.Ports[1], base.Ports[0]}
+	if summaryFingerprint(same) != fp {
+		t.Errorf("expected uptime and port order not to change the fingerprint")
+	}
+
+	changes := map[string]func(*container.Summary){
+		"name":   func(s *container.Summary) { s.Names = []string{"/web2"} },
+		"state":  func(s *container.Summary) { s.State = container.StatePaused },
+		"health": func(s *container.Summary) { s.Status = "Up 5 minutes (healthy)" },
+		"label":  func(s *container.Summary) { s.Labels = map[string]string{"a": "1", "b": "3"} },
+		"port":   func(s *container.Summary) { s.Ports = base.Ports[:1] },
+		"network": func(s *container.Summary) {
+			s.NetworkSettings = &container.NetworkSettingsSummary{
+				Networks: map[string]*network.EndpointSettings{
+					"bridge": {EndpointID: "ep-1", IPAddress: "172.17.0.9"},
+				},
+			}
+		},
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			changed := base
+			change(&changed)
+			if summaryFingerprint(changed) == fp {
+				t.Errorf("expected a %s change to change the fingerprint", name)
+			}
+		})
+	}
+}
+
+func TestSummaryHealth(t *testing.T) {
+	tests := map[string]string{
+		"Up 5 minutes":                    "",
+		"Up 5 minutes (healthy)":          "(healthy)",
+		"Up 5 minutes (unhealthy)":        "(unhealthy)",
+		"Up 3 seconds (health: starting)": "(health: starting)",
+		"Up About a minute (Paused)":      "(Paused)",
+		"Exited (0) 2 minutes ago":        "",
+		"":                                "",
+	}
+	for status, want := range tests {
+		if got := summaryHealth(status); got != want {
+			t.Errorf("summaryHealth(%q) = %q, want %q", status, got, want)
+		}
+	}
+}
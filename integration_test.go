@@ -92,11 +92,6 @@ func setupIntegrationDocker(t *testing.T, networks []string) (*Docker, *client.C
 		labelPrefix: "com.dokku.coredns-docker",
 		client:      cli,
 		networks:    networks,
-		records:     make(map[string][]net.IP),
-		srvs:        make(map[string][]srvRecord),
-		ptrs:        make(map[string][]string),
-		cnames:      make(map[string]string),
-		txts:        make(map[string][][]string),
 	}
 
 	return d, cli
@@ -294,8 +289,6 @@ func TestIntegrationNetworkAlias(t *testing.T) {
 		labelPrefix: "com.dokku.coredns-docker",
 		client:      cli,
 		networks:    []string{networkName},
-		records:     make(map[string][]net.IP),
-		srvs:        make(map[string][]srvRecord),
 	}
 
 	alias := "myservicealias"
@@ -402,7 +395,7 @@ func TestIntegrationNoFallthrough(t *testing.T) {
 
 func TestIntegrationSOAQuery(t *testing.T) {
 	d, _ := setupIntegrationDocker(t, nil)
-	d.connected = true
+	d.setConnected(true)
 
 	resp, _, err := queryDNS(t, d, "docker.", dns.TypeSOA)
 	if err != nil {
@@ -434,7 +427,7 @@ func TestIntegrationSOAQuery(t *testing.T) {
 
 func TestIntegrationNSQuery(t *testing.T) {
 	d, _ := setupIntegrationDocker(t, nil)
-	d.connected = true
+	d.setConnected(true)
 
 	resp, _, err := queryDNS(t, d, "docker.", dns.TypeNS)
 	if err != nil {
@@ -474,8 +467,6 @@ func TestIntegrationMultiZone(t *testing.T) {
 		zones:       []string{"docker.", "internal."},
 		labelPrefix: "com.dokku.coredns-docker",
 		client:      cli,
-		records:     make(map[string][]net.IP),
-		srvs:        make(map[string][]srvRecord),
 	}
 
 	ctx := context.Background()
@@ -520,9 +511,7 @@ func TestIntegrationMultiZone(t *testing.T) {
 func TestIntegrationReadyConnected(t *testing.T) {
 	d, _ := setupIntegrationDocker(t, nil)
 
-	d.mu.Lock()
-	d.connected = true
-	d.mu.Unlock()
+	d.setConnected(true)
 
 	d.syncRecords(context.Background())
 
@@ -536,9 +525,7 @@ func TestIntegrationReadyDisconnectedWithRecords(t *testing.T) {
 
 	d.syncRecords(context.Background())
 
-	d.mu.Lock()
-	d.connected = false
-	d.mu.Unlock()
+	d.setConnected(false)
 
 	if !d.Ready() {
 		t.Error("expected Ready() to return true when disconnected but has synced records")
@@ -548,9 +535,7 @@ func TestIntegrationReadyDisconnectedWithRecords(t *testing.T) {
 func TestIntegrationReadyDisconnectedNoSync(t *testing.T) {
 	d, _ := setupIntegrationDocker(t, nil)
 
-	d.mu.Lock()
-	d.connected = false
-	d.mu.Unlock()
+	d.setConnected(false)
 
 	if d.Ready() {
 		t.Error("expected Ready() to return false when disconnected and never synced")
@@ -558,10 +543,7 @@ func TestIntegrationReadyDisconnectedNoSync(t *testing.T) {
 }
 
 func TestIntegrationReadyNoClient(t *testing.T) {
-	d := &Docker{
-		records: make(map[string][]net.IP),
-		srvs:    make(map[string][]srvRecord),
-	}
+	d := &Docker{}
 
 	if d.Ready() {
 		t.Error("expected Ready() to return false when client is nil")
@@ -704,15 +686,13 @@ func TestIntegrationCNAMELabel(t *testing.T) {
 	fqdn := name + ".docker."
 
 	// CNAME'd container must not appear in the A/AAAA map.
-	d.mu.RLock()
-	if _, ok := d.records[fqdn]; ok {
-		d.mu.RUnlock()
+	snap := d.loadSnapshot()
+	if _, ok := snap.records[fqdn]; ok {
 		t.Fatalf("expected no A records for CNAME'd container %s, but found some", fqdn)
 	}
 	// The cname map must contain the FQDN with the target normalized to a
 	// trailing dot.
-	target, ok := d.cnames[fqdn]
-	d.mu.RUnlock()
+	target, ok := snap.cnames[fqdn]
 	if !ok {
 		t.Fatalf("expected CNAME entry for %s, not found", fqdn)
 	}
@@ -752,15 +732,13 @@ func TestIntegrationCNAMELabel(t *testing.T) {
 func TestIntegrationLastSyncTimeSet(t *testing.T) {
 	d, _ := setupIntegrationDocker(t, nil)
 
-	if !d.lastSyncTime.IsZero() {
+	if !d.loadSnapshot().lastSyncTime.IsZero() {
 		t.Error("expected lastSyncTime to be zero before sync")
 	}
 
 	d.syncRecords(context.Background())
 
-	d.mu.RLock()
-	syncTime := d.lastSyncTime
-	d.mu.RUnlock()
+	syncTime := d.loadSnapshot().lastSyncTime
 
 	if syncTime.IsZero() {
 		t.Error("expected lastSyncTime to be set after sync")
@@ -1441,25 +1419,20 @@ func TestIntegrationStartEventLoop(t *testing.T) {
 	}()
 
 	// Wait briefly for the loop to establish its Events subscription and
-	// flip d.connected to true. The loop sets connected inside the for
+	// mark the plugin connected. The loop sets connected inside the for
 	// header, after calling Events, so a short poll is sufficient.
 	deadline := time.Now().Add(1 * time.Second)
 	for time.Now().Before(deadline) {
-		d.mu.RLock()
-		connected := d.connected
-		d.mu.RUnlock()
+		connected := d.loadSnapshot().connected
 		if connected {
 			break
 		}
 		time.Sleep(20 * time.Millisecond)
 	}
 
-	d.mu.RLock()
-	if !d.connected {
-		d.mu.RUnlock()
-		t.Fatal("startEventLoop did not mark d.connected within 1s")
+	if !d.loadSnapshot().connected {
+		t.Fatal("startEventLoop did not mark the plugin connected within 1s")
 	}
-	d.mu.RUnlock()
 
 	cancel()
 
@@ -1490,9 +1463,7 @@ func TestIntegrationStartEventLoopHandlesContainerEvent(t *testing.T) {
 	// Wait for the loop to establish its event subscription.
 	deadline := time.Now().Add(2 * time.Second)
 	for time.Now().Before(deadline) {
-		d.mu.RLock()
-		connected := d.connected
-		d.mu.RUnlock()
+		connected := d.loadSnapshot().connected
 		if connected {
 			break
 		}
@@ -1507,15 +1478,13 @@ func TestIntegrationStartEventLoopHandlesContainerEvent(t *testing.T) {
 		Cmd:   []string{"sleep", "3600"},
 	}, nil, nil)
 
-	// Poll until the container shows up in d.records, which indicates
+	// Poll until the container shows up in the snapshot, which indicates
 	// that the event-driven resync has completed.
 	fqdn := name + ".docker."
 	recordSeen := false
 	deadline = time.Now().Add(3 * time.Second)
 	for time.Now().Before(deadline) {
-		d.mu.RLock()
-		_, recordSeen = d.records[fqdn]
-		d.mu.RUnlock()
+		_, recordSeen = d.loadSnapshot().records[fqdn]
 		if recordSeen {
 			break
 		}
@@ -1533,3 +1502,165 @@ func TestIntegrationStartEventLoopHandlesContainerEvent(t *testing.T) {
 	}
 }
 
+// TestIntegrationStartEventLoopRemovesStoppedContainer verifies that a
+// container's records disappear once its die/stop events are handled,
+// without waiting for a full resync.
+func TestIntegrationStartEventLoopRemovesStoppedContainer(t *testing.T) {
+	d, cli := setupIntegrationDocker(t, nil)
+
+	name := testContainerName(t, "")
+	id := createTestContainer(t, cli, name, &container.Config{
+		Image: "alpine:latest",
+		Cmd:   []string{"sleep", "3600"},
+	}, nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.startEventLoop(ctx)
+		close(done)
+	}()
+
+	fqdn := name + ".docker."
+	waitForRecord := func(want bool) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			_, ok := d.loadSnapshot().records[fqdn]
+			if ok == want {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+
+	if !waitForRecord(true) {
+		t.Fatalf("expected %s after the initial sync", fqdn)
+	}
+
+	timeout := 1
+	if err := cli.ContainerStop(context.Background(), id, container.StopOptions{Timeout: &timeout}); err != nil {
+		t.Fatalf("Failed to stop container: %v", err)
+	}
+
+	if !waitForRecord(false) {
+		t.Fatalf("expected %s to be removed after the container stopped", fqdn)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("startEventLoop did not return within 2s after ctx cancel")
+	}
+}
+
+// TestIntegrationResyncLoopHealsMissedEvent simulates a dropped Docker
+// event by forgetting a container's records, then checks that the
+// periodic resync restores them and counts the drift.
+func TestIntegrationResyncLoopHealsMissedEvent(t *testing.T) {
+	d, cli := setupIntegrationDocker(t, nil)
+	d.resyncInterval = 100 * time.Millisecond
+	d.setConnected(true)
+
+	name := testContainerName(t, "")
+	createTestContainer(t, cli, name, &container.Config{
+		Image: "alpine:latest",
+		Cmd:   []string{"sleep", "3600"},
+	}, nil, nil)
+
+	if err := d.syncRecords(context.Background()); err != nil {
+		t.Fatalf("syncRecords failed: %v", err)
+	}
+
+	fqdn := name + ".docker."
+	d.syncMu.Lock()
+	for id := range d.containerSets {
+		if rs := d.containerSets[id]; rs != nil && len(rs.records[fqdn]) > 0 {
+			d.deleteContainerLocked(id)
+		}
+	}
+	d.publishLocked()
+	d.syncMu.Unlock()
+
+	driftBefore := testutil.ToFloat64(resyncDriftCount)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go d.startResyncLoop(ctx)
+
+	deadline := time.Now().Add(3 * time.Second)
+	healed := false
+	for time.Now().Before(deadline) {
+		_, healed = d.loadSnapshot().records[fqdn]
+		if healed {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !healed {
+		t.Fatalf("expected periodic resync to restore %s", fqdn)
+	}
+	if drift := testutil.ToFloat64(resyncDriftCount); drift < driftBefore+1 {
+		t.Errorf("expected resync_drift_total to increase, before=%f after=%f", driftBefore, drift)
+	}
+}
+
+// TestIntegrationStartEventLoopHandlesRename verifies that `docker
+// rename` moves a container's records to its new name.
+func TestIntegrationStartEventLoopHandlesRename(t *testing.T) {
+	d, cli := setupIntegrationDocker(t, nil)
+
+	name := testContainerName(t, "old")
+	id := createTestContainer(t, cli, name, &container.Config{
+		Image: "alpine:latest",
+		Cmd:   []string{"sleep", "3600"},
+	}, nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.startEventLoop(ctx)
+		close(done)
+	}()
+
+	waitForRecord := func(fqdn string, want bool) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			_, ok := d.loadSnapshot().records[fqdn]
+			if ok == want {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+
+	oldFqdn := name + ".docker."
+	if !waitForRecord(oldFqdn, true) {
+		t.Fatalf("expected %s after the initial sync", oldFqdn)
+	}
+
+	newName := testContainerName(t, "new")
+	if err := cli.ContainerRename(context.Background(), id, newName); err != nil {
+		t.Fatalf("Failed to rename container: %v", err)
+	}
+
+	if !waitForRecord(newName+".docker.", true) {
+		t.Fatalf("expected %s.docker. after rename", newName)
+	}
+	if !waitForRecord(oldFqdn, false) {
+		t.Fatalf("expected %s to be removed after rename", oldFqdn)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("startEventLoop did not return within 2s after ctx cancel")
+	}
+}
@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"hash/fnv"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// journalSize is how many changes of a zone are kept for IXFR. A
+// secondary that is further behind gets a full transfer.
+const journalSize = 100
+
+// zoneVersion is a zone's serial, the hash of the records it stands for,
+// and the changes that led up to it. Like the rest of the answer table it
+// is never modified once published; a change produces a new one.
+type zoneVersion struct {
+	serial uint32
+	hash   uint64
+	// journal holds the latest changes, oldest first. Each starts at the
+	// serial the previous one ended at, and the last ends at serial.
+	journal []zoneDiff
+}
+
+// zoneDiff is one change of a zone: the records deleted and added going
+// from serial from to serial to.
+type zoneDiff struct {
+	from, to       uint32
+	deleted, added []dns.RR
+}
+
+// version returns the zone's version in the table being built, a, given
+// the version in prev, the table it replaces. Records that hash the same,
+// or differ only in order, keep the serial. Changed records get a new
+// serial, the current Unix time unless that would not be higher, and the
+// difference is journalled.
+// The first version of a zone is seeded with the current time too, so
+// serials keep increasing across restarts.
+func (d *Docker) version(prev, a *answerTable, zone string) *zoneVersion {
+	records := d.zoneRecords(a, zone)
+	hash := hashRecords(records)
+	old, ok := prev.version(zone)
+	if !ok {
+		return &zoneVersion{serial: uint32(time.Now().Unix()), hash: hash}
+	}
+	if old.hash == hash {
+		return old
+	}
+
+	deleted, added := diffRecords(d.zoneRecords(prev, zone), records)
+	if len(deleted) == 0 && len(added) == 0 {
+		return old
+	}
+	next := &zoneVersion{serial: max(old.serial+1, uint32(time.Now().Unix())), hash: hash}
+	journal := old.journal
+	if len(journal) >= journalSize {
+		journal = journal[len(journal)-journalSize+1:]
+	}
+	// Clip so the append copies rather than writing into old's journal.
+	next.journal = append(slices.Clip(journal), zoneDiff{from: old.serial, to: next.serial, deleted: deleted, added: added})
+	return next
+}
+
+// version returns a zone's version. It is safe to call on a nil
+// answerTable.
+func (a *answerTable) version(zone string) (*zoneVersion, bool) {
+	if a == nil {
+		return nil, false
+	}
+	v, ok := a.versions[zone]
+	return v, ok
+}
+
+// since returns the journalled changes from serial up to the current
+// one, or false if serial is not in the journal. It is safe to call on a
+// nil zoneVersion.
+func (v *zoneVersion) since(serial uint32) ([]zoneDiff, bool) {
+	if v == nil {
+		return nil, false
+	}
+	i := slices.IndexFunc(v.journal, func(diff zoneDiff) bool { return diff.from == serial })
+	if i < 0 {
+		return nil, false
+	}
+	return v.journal[i:], true
+}
+
+// hashRecords hashes the text form of a zone's records. The records are
+// sorted first, as the order within an RRset follows the order containers
+// were seen in, which a full sync can shuffle without changing anything.
+func hashRecords(rrs []dns.RR) uint64 {
+	lines := make([]string, len(rrs))
+	for i, rr := range rrs {
+		lines[i] = rr.String()
+	}
+	slices.Sort(lines)
+	h := fnv.New64a()
+	for _, line := range lines {
+		h.Write([]byte(line))
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum64()
+}
+
+// diffRecords returns the records in old but not in new, and those in new
+// but not in old, each in their original order.
+func diffRecords(old, new []dns.RR) (deleted, added []dns.RR) {
+	counts := make(map[string]int, len(old))
+	for _, rr := range old {
+		counts[rr.String()]++
+	}
+	for _, rr := range new {
+		if s := rr.String(); counts[s] > 0 {
+			counts[s]--
+		} else {
+			added = append(added, rr)
+		}
+	}
+	for _, rr := range old {
+		if s := rr.String(); counts[s] > 0 {
+			counts[s]--
+			deleted = append(deleted, rr)
+		}
+	}
+	return deleted, added
+}
@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func zoneSerial(t *testing.T, d *Docker, zone string) uint32 {
+	t.Helper()
+	return d.apexSOA(d.loadSnapshot(), zone).(*dns.SOA).Serial
+}
+
+func TestSerialFollowsChanges(t *testing.T) {
+	ctx := context.Background()
+	d := newTransferTestDocker(t, fixtureContainer("c1", "web", "172.17.0.2"))
+	src := d.source.(*fixtureSource)
+	serial := zoneSerial(t, d, "docker.")
+	if serial == 0 {
+		t.Fatal("expected a synced zone to have a serial")
+	}
+
+	if _, err := d.fullSync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := zoneSerial(t, d, "docker."); got != serial {
+		t.Errorf("expected a sync that changes nothing to keep serial %d, got %d", serial, got)
+	}
+
+	src.set(fixtureContainer("c2", "api", "172.17.0.3"))
+	d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c2"}})
+	if got := zoneSerial(t, d, "docker."); got <= serial {
+		t.Errorf("expected a change to raise serial %d, got %d", serial, got)
+	}
+}
+
+func TestSerialIgnoresRecordOrder(t *testing.T) {
+	ctx := context.Background()
+	shared := func(id, ip string) Container {
+		c := fixtureContainer(id, "web-"+id, ip)
+		c.Labels = map[string]string{"com.dokku.coredns-docker/hostname": "web"}
+		return c
+	}
+	d := newTransferTestDocker(t, shared("c2", "172.17.0.3"))
+	src := d.source.(*fixtureSource)
+
+	// The event appends c1 after c2; a full sync orders them by the list.
+	src.set(shared("c1", "172.17.0.2"))
+	d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c1"}})
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.3", "172.17.0.2")
+	serial := zoneSerial(t, d, "docker.")
+	v, _ := d.loadSnapshot().answers.version("docker.")
+	journalled := len(v.journal)
+
+	if _, err := d.fullSync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2", "172.17.0.3")
+	if got := zoneSerial(t, d, "docker."); got != serial {
+		t.Errorf("expected reordered records to keep serial %d, got %d", serial, got)
+	}
+	if v, _ := d.loadSnapshot().answers.version("docker."); len(v.journal) != journalled {
+		t.Errorf("expected no journal entry for reordered records, got %d entries after %d", len(v.journal), journalled)
+	}
+}
+
+func TestTransferIXFRJournal(t *testing.T) {
+	ctx := context.Background()
+	d := newTransferTestDocker(t, fixtureContainer("c1", "web", "172.17.0.2"))
+	src := d.source.(*fixtureSource)
+	from := zoneSerial(t, d, "docker.")
+
+	src.set(fixtureContainer("c1", "web", "172.17.0.5"))
+	d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c1"}})
+	to := zoneSerial(t, d, "docker.")
+
+	ch, err := d.Transfer("docker.", from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rr := range collectTransfer(t, ch) {
+		switch rr := rr.(type) {
+		case *dns.SOA:
+			got = append(got, fmt.Sprintf("SOA %d", rr.Serial))
+		case *dns.A:
+			got = append(got, rr.Header().Name+" "+rr.A.String())
+		default:
+			got = append(got, rr.String())
+		}
+	}
+	want := []string{
+		fmt.Sprintf("SOA %d", to),
+		fmt.Sprintf("SOA %d", from), "web.docker. 172.17.0.2",
+		fmt.Sprintf("SOA %d", to), "web.docker. 172.17.0.5",
+		fmt.Sprintf("SOA %d", to),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected the incremental transfer\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestJournalBounded(t *testing.T) {
+	ctx := context.Background()
+	d := newTransferTestDocker(t, fixtureContainer("c1", "web", "172.17.0.2"))
+	src := d.source.(*fixtureSource)
+	first := zoneSerial(t, d, "docker.")
+
+	for i := range journalSize + 5 {
+		src.set(fixtureContainer("c1", "web", fmt.Sprintf("172.17.1.%d", i)))
+		d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c1"}})
+	}
+	v, _ := d.loadSnapshot().answers.version("docker.")
+	if len(v.journal) != journalSize {
+		t.Fatalf("expected the journal to keep %d changes, got %d", journalSize, len(v.journal))
+	}
+	for i := 1; i < len(v.journal); i++ {
+		if v.journal[i].from != v.journal[i-1].to {
+			t.Fatalf("expected a continuous journal, got %d after %d", v.journal[i].from, v.journal[i-1].to)
+		}
+	}
+
+	// A serial that fell out of the journal gets a full transfer.
+	ch, err := d.Transfer("docker.", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrs := collectTransfer(t, ch)
+	if _, ok := rrs[1].(*dns.NS); !ok {
+		t.Errorf("expected a full transfer for a serial no longer journalled, got %v", rrs)
+	}
+}
+
+func TestDiffRecords(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	old := []dns.RR{rr("a.docker. 30 IN A 10.0.0.1"), rr("b.docker. 30 IN A 10.0.0.2"), rr("b.docker. 30 IN A 10.0.0.2")}
+	new := []dns.RR{rr("b.docker. 30 IN A 10.0.0.2"), rr("c.docker. 30 IN A 10.0.0.3")}
+	deleted, added := diffRecords(old, new)
+	if len(deleted) != 2 || deleted[0].Header().Name != "a.docker." || deleted[1].Header().Name != "b.docker." {
+		t.Errorf("expected a.docker. and one b.docker. deleted, got %v", deleted)
+	}
+	if len(added) != 1 || added[0].Header().Name != "c.docker." {
+		t.Errorf("expected c.docker. added, got %v", added)
+	}
+}
@@ -93,6 +93,34 @@ var (
 		Name:      "connected",
 		Help:      "Whether the plugin is connected to the Docker daemon (1 = connected, 0 = disconnected).",
 	})
+	// heartbeatFailureCount is the number of failed Docker daemon heartbeats.
+	heartbeatFailureCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "heartbeat_failures_total",
+		Help:      "Counter of Docker daemon heartbeats that failed, timed out, or saw a new daemon ID.",
+	})
+	// inspectCacheHitCount is the number of container inspects served from the inspect cache.
+	inspectCacheHitCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "inspect_cache_hits_total",
+		Help:      "Counter of container inspects during full syncs served from the inspect cache.",
+	})
+	// inspectCacheMissCount is the number of container inspects that had to query the Docker daemon.
+	inspectCacheMissCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "inspect_cache_misses_total",
+		Help:      "Counter of container inspects during full syncs that missed the inspect cache and queried the Docker daemon.",
+	})
+	// inspectFailureCount is the number of failed container inspects.
+	inspectFailureCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "inspect_failures_total",
+		Help:      "Counter of failed container inspects.",
+	})
 	// containersCount is the number of Docker containers currently tracked.
 	containersCount = promauto.NewGauge(prometheus.GaugeOpts{
 		Namespace: plugin.Namespace,
@@ -108,6 +136,20 @@ var (
 		Help:      "Histogram of record sync durations in seconds.",
 		Buckets:   prometheus.DefBuckets,
 	})
+	// syncCoalescedCount is the number of Docker events folded into another event's sync by sync_debounce.
+	syncCoalescedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "sync_coalesced_events_total",
+		Help:      "Counter of Docker events coalesced into another event's sync by sync_debounce.",
+	})
+	// resyncDriftCount is the number of containers whose records a periodic resync found out of date.
+	resyncDriftCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "resync_drift_total",
+		Help:      "Counter of containers whose records a periodic resync found out of date.",
+	})
 	// syncErrorCount is the number of failed record sync attempts.
 	syncErrorCount = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: plugin.Namespace,
@@ -115,4 +157,39 @@ var (
 		Name:      "sync_errors_total",
 		Help:      "Counter of failed record sync attempts.",
 	})
+	// endpointConnectedGauge is whether each Docker endpoint is connected.
+	endpointConnectedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "endpoint_connected",
+		Help:      "Whether the plugin is connected to each Docker endpoint (1 = connected, 0 = disconnected).",
+	}, []string{"endpoint"})
+	// endpointLastSyncTimestamp is the Unix timestamp of each Docker endpoint's last successful sync.
+	endpointLastSyncTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "endpoint_last_sync_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful record sync from each Docker endpoint.",
+	}, []string{"endpoint"})
+	// snapshotFileErrorCount is the number of failed snapshot_file writes.
+	snapshotFileErrorCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "snapshot_file_errors_total",
+		Help:      "Counter of failed writes of the snapshot file.",
+	})
+	// dnssecSignatureCount is the number of RRSIGs made by online signing.
+	dnssecSignatureCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "dnssec_signatures_total",
+		Help:      "Counter of RRSIG records made by online DNSSEC signing; cached signatures are not counted.",
+	})
+	// updateCount is the number of dynamic update requests, by response code.
+	updateCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "dynamic_updates_total",
+		Help:      "Counter of dynamic update requests by response code.",
+	}, []string{"server", "rcode"})
 )
@@ -0,0 +1,185 @@
+package docker
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+// Defaults for the synthetic SOA and NS records. Names without a trailing
+// dot are relative to each zone.
+const (
+	defaultNSName     = "ns.dns"
+	defaultSOAMbox    = "hostmaster"
+	defaultSOARefresh = 7200
+	defaultSOARetry   = 1800
+	defaultSOAExpire  = 86400
+)
+
+// nameservers returns the NS names of zone: the ns option's names, or
+// ns.dns.<zone>, made absolute and lowercased.
+func (d *Docker) nameservers(zone string) []string {
+	names := d.nsNames
+	if len(names) == 0 {
+		names = []string{defaultNSName}
+	}
+	fqdns := make([]string, len(names))
+	for i, name := range names {
+		fqdns[i] = qualify(name, zone)
+	}
+	return fqdns
+}
+
+// mbox returns the SOA RNAME of zone.
+func (d *Docker) mbox(zone string) string {
+	if d.soaMbox == "" {
+		return qualify(defaultSOAMbox, zone)
+	}
+	return qualify(d.soaMbox, zone)
+}
+
+// qualify makes name absolute, appending zone unless it ends in a dot.
+func qualify(name, zone string) string {
+	if dns.IsFqdn(name) {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(name + "." + zone)
+}
+
+// soaTimers returns the SOA refresh, retry and expire values.
+func (d *Docker) soaTimers() (refresh, retry, expire uint32) {
+	refresh, retry, expire = defaultSOARefresh, defaultSOARetry, defaultSOAExpire
+	if d.soaRefresh > 0 {
+		refresh, retry, expire = d.soaRefresh, d.soaRetry, d.soaExpire
+	}
+	return refresh, retry, expire
+}
+
+// negTTL returns how long negative answers may be cached: negative_ttl if
+// set, otherwise ttl. It is the SOA minimum and the TTL of the SOA and
+// NSEC records in negative answers (RFC 2308).
+func (d *Docker) negTTL() uint32 {
+	if d.negativeTTL != nil {
+		return *d.negativeTTL
+	}
+	return d.ttl
+}
+
+// negativeSOA returns the zone's SOA for the authority section of a
+// negative answer, with the negative caching TTL.
+func (d *Docker) negativeSOA(snap *snapshot, zone string) dns.RR {
+	soa := d.apexSOA(snap, zone)
+	soa.Header().Ttl = d.negTTL()
+	return soa
+}
+
+// addGlue adds the glue addresses as A and AAAA records for every NS name
+// inside a configured zone, so resolvers following the NS records can
+// reach the server. NS names outside the zones are left to their own
+// zone's servers.
+func (d *Docker) addGlue(a *answerTable, name func(string) *nameAnswers) {
+	if len(d.glue) == 0 {
+		return
+	}
+	zones := plugin.Zones(d.zones)
+	seen := make(map[string]bool)
+	for _, zone := range d.zones {
+		for _, fqdn := range d.nameservers(zone) {
+			if seen[fqdn] || zones.Matches(fqdn) == "" {
+				continue
+			}
+			seen[fqdn] = true
+			n := name(fqdn)
+			for _, ip := range d.glue {
+				hdr := dns.RR_Header{Name: fqdn, Class: dns.ClassINET, Ttl: d.ttl}
+				if ip4 := ip.To4(); ip4 != nil {
+					hdr.Rrtype = dns.TypeA
+					n.rrsets[dns.TypeA] = append(n.rrsets[dns.TypeA], &dns.A{Hdr: hdr, A: ip4})
+				} else {
+					hdr.Rrtype = dns.TypeAAAA
+					n.rrsets[dns.TypeAAAA] = append(n.rrsets[dns.TypeAAAA], &dns.AAAA{Hdr: hdr, AAAA: ip})
+				}
+			}
+		}
+	}
+}
+
+// additionalGlue returns copies of the A and AAAA records of the zone's NS
+// names that belong to zone, for the additional section of an NS answer.
+func (d *Docker) additionalGlue(snap *snapshot, zone string) []dns.RR {
+	zones := plugin.Zones(d.zones)
+	var rrs []dns.RR
+	for _, fqdn := range d.nameservers(zone) {
+		if zones.Matches(fqdn) != zone {
+			continue
+		}
+		n, ok := snap.answers.lookup(fqdn)
+		if !ok || n.cname != nil {
+			continue
+		}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			for _, rr := range n.rrsets[qtype] {
+				rrs = append(rrs, copyRR(rr))
+			}
+		}
+	}
+	return rrs
+}
+
+// listenAddresses returns the addresses the server listens on, for
+// ns_glue auto. Hosts are the server block's bind addresses; an empty or
+// unspecified host means every interface, whose addresses come from
+// interfaceAddrs. Loopback and link-local interface addresses are left
+// out, since other hosts cannot reach them.
+func listenAddresses(hosts []string, interfaceAddrs func() ([]net.Addr, error)) ([]net.IP, error) {
+	var ips []net.IP
+	all := false
+	for _, host := range hosts {
+		ip := net.ParseIP(host)
+		if host == "" || (ip != nil && ip.IsUnspecified()) {
+			all = true
+			continue
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("cannot use listen address %q as glue", host)
+		}
+		ips = append(ips, ip)
+	}
+	if len(hosts) == 0 || all {
+		addrs, err := interfaceAddrs()
+		if err != nil {
+			return nil, fmt.Errorf("listing interface addresses: %w", err)
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no usable listen address found for glue")
+	}
+	return ips, nil
+}
+
+// parseSeconds parses an SOA timer given as seconds or a duration.
+func parseSeconds(s string) (uint32, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if dur%time.Second != 0 || dur.Seconds() > float64(^uint32(0)) {
+		return 0, fmt.Errorf("%s is not a whole number of seconds", s)
+	}
+	return uint32(dur.Seconds()), nil
+}
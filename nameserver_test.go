@@ -0,0 +1,166 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestParseSOAOptions(t *testing.T) {
+	d := &Docker{ttl: DefaultTTL}
+	if err := parse(caddy.NewTestController("dns", `docker {
+		ns ns1 ns2.example.org.
+		soa_mbox admin.example.org.
+		soa_timers 1h 600 336h
+		negative_ttl 5
+	}`), d); err != nil {
+		t.Fatal(err)
+	}
+
+	soa := d.soa("docker.", 42)
+	if soa.Ns != "ns1.docker." || soa.Mbox != "admin.example.org." {
+		t.Errorf("expected the configured MNAME and RNAME, got %s and %s", soa.Ns, soa.Mbox)
+	}
+	if soa.Refresh != 3600 || soa.Retry != 600 || soa.Expire != 1209600 || soa.Minttl != 5 {
+		t.Errorf("expected the configured timers and negative TTL, got %v", soa)
+	}
+	var names []string
+	for _, rr := range d.ns("docker.") {
+		names = append(names, rr.(*dns.NS).Ns)
+	}
+	if !slices.Equal(names, []string{"ns1.docker.", "ns2.example.org."}) {
+		t.Errorf("expected an NS record per name, got %v", names)
+	}
+
+	// The defaults are unchanged without the options.
+	soa = (&Docker{ttl: DefaultTTL}).soa("docker.", 42)
+	if soa.Ns != "ns.dns.docker." || soa.Mbox != "hostmaster.docker." || soa.Refresh != 7200 || soa.Retry != 1800 || soa.Expire != 86400 || soa.Minttl != DefaultTTL {
+		t.Errorf("expected the default SOA fields, got %v", soa)
+	}
+}
+
+func TestParseSOAOptionsErrors(t *testing.T) {
+	tests := map[string]string{
+		"ns without names":       "docker {\nns\n}",
+		"invalid ns name":        "docker {\nns bad..name\n}",
+		"soa_mbox arguments":     "docker {\nsoa_mbox a. b.\n}",
+		"soa_timers count":       "docker {\nsoa_timers 1h 1h\n}",
+		"soa_timers invalid":     "docker {\nsoa_timers 1h soon 1h\n}",
+		"soa_timers zero":        "docker {\nsoa_timers 1h 0 1h\n}",
+		"soa_timers fraction":    "docker {\nsoa_timers 1h 1.5s 1h\n}",
+		"negative_ttl range":     "docker {\nnegative_ttl 7200\n}",
+		"ns_glue without values": "docker {\nns_glue\n}",
+		"ns_glue invalid":        "docker {\nns_glue 192.0.2.300\n}",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := parse(caddy.NewTestController("dns", input), &Docker{}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestGlue(t *testing.T) {
+	negTTL := uint32(10)
+	d := &Docker{
+		Next:        test.ErrorHandler(),
+		ttl:         DefaultTTL,
+		zones:       []string{"docker."},
+		nsNames:     []string{"ns.dns", "ns.example.org."},
+		negativeTTL: &negTTL,
+		glue:        []net.IP{net.ParseIP("192.0.2.53"), net.ParseIP("2001:db8::53")},
+	}
+	storeSnapshot(d, &snapshot{
+		connected: true,
+		recordSet: recordSet{records: map[string][]net.IP{"web.docker.": {net.ParseIP("172.17.0.2")}}},
+	})
+	serve := func(qname string, qtype uint16) *dns.Msg {
+		t.Helper()
+		r := new(dns.Msg)
+		r.SetQuestion(qname, qtype)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := d.ServeDNS(context.Background(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		return w.Msg
+	}
+
+	m := serve("ns.dns.docker.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "192.0.2.53" {
+		t.Errorf("expected the glue address for the NS name, got %v", m.Answer)
+	}
+	m = serve("ns.dns.docker.", dns.TypeAAAA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.AAAA).AAAA.String() != "2001:db8::53" {
+		t.Errorf("expected the IPv6 glue address for the NS name, got %v", m.Answer)
+	}
+
+	m = serve("docker.", dns.TypeNS)
+	if len(m.Answer) != 2 {
+		t.Errorf("expected both NS records, got %v", m.Answer)
+	}
+	var extra []string
+	for _, rr := range m.Extra {
+		extra = append(extra, rr.Header().Name+" "+dns.TypeToString[rr.Header().Rrtype])
+	}
+	if !slices.Equal(extra, []string{"ns.dns.docker. A", "ns.dns.docker. AAAA"}) {
+		t.Errorf("expected glue for the in-zone NS name only, got %v", extra)
+	}
+
+	m = serve("missing.docker.", dns.TypeA)
+	if len(m.Ns) != 1 || m.Ns[0].Header().Ttl != negTTL {
+		t.Errorf("expected the SOA in a negative answer to carry the negative TTL, got %v", m.Ns)
+	}
+	m = serve("docker.", dns.TypeSOA)
+	if len(m.Answer) != 1 || m.Answer[0].Header().Ttl != DefaultTTL || m.Answer[0].(*dns.SOA).Minttl != negTTL {
+		t.Errorf("expected the apex SOA to keep the normal TTL with the negative TTL as minimum, got %v", m.Answer)
+	}
+}
+
+func TestListenAddresses(t *testing.T) {
+	ifaces := func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(24, 32)},
+			&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+			&net.IPNet{IP: net.ParseIP("2001:db8::5"), Mask: net.CIDRMask(64, 128)},
+		}, nil
+	}
+	tests := map[string]struct {
+		hosts []string
+		want  []string
+	}{
+		"all interfaces": {nil, []string{"10.0.0.5", "2001:db8::5"}},
+		"wildcard":       {[]string{"::"}, []string{"10.0.0.5", "2001:db8::5"}},
+		"bound":          {[]string{"192.0.2.53"}, []string{"192.0.2.53"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ips, err := listenAddresses(tt.hosts, ifaces)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, ip := range ips {
+				got = append(got, ip.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := listenAddresses(nil, func() ([]net.Addr, error) { return nil, errors.New("no interfaces") }); err == nil {
+		t.Errorf("expected an error when interfaces cannot be listed")
+	}
+	if _, err := listenAddresses([]string{""}, func() ([]net.Addr, error) { return nil, nil }); err == nil {
+		t.Errorf("expected an error when no address is usable")
+	}
+}
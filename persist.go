@@ -0,0 +1,246 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// snapshotFileVersion is bumped whenever the on-disk layout changes in a
+// way older readers cannot load. Files with another version are ignored.
+const snapshotFileVersion = 1
+
+// persistedSnapshot is the JSON layout of snapshot_file: the merged
+// record tables and the time of the sync that produced them.
+type persistedSnapshot struct {
+	Version  int                       `json:"version"`
+	SyncTime time.Time                 `json:"sync_time"`
+	Records  map[string][]string       `json:"records,omitempty"`
+	SRVs     map[string][]persistedSRV `json:"srvs,omitempty"`
+	PTRs     map[string][]string       `json:"ptrs,omitempty"`
+	CNAMEs   map[string]string         `json:"cnames,omitempty"`
+	TXTs     map[string][][]string     `json:"txts,omitempty"`
+}
+
+type persistedSRV struct {
+	Target string `json:"target"`
+	Port   uint16 `json:"port"`
+}
+
+// newPersistedSnapshot converts a merged record set to its on-disk form.
+func newPersistedSnapshot(rs *recordSet, syncTime time.Time) *persistedSnapshot {
+	p := &persistedSnapshot{
+		Version:  snapshotFileVersion,
+		SyncTime: syncTime,
+		Records:  make(map[string][]string, len(rs.records)),
+		SRVs:     make(map[string][]persistedSRV, len(rs.srvs)),
+		PTRs:     rs.ptrs,
+		CNAMEs:   rs.cnames,
+		TXTs:     rs.txts,
+	}
+	for fqdn, ips := range rs.records {
+		strs := make([]string, len(ips))
+		for i, ip := range ips {
+			strs[i] = ip.String()
+		}
+		p.Records[fqdn] = strs
+	}
+	for fqdn, srvs := range rs.srvs {
+		out := make([]persistedSRV, len(srvs))
+		for i, srv := range srvs {
+			out[i] = persistedSRV{Target: srv.target, Port: srv.port}
+		}
+		p.SRVs[fqdn] = out
+	}
+	return p
+}
+
+// recordSet converts the on-disk form back to a record set.
+func (p *persistedSnapshot) recordSet() (*recordSet, error) {
+	rs := newRecordSet()
+	for fqdn, strs := range p.Records {
+		for _, s := range strs {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q for %s", s, fqdn)
+			}
+			rs.records[fqdn] = append(rs.records[fqdn], ip)
+		}
+	}
+	for fqdn, srvs := range p.SRVs {
+		for _, srv := range srvs {
+			rs.srvs[fqdn] = append(rs.srvs[fqdn], srvRecord{target: srv.Target, port: srv.Port})
+		}
+	}
+	for arpa, fqdns := range p.PTRs {
+		rs.ptrs[arpa] = fqdns
+	}
+	for fqdn, target := range p.CNAMEs {
+		rs.cnames[fqdn] = target
+	}
+	for fqdn, txts := range p.TXTs {
+		rs.txts[fqdn] = txts
+	}
+	return rs, nil
+}
+
+// writeSnapshotFile atomically replaces path with the given records. The
+// data goes to a temporary file in the same directory first, which is
+// synced and renamed over path, so a crash mid-write never leaves a
+// truncated file behind. The directory is synced after the rename so the
+// new file survives a crash too.
+func writeSnapshotFile(path string, rs *recordSet, syncTime time.Time) error {
+	data, err := json.Marshal(newPersistedSnapshot(rs, syncTime))
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// readSnapshotFile loads the records and sync time written by
+// writeSnapshotFile.
+func readSnapshotFile(path string) (*recordSet, time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var p persistedSnapshot
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, time.Time{}, err
+	}
+	if p.Version != snapshotFileVersion {
+		return nil, time.Time{}, fmt.Errorf("unsupported snapshot file version %d", p.Version)
+	}
+	rs, err := p.recordSet()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return rs, p.SyncTime, nil
+}
+
+// loadSnapshotFile publishes the records saved in snapshot_file, so the
+// plugin has something to answer with before its first live sync. The
+// plugin stays disconnected, so these records are served in stale mode
+// until a sync replaces them. The last sync time is left unset, so the
+// plugin only reports ready after a live sync. It reports whether records
+// were loaded; a missing or unreadable file is not an error, just a cold
+// start.
+func (d *Docker) loadSnapshotFile() bool {
+	if d.snapshotFile == "" {
+		return false
+	}
+	rs, syncTime, err := readSnapshotFile(d.snapshotFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Infof("No snapshot file at %s, starting without records", d.snapshotFile)
+		} else {
+			log.Warningf("Failed to load snapshot file %s: %v", d.snapshotFile, err)
+		}
+		return false
+	}
+	d.loaded = rs
+	d.updateSnapshot(func(s *snapshot) {
+		s.recordSet = *rs
+		s.answers = d.buildAnswers(rs)
+	})
+	log.Infof("Loaded %d records from snapshot file %s (synced %s)", len(rs.records), d.snapshotFile, syncTime.Format(time.RFC3339))
+	return true
+}
+
+// persistLocked queues the merged records for snapshot_file, if set. It
+// runs under syncMu, or under mu when merging named endpoints, so the
+// writer gets them in the same order as publishes.
+func (d *Docker) persistLocked(rs *recordSet, syncTime time.Time) {
+	if d.snapshotFile == "" {
+		return
+	}
+	d.persister.write(d.snapshotFile, rs, syncTime)
+}
+
+// snapshotWriter writes snapshot_file in the background, so a publish
+// never waits for the disk. Only the latest records are worth writing: a
+// publish made while a write is under way replaces the one waiting, and
+// a burst of events ends in a single write of the final records.
+type snapshotWriter struct {
+	mu       sync.Mutex
+	path     string
+	next     *recordSet // nil when nothing is waiting
+	syncTime time.Time
+	// idle is closed when the running writer goroutine exits; nil when
+	// none runs.
+	idle chan struct{}
+}
+
+// write queues rs to be written to path, starting the writer goroutine
+// if it is not running. rs must not be modified afterwards, which holds
+// for published record sets.
+func (w *snapshotWriter) write(path string, rs *recordSet, syncTime time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.path, w.next, w.syncTime = path, rs, syncTime
+	if w.idle == nil {
+		w.idle = make(chan struct{})
+		go w.run()
+	}
+}
+
+// run writes queued records until none are left.
+func (w *snapshotWriter) run() {
+	w.mu.Lock()
+	for w.next != nil {
+		path, rs, syncTime := w.path, w.next, w.syncTime
+		w.next = nil
+		w.mu.Unlock()
+		if err := writeSnapshotFile(path, rs, syncTime); err != nil {
+			log.Warningf("Failed to write snapshot file %s: %v", path, err)
+			snapshotFileErrorCount.Inc()
+		}
+		w.mu.Lock()
+	}
+	close(w.idle)
+	w.idle = nil
+	w.mu.Unlock()
+}
+
+// flush waits until the queued records have been written.
+func (w *snapshotWriter) flush() {
+	w.mu.Lock()
+	idle := w.idle
+	w.mu.Unlock()
+	if idle != nil {
+		<-idle
+	}
+}
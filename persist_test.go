@@ -0,0 +1,213 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestSnapshotFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	rs := &recordSet{
+		records: map[string][]net.IP{
+			"web.docker.": {net.ParseIP("172.17.0.2"), net.ParseIP("2001:db8::2")},
+		},
+		srvs: map[string][]srvRecord{
+			"_http._tcp.web.docker.": {{target: "web.docker.", port: 80}},
+		},
+		ptrs: map[string][]string{
+			"2.0.17.172.in-addr.arpa.": {"web.docker."},
+		},
+		cnames: map[string]string{
+			"alias.docker.": "external.example.com.",
+		},
+		txts: map[string][][]string{
+			"web.docker.": {{"v=spf1 -all"}},
+		},
+	}
+	syncTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if err := writeSnapshotFile(path, rs, syncTime); err != nil {
+		t.Fatal(err)
+	}
+	got, gotTime, err := readSnapshotFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotTime.Equal(syncTime) {
+		t.Errorf("expected sync time %s, got %s", syncTime, gotTime)
+	}
+	for name, pair := range map[string][2]any{
+		"records": {rs.records, got.records},
+		"srvs":    {rs.srvs, got.srvs},
+		"ptrs":    {rs.ptrs, got.ptrs},
+		"cnames":  {rs.cnames, got.cnames},
+		"txts":    {rs.txts, got.txts},
+	} {
+		if fmt.Sprint(pair[0]) != fmt.Sprint(pair[1]) {
+			t.Errorf("expected %s %v, got %v", name, pair[0], pair[1])
+		}
+	}
+}
+
+func TestWriteSnapshotFileReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "snapshot.json")
+	first := &recordSet{records: map[string][]net.IP{"old.docker.": {net.ParseIP("172.17.0.2")}}}
+	second := &recordSet{records: map[string][]net.IP{"new.docker.": {net.ParseIP("172.17.0.3")}}}
+
+	if err := writeSnapshotFile(path, first, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSnapshotFile(path, second, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	rs, _, err := readSnapshotFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rs.records["new.docker."]; !ok || len(rs.records) != 1 {
+		t.Errorf("expected only the second write's records, got %v", rs.records)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestReadSnapshotFileRejectsBadFiles(t *testing.T) {
+	tests := map[string]string{
+		"not json":      `{"version": 1,`,
+		"wrong version": `{"version": 99}`,
+		"invalid IP":    `{"version": 1, "records": {"web.docker.": ["not-an-ip"]}}`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "snapshot.json")
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := readSnapshotFile(path); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadSnapshotFileServesStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	syncTime := time.Now().Add(-time.Hour)
+	rs := &recordSet{records: map[string][]net.IP{"web.docker.": {net.ParseIP("172.17.0.2")}}}
+	if err := writeSnapshotFile(path, rs, syncTime); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newSyncTestDocker()
+	d.Next = test.ErrorHandler()
+	d.snapshotFile = path
+	if !d.loadSnapshotFile() {
+		t.Fatal("expected the snapshot file to load")
+	}
+
+	snap := d.loadSnapshot()
+	if snap.connected {
+		t.Errorf("expected loaded records to be served disconnected")
+	}
+	if !snap.lastSyncTime.IsZero() || d.Ready() {
+		t.Errorf("expected loaded records not to count as a sync, got last sync time %s", snap.lastSyncTime)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("web.docker.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := d.ServeDNS(context.Background(), rec, m); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Msg.Answer) != 1 {
+		t.Fatalf("expected one answer from the snapshot file, got %v", rec.Msg.Answer)
+	}
+	if ttl := rec.Msg.Answer[0].Header().Ttl; ttl != 5 {
+		t.Errorf("expected stale TTL 5, got %d", ttl)
+	}
+}
+
+func TestLoadSnapshotFileMissingOrCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"", filepath.Join(dir, "missing.json"), corrupt} {
+		d := newSyncTestDocker()
+		d.snapshotFile = path
+		if d.loadSnapshotFile() {
+			t.Errorf("%q: expected nothing to be loaded", path)
+		}
+		if len(d.loadSnapshot().records) != 0 {
+			t.Errorf("%q: expected no snapshot to be published", path)
+		}
+	}
+}
+
+func TestPublishWritesSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	d := newSyncTestDocker()
+	d.snapshotFile = path
+	d.containerSets = map[string]*recordSet{
+		"c1": {records: map[string][]net.IP{"web.docker.": {net.ParseIP("172.17.0.2")}}},
+	}
+	d.containerOrder = []string{"c1"}
+	d.publishLocked()
+	d.persister.flush()
+
+	rs, syncTime, err := readSnapshotFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rs.records["web.docker."]; !ok {
+		t.Errorf("expected the published records to be written, got %v", rs.records)
+	}
+	if !syncTime.Equal(d.loadSnapshot().lastSyncTime) {
+		t.Errorf("expected the file's sync time to match the snapshot")
+	}
+
+	// A write failure is logged and leaves the in-memory snapshot alone.
+	d.snapshotFile = filepath.Join(t.TempDir(), "missing-dir", "snapshot.json")
+	d.deleteContainerLocked("c1")
+	d.publishLocked()
+	d.persister.flush()
+	if len(d.loadSnapshot().records) != 0 {
+		t.Errorf("expected the publish to succeed despite the write failure")
+	}
+}
+
+func TestSnapshotWriterKeepsLatest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	var w snapshotWriter
+	for i := range 20 {
+		rs := newRecordSet()
+		rs.records["web.docker."] = []net.IP{net.IPv4(172, 17, 0, byte(i))}
+		w.write(path, rs, time.Now())
+	}
+	w.flush()
+
+	rs, _, err := readSnapshotFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ips := rs.records["web.docker."]; len(ips) != 1 || !ips[0].Equal(net.ParseIP("172.17.0.19")) {
+		t.Errorf("expected the last records written, got %v", ips)
+	}
+}
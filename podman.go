@@ -0,0 +1,235 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+)
+
+// podmanDefaultNetwork is the network Podman attaches containers to when
+// HostConfig.NetworkMode says "bridge".
+const podmanDefaultNetwork = "podman"
+
+// libpodPodsPath lists pods through Podman's native API. The Docker
+// compatible API has no notion of pods.
+const libpodPodsPath = "/v4.0.0/libpod/pods/json"
+
+// isPodman reports whether a /version response came from Podman's
+// Docker-compatible API rather than Docker Engine.
+func isPodman(v types.Version) bool {
+	if strings.Contains(strings.ToLower(v.Platform.Name), "podman") {
+		return true
+	}
+	for _, c := range v.Components {
+		if strings.Contains(strings.ToLower(c.Name), "podman") {
+			return true
+		}
+	}
+	return false
+}
+
+// podMember describes a container's place in a Podman pod.
+type podMember struct {
+	pod   string // pod name, published as a name shared by all members
+	infra bool   // the pod's infra container, which holds its network namespace
+}
+
+// podIndex maps container IDs to their pod.
+type podIndex map[string]podMember
+
+// podLister lists Podman pods. It is implemented by libpodClient and
+// faked in tests.
+type podLister interface {
+	listPods(ctx context.Context) (podIndex, error)
+}
+
+// libpodClient talks to Podman's native API over the Docker client's
+// transport, so it reaches the same socket or TCP endpoint with the same
+// TLS settings.
+type libpodClient struct {
+	http *http.Client
+	base string
+}
+
+// newLibpodClient returns a libpodClient for the daemon cli talks to.
+func newLibpodClient(cli *client.Client) (*libpodClient, error) {
+	host, err := client.ParseHostURL(cli.DaemonHost())
+	if err != nil {
+		return nil, err
+	}
+	httpClient := cli.HTTPClient()
+	// Unix sockets and named pipes ignore the host part of the URL.
+	base := "http://podman"
+	if host.Scheme == "tcp" {
+		scheme := "http"
+		if t, ok := httpClient.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + host.Host + host.Path
+	}
+	return &libpodClient{http: httpClient, base: base}, nil
+}
+
+// listPods implements podLister.
+func (l *libpodClient) listPods(ctx context.Context) (podIndex, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.base+libpodPodsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing pods: %s", resp.Status)
+	}
+	var pods []struct {
+		Name       string
+		InfraID    string `json:"InfraId"`
+		Containers []struct {
+			ID string `json:"Id"`
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, fmt.Errorf("decoding pods: %w", err)
+	}
+	index := make(podIndex)
+	for _, p := range pods {
+		for _, c := range p.Containers {
+			index[c.ID] = podMember{pod: p.Name, infra: c.ID == p.InfraID}
+		}
+	}
+	return index, nil
+}
+
+// detectBackend asks the daemon for its version and records whether it is
+// Podman. It runs on every (re)connect, since the socket may be served by
+// a different engine after a restart. Errors keep the previous answer;
+// the sync that follows reports an unreachable daemon.
+func (d *Docker) detectBackend(ctx context.Context) {
+	if d.client == nil || d.source != nil {
+		return
+	}
+	ctx, cancel := d.apiContext(ctx)
+	defer cancel()
+	v, err := d.client.ServerVersion(ctx)
+	if err != nil {
+		log.Debugf("Failed to query the daemon version: %v", err)
+		return
+	}
+	podman := isPodman(v)
+	if podman != d.podman.Load() {
+		if podman {
+			log.Infof("Detected Podman %s, enabling Podman support", v.Version)
+		} else {
+			log.Infof("Detected Docker Engine %s", v.Version)
+		}
+	}
+	d.podman.Store(podman)
+}
+
+// refreshPods reloads the pod index from Podman. On error the previous
+// index is kept, so a transient failure does not drop pod names.
+func (d *Docker) refreshPods(ctx context.Context) {
+	if !d.podman.Load() || d.libpod == nil {
+		return
+	}
+	ctx, cancel := d.apiContext(ctx)
+	defer cancel()
+	index, err := d.libpod.listPods(ctx)
+	if err != nil {
+		log.Warningf("Failed to list Podman pods: %v", err)
+		return
+	}
+	d.pods.Store(&index)
+}
+
+// loadPods returns the current pod index, or nil outside Podman mode.
+func (d *Docker) loadPods() podIndex {
+	if !d.podman.Load() {
+		return nil
+	}
+	if p := d.pods.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// podmanEventActions maps the Podman event names that differ from
+// Docker's onto the Docker actions the sync loop handles.
+var podmanEventActions = map[events.Action]events.Action{
+	"died":    events.ActionDie,
+	"cleanup": events.ActionDie,
+	"remove":  events.ActionDestroy,
+}
+
+// normalizePodmanEvent rewrites a Podman event into its Docker
+// equivalent.
+func normalizePodmanEvent(msg events.Message) events.Message {
+	if action, ok := podmanEventActions[msg.Action]; ok {
+		msg.Action = action
+	}
+	return msg
+}
+
+// networkPeerID returns the container whose network namespace a
+// container shares through --network container:ID, which is how Podman
+// pod members join their pod's infra container.
+func networkPeerID(inspect container.InspectResponse) (string, bool) {
+	if inspect.ContainerJSONBase == nil || inspect.HostConfig == nil {
+		return "", false
+	}
+	return strings.CutPrefix(string(inspect.HostConfig.NetworkMode), "container:")
+}
+
+// podmanNetworking adapts a Podman inspect response to the Docker
+// conventions containerRecords relies on. Pod members report
+// NetworkMode container:INFRA and no networks of their own, so they take
+// their networks from the infra container. Podman's default network is
+// called podman, not bridge, and rootless containers on a user-defined
+// network report that network under a NetworkMode of bridge; when the
+// NetworkMode names no attached network and the container is on exactly
+// one, that one is primary.
+func podmanNetworking(id string, inspect container.InspectResponse, peers map[string]container.InspectResponse) (container.InspectResponse, string) {
+	if peerID, ok := networkPeerID(inspect); ok {
+		peer, found := peers[peerID]
+		if !found || peer.ContainerJSONBase == nil || peer.HostConfig == nil {
+			log.Debugf("Container %s shares the network of %s, which is not available", id, peerID)
+			return inspect, ""
+		}
+		hostConfig := *inspect.HostConfig
+		hostConfig.NetworkMode = peer.HostConfig.NetworkMode
+		base := *inspect.ContainerJSONBase
+		base.HostConfig = &hostConfig
+		inspect.ContainerJSONBase = &base
+		inspect.NetworkSettings = peer.NetworkSettings
+	}
+	if inspect.NetworkSettings == nil {
+		return inspect, ""
+	}
+
+	networks := inspect.NetworkSettings.Networks
+	mode := string(inspect.HostConfig.NetworkMode)
+	if _, ok := networks[mode]; ok {
+		return inspect, mode
+	}
+	if mode == "" || mode == "default" || mode == "bridge" {
+		if _, ok := networks[podmanDefaultNetwork]; ok {
+			return inspect, podmanDefaultNetwork
+		}
+	}
+	if len(networks) == 1 {
+		for name := range networks {
+			return inspect, name
+		}
+	}
+	return inspect, mode
+}
@@ -0,0 +1,236 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+type mockPodLister struct {
+	pods podIndex
+}
+
+func (m *mockPodLister) listPods(ctx context.Context) (podIndex, error) {
+	return m.pods, nil
+}
+
+// podmanContainer returns a running container as Podman's compatible API
+// reports it: NetworkMode bridge, attached to the podman network.
+func podmanContainer(name, ip string) container.InspectResponse {
+	inspect := runningContainer(name, "", nil)
+	inspect.NetworkSettings.Networks = map[string]*network.EndpointSettings{
+		podmanDefaultNetwork: {IPAddress: ip},
+	}
+	return inspect
+}
+
+// podMemberContainer returns a running pod member sharing infra's
+// network namespace.
+func podMemberContainer(name, infra string) container.InspectResponse {
+	inspect := runningContainer(name, "", nil)
+	inspect.HostConfig.NetworkMode = container.NetworkMode("container:" + infra)
+	inspect.NetworkSettings.Networks = map[string]*network.EndpointSettings{}
+	return inspect
+}
+
+func newPodmanTestDocker(pods podIndex) *Docker {
+	d := newSyncTestDocker()
+	d.podman.Store(true)
+	d.libpod = &mockPodLister{pods: pods}
+	d.refreshPods(context.Background())
+	return d
+}
+
+func TestIsPodman(t *testing.T) {
+	var docker types.Version
+	docker.Platform.Name = "Docker Engine - Community"
+	docker.Components = []types.ComponentVersion{{Name: "Engine"}, {Name: "containerd"}}
+	if isPodman(docker) {
+		t.Errorf("expected Docker Engine not to be detected as Podman")
+	}
+
+	var podman types.Version
+	podman.Components = []types.ComponentVersion{{Name: "Podman Engine"}}
+	if !isPodman(podman) {
+		t.Errorf("expected the Podman Engine component to be detected")
+	}
+}
+
+func TestNormalizePodmanEvent(t *testing.T) {
+	tests := map[events.Action]events.Action{
+		"died":              events.ActionDie,
+		"cleanup":           events.ActionDie,
+		"remove":            events.ActionDestroy,
+		events.ActionStart:  events.ActionStart,
+		events.ActionRename: events.ActionRename,
+	}
+	for in, want := range tests {
+		if got := normalizePodmanEvent(containerEvent(in, "c1")).Action; got != want {
+			t.Errorf("%s: expected %s, got %s", in, want, got)
+		}
+	}
+
+	if eventFilter(false).ExactMatch("event", "died") {
+		t.Errorf("expected Podman event names only in the Podman filter")
+	}
+	for _, action := range []string{"died", "cleanup", "remove", "start"} {
+		if !eventFilter(true).ExactMatch("event", action) {
+			t.Errorf("expected the Podman filter to include event %s", action)
+		}
+	}
+}
+
+func TestNewLibpodClient(t *testing.T) {
+	tests := map[string]string{
+		"unix:///run/podman/podman.sock": "http://podman",
+		"tcp://10.0.0.5:8080":            "http://10.0.0.5:8080",
+	}
+	for host, want := range tests {
+		cli, err := client.NewClientWithOpts(client.WithHost(host))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := newLibpodClient(cli)
+		cli.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.base != want {
+			t.Errorf("%s: expected base %s, got %s", host, want, l.base)
+		}
+	}
+}
+
+func TestLibpodListPods(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != libpodPodsPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[{"Id":"p1","Name":"web","InfraId":"infra1","Containers":[
+			{"Id":"infra1","Names":"p1-infra","Status":"running"},
+			{"Id":"app1","Names":"web-app","Status":"running"}]}]`))
+	}))
+	defer srv.Close()
+
+	l := &libpodClient{http: srv.Client(), base: srv.URL}
+	pods, err := l.listPods(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pods["infra1"]; got.pod != "web" || !got.infra {
+		t.Errorf("expected infra1 to be the infra container of pod web, got %+v", got)
+	}
+	if got := pods["app1"]; got.pod != "web" || got.infra {
+		t.Errorf("expected app1 to be a member of pod web, got %+v", got)
+	}
+
+	l.base = srv.URL + "/missing"
+	if _, err := l.listPods(context.Background()); err == nil {
+		t.Errorf("expected an error for a non-200 response")
+	}
+}
+
+func TestPodmanNetworking(t *testing.T) {
+	onNetwork := func(mode string, networks ...string) container.InspectResponse {
+		inspect := runningContainer("c", "", nil)
+		inspect.HostConfig.NetworkMode = container.NetworkMode(mode)
+		inspect.NetworkSettings.Networks = map[string]*network.EndpointSettings{}
+		for _, n := range networks {
+			inspect.NetworkSettings.Networks[n] = &network.EndpointSettings{}
+		}
+		return inspect
+	}
+	tests := []struct {
+		name    string
+		inspect container.InspectResponse
+		want    string
+	}{
+		{"named network", onNetwork("app", "app", "other"), "app"},
+		{"default podman network", onNetwork("bridge", "podman", "other"), "podman"},
+		{"single network under bridge", onNetwork("bridge", "app"), "app"},
+		{"ambiguous", onNetwork("bridge", "app", "other"), "bridge"},
+	}
+	for _, tt := range tests {
+		if _, got := podmanNetworking("c", tt.inspect, nil); got != tt.want {
+			t.Errorf("%s: expected primary network %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	member := podMemberContainer("app", "infra")
+	peers := map[string]container.InspectResponse{"infra": podmanContainer("infra", "10.88.0.5")}
+	inspect, primary := podmanNetworking("app", member, peers)
+	if primary != podmanDefaultNetwork || inspect.NetworkSettings.Networks[primary].IPAddress != "10.88.0.5" {
+		t.Errorf("expected the member to borrow the infra container's network, got %q", primary)
+	}
+	if string(member.HostConfig.NetworkMode) != "container:infra" {
+		t.Errorf("expected the member's own inspect response to be left unchanged")
+	}
+}
+
+func TestPodmanPodRecords(t *testing.T) {
+	d := newPodmanTestDocker(podIndex{
+		"infra": {pod: "shop", infra: true},
+		"web":   {pod: "shop"},
+		"api":   {pod: "shop"},
+	})
+	syncEndpoint(t, d, map[string]container.InspectResponse{
+		"infra": podmanContainer("shop-infra", "10.88.0.5"),
+		"web":   podMemberContainer("web", "infra"),
+		"api":   podMemberContainer("api", "infra"),
+		"db":    podmanContainer("db", "10.88.0.6"),
+	})
+
+	assertRecordIPs(t, d, "web.docker.", "10.88.0.5")
+	assertRecordIPs(t, d, "api.docker.", "10.88.0.5")
+	assertRecordIPs(t, d, "shop.docker.", "10.88.0.5")
+	assertRecordIPs(t, d, "db.docker.", "10.88.0.6")
+	assertRecordIPs(t, d, "shop-infra.docker.")
+}
+
+func TestPodmanHandleEventInspectsInfra(t *testing.T) {
+	ctx := context.Background()
+	d := newPodmanTestDocker(podIndex{
+		"infra": {pod: "shop", infra: true},
+		"web":   {pod: "shop"},
+	})
+	inspector := &mockContainerInspector{inspections: map[string]container.InspectResponse{
+		"infra": podmanContainer("shop-infra", "10.88.0.5"),
+		"web":   podMemberContainer("web", "infra"),
+	}}
+
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "web"))
+	assertRecordIPs(t, d, "web.docker.", "10.88.0.5")
+	assertRecordIPs(t, d, "shop.docker.", "10.88.0.5")
+
+	d.handleEvent(ctx, inspector, normalizePodmanEvent(containerEvent("died", "web")))
+	assertRecordIPs(t, d, "web.docker.")
+	assertRecordIPs(t, d, "shop.docker.")
+}
+
+func TestComposeProjectService(t *testing.T) {
+	project, service := composeProjectService(map[string]string{
+		"io.podman.compose.project": "shop",
+		"io.podman.compose.service": "web",
+	})
+	if project != "shop" || service != "web" {
+		t.Errorf("expected the podman-compose labels to be used, got %s.%s", project, service)
+	}
+
+	project, service = composeProjectService(map[string]string{
+		"com.docker.compose.project": "app",
+		"com.docker.compose.service": "api",
+		"io.podman.compose.project":  "shop",
+		"io.podman.compose.service":  "web",
+	})
+	if project != "app" || service != "api" {
+		t.Errorf("expected the Docker Compose labels to take precedence, got %s.%s", project, service)
+	}
+}
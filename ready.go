@@ -4,19 +4,18 @@ import "time"
 
 // Ready signals when the plugin is ready for use.
 func (d *Docker) Ready() bool {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-	if d.client == nil {
+	if d.client == nil && d.source == nil && len(d.endpoints) == 0 {
 		log.Debugf("Ready check: not ready (no Docker client)")
 		return false
 	}
-	if d.connected {
+	snap := d.loadSnapshot()
+	if snap.connected {
 		log.Debugf("Ready check: ready (connected to Docker daemon)")
 		return true
 	}
-	ready := !d.lastSyncTime.IsZero()
+	ready := !snap.lastSyncTime.IsZero()
 	if ready {
-		log.Debugf("Ready check: ready (serving stale records, last sync: %s)", d.lastSyncTime.Format(time.RFC3339))
+		log.Debugf("Ready check: ready (serving stale records, last sync: %s)", snap.lastSyncTime.Format(time.RFC3339))
 	} else {
 		log.Debugf("Ready check: not ready (disconnected, no previous sync)")
 	}
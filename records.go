@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"net"
+	"slices"
+)
+
+// recordSet holds the record tables derived from one or more containers.
+// The per-container sets produced during a sync are merged into a single
+// set that ServeDNS reads from.
+type recordSet struct {
+	records map[string][]net.IP
+	srvs    map[string][]srvRecord
+	ptrs    map[string][]string   // reverse-arpa FQDN -> container FQDNs
+	cnames  map[string]string     // FQDN -> canonical target (trailing dot)
+	txts    map[string][][]string // FQDN -> list of TXT RRs; each inner slice is one RR's character-strings
+}
+
+// newRecordSet returns an empty recordSet with all tables allocated.
+func newRecordSet() *recordSet {
+	return &recordSet{
+		records: make(map[string][]net.IP),
+		srvs:    make(map[string][]srvRecord),
+		ptrs:    make(map[string][]string),
+		cnames:  make(map[string]string),
+		txts:    make(map[string][][]string),
+	}
+}
+
+// merge folds other into rs using the same dedup rules generateRecords
+// applies while building a single container's records: A/AAAA entries
+// dedup by IP, SRV entries by target and port, PTR entries by FQDN, CNAMEs
+// are last-write-wins, and TXT RRs from different containers accumulate.
+// A nil other is a no-op.
+func (rs *recordSet) merge(other *recordSet) {
+	if other == nil {
+		return
+	}
+	for fqdn, ips := range other.records {
+		for _, ip := range ips {
+			if !slices.ContainsFunc(rs.records[fqdn], ip.Equal) {
+				rs.records[fqdn] = append(rs.records[fqdn], ip)
+			}
+		}
+	}
+	for name, srvs := range other.srvs {
+		for _, srv := range srvs {
+			if !slices.Contains(rs.srvs[name], srv) {
+				rs.srvs[name] = append(rs.srvs[name], srv)
+			}
+		}
+	}
+	for arpa, fqdns := range other.ptrs {
+		for _, fqdn := range fqdns {
+			if !slices.Contains(rs.ptrs[arpa], fqdn) {
+				rs.ptrs[arpa] = append(rs.ptrs[arpa], fqdn)
+			}
+		}
+	}
+	for fqdn, target := range other.cnames {
+		rs.cnames[fqdn] = target
+	}
+	for fqdn, rrs := range other.txts {
+		rs.txts[fqdn] = append(rs.txts[fqdn], rrs...)
+	}
+}
+
+// mergeRecordSets merges the per-container sets in order into a fresh
+// recordSet. Order matters for CNAME conflicts (last write wins) and for
+// the order of IPs within an RRset.
+func mergeRecordSets(order []string, sets map[string]*recordSet) *recordSet {
+	merged := newRecordSet()
+	for _, id := range order {
+		merged.merge(sets[id])
+	}
+	return merged
+}
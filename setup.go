@@ -2,7 +2,10 @@ package docker
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -10,7 +13,8 @@ import (
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
-	"github.com/docker/docker/client"
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/miekg/dns"
 )
 
 // init registers this plugin.
@@ -19,44 +23,135 @@ func init() { plugin.Register(pluginName, setup) }
 // setup is the function that gets called when the config parser see the token "docker".
 func setup(c *caddy.Controller) error {
 	d := &Docker{
-		labelPrefix: "com.dokku.coredns-docker",
-		maxBackoff:  60 * time.Second,
-		records:     make(map[string][]net.IP),
-		srvs:        make(map[string][]srvRecord),
-		ptrs:        make(map[string][]string),
-		cnames:      make(map[string]string),
-		txts:        make(map[string][][]string),
-		ttl:         DefaultTTL,
-		zones:       []string{"docker."},
+		labelPrefix:        "com.dokku.coredns-docker",
+		maxBackoff:         60 * time.Second,
+		ttl:                DefaultTTL,
+		zones:              []string{"docker."},
+		inspectConcurrency: defaultInspectConcurrency,
+		inspectCache:       newInspectCache(),
+		inspectGrace:       defaultInspectGrace,
 	}
 	if err := parse(c, d); err != nil {
 		return plugin.Error(pluginName, err)
 	}
-	log.Debugf("Configuration: zones=[%s], ttl=%d, label_prefix=%q, networks=[%s], max_backoff=%s, host_mode=%t, host_mode_ptr=%t, name_templates=%d",
-		strings.Join(d.zones, ", "), d.ttl, d.labelPrefix, strings.Join(d.networks, ", "), d.maxBackoff, d.hostMode, d.hostModePTR, len(d.nameTemplates))
+	log.Debugf("Configuration: zones=[%s], ttl=%d, label_prefix=%q, networks=[%s], max_backoff=%s, host_mode=%t, host_mode_ptr=%t, link_local=%t, lazy_connect=%t, snapshot_file=%q, source_dir=%q, runtime=%q, endpoint=%q, tls=%t, api_version=%q, name_templates=%d, sync_debounce=%s, sync_max_delay=%s, resync_interval=%s, api_timeout=%s, heartbeat=%s/%s, inspect_concurrency=%d, inspect_grace=%s, swarm=%s, dnssec=%t, ns=[%s], glue=%v, update_keys=%d, update_lease=%s",
+		strings.Join(d.zones, ", "), d.ttl, d.labelPrefix, strings.Join(d.networks, ", "), d.maxBackoff, d.hostMode, d.hostModePTR, d.linkLocal, d.lazyConnect, d.snapshotFile, d.sourceDir, d.runtimeName(), d.endpoint, d.tlsCA != "" || d.tlsCert != "", d.apiVersion, len(d.nameTemplates), d.syncDebounce, d.syncMaxDelay, d.resyncInterval, d.apiTimeout, d.heartbeatInterval, d.heartbeatTimeout, d.inspectConcurrency, d.inspectGrace, d.swarmInterval, d.dnssec, strings.Join(d.nsNames, ", "), d.glue, len(d.updateKeys), d.updateLease)
+	// Glue for NS names inside the zones points at the addresses this
+	// server block listens on.
+	if d.glueAuto {
+		ips, err := listenAddresses(dnsserver.GetConfig(c).ListenHosts, net.InterfaceAddrs)
+		if err != nil {
+			return plugin.Error(pluginName, err)
+		}
+		d.glue = ips
+		log.Infof("Serving %s as glue for the NS names", ips)
+	}
+	if d.dnssec {
+		s, err := newSigner(d.zones, d.dnssecKeys)
+		if err != nil {
+			return plugin.Error(pluginName, err)
+		}
+		d.signer = s
+	}
+	// Dynamic updates are verified by the server, which needs the keys'
+	// secrets. Keys from the tsig plugin, set up earlier, are kept.
+	if len(d.updateKeys) > 0 {
+		cfg := dnsserver.GetConfig(c)
+		if cfg.TsigSecret == nil {
+			cfg.TsigSecret = make(map[string]string)
+		}
+		for name, key := range d.updateKeys {
+			cfg.TsigSecret[name] = key.secret
+		}
+	}
+	for _, e := range d.endpoints {
+		log.Debugf("Endpoint %s: runtime=%q, url=%q, zones=[%s], tls=%t, api_version=%q", e.name, e.runtimeName(), e.endpoint, strings.Join(e.zones, ", "), e.tlsCA != "" || e.tlsCert != "", e.apiVersion)
+	}
 
-	// Create a new Docker client.
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return plugin.Error(pluginName, err)
+	// Get a Docker client for each endpoint, shared with any other server
+	// block or plugin instance that uses the same endpoint. A file source
+	// reads its directory instead and needs none.
+	daemons := d.daemons()
+	for _, dm := range daemons {
+		if dm.sourceDir != "" {
+			log.Infof("Reading containers from docker inspect dumps in %s", dm.sourceDir)
+			dm.source = newFileSource(dm, dm.sourceDir)
+			continue
+		}
+		w, err := acquireWatcher(dm)
+		if err != nil {
+			releaseWatchers(daemons)
+			return plugin.Error(pluginName, err)
+		}
+		dm.watcher = w
+		dm.client = w.client
+		dm.libpod = w.libpod
 	}
-	d.client = dockerClient
 
-	// Do a ping check to check if the Docker daemon is reachable.
-	_, err = d.client.Ping(context.Background())
-	if err != nil {
-		return plugin.Error(pluginName, err)
+	// Serve the records saved by the previous run until the first live
+	// sync replaces them.
+	loaded := d.loadSnapshotFile()
+
+	// Do a ping check to check if the Docker daemon is reachable. With
+	// lazy_connect the event loop connects in the background instead, and
+	// the plugin stays not-ready until its first sync succeeds.
+	if d.lazyConnect {
+		log.Infof("lazy_connect enabled, connecting to the Docker daemon in the background")
+	} else {
+		for _, dm := range daemons {
+			if dm.client == nil {
+				continue
+			}
+			pingCtx, pingCancel := dm.apiContext(context.Background())
+			_, err := dm.client.Ping(pingCtx)
+			pingCancel()
+			if err != nil {
+				releaseWatchers(daemons)
+				if dm.name != "" {
+					return plugin.Error(pluginName, fmt.Errorf("endpoint %s: %w", dm.name, err))
+				}
+				return plugin.Error(pluginName, err)
+			}
+			// Records loaded from snapshot_file stay in stale mode until
+			// the event loop's first sync marks the plugin connected.
+			if !loaded {
+				dm.setConnected(true)
+			}
+		}
 	}
-	d.connected = true
 
 	ctx, cancel := context.WithCancel(context.Background())
+	accepting := false
 	c.OnStartup(func() error {
-		go d.startEventLoop(ctx)
+		// With a transfer plugin in the server block, secondaries are
+		// notified whenever a sync changes a zone.
+		if t, ok := dnsserver.GetConfig(c).Handler("transfer").(*transfer.Transfer); ok {
+			d.xfr = t
+		}
+		if len(d.updateKeys) > 0 && !accepting {
+			acceptUpdates()
+			accepting = true
+		}
+		for _, dm := range daemons {
+			go dm.startEventLoop(ctx)
+			if dm.resyncInterval > 0 {
+				go dm.startResyncLoop(ctx)
+			}
+			if dm.swarmInterval > 0 {
+				go dm.startSwarmLoop(ctx)
+			}
+		}
 		return nil
 	})
 	c.OnShutdown(func() error {
 		cancel()
-		d.client.Close()
+		d.overlay.stop()
+		if accepting {
+			releaseUpdates()
+			accepting = false
+		}
+		releaseWatchers(daemons)
+		d.persister.flush()
 		return nil
 	})
 
@@ -88,6 +183,118 @@ func parse(c *caddy.Controller, d *Docker) error {
 					return c.Errf("error parsing max_backoff: %v", err)
 				}
 				d.maxBackoff = dur
+			case "sync_debounce":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return c.ArgErr()
+				}
+				window, err := time.ParseDuration(args[0])
+				if err != nil {
+					return c.Errf("error parsing sync_debounce: %v", err)
+				}
+				if window <= 0 {
+					return c.Errf("sync_debounce must be positive: %s", args[0])
+				}
+				maxDelay := window * defaultSyncMaxDelayFactor
+				if len(args) == 2 {
+					maxDelay, err = time.ParseDuration(args[1])
+					if err != nil {
+						return c.Errf("error parsing sync_debounce max delay: %v", err)
+					}
+					if maxDelay < window {
+						return c.Errf("sync_debounce max delay %s must not be shorter than the window %s", args[1], args[0])
+					}
+				}
+				d.syncDebounce = window
+				d.syncMaxDelay = maxDelay
+			case "resync_interval":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				dur, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return c.Errf("error parsing resync_interval: %v", err)
+				}
+				if dur <= 0 {
+					return c.Errf("resync_interval must be positive: %s", c.Val())
+				}
+				d.resyncInterval = dur
+			case "api_timeout":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				dur, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return c.Errf("error parsing api_timeout: %v", err)
+				}
+				if dur <= 0 {
+					return c.Errf("api_timeout must be positive: %s", c.Val())
+				}
+				d.apiTimeout = dur
+			case "inspect_concurrency":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				n, err := strconv.Atoi(c.Val())
+				if err != nil {
+					return c.Err("error parsing inspect_concurrency: " + err.Error())
+				}
+				if n < 1 {
+					return c.Errf("inspect_concurrency must be at least 1: %d", n)
+				}
+				d.inspectConcurrency = n
+			case "inspect_grace":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				dur, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return c.Errf("error parsing inspect_grace: %v", err)
+				}
+				if dur < 0 {
+					return c.Errf("inspect_grace must not be negative: %s", c.Val())
+				}
+				d.inspectGrace = dur
+			case "swarm":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return c.ArgErr()
+				}
+				d.swarmInterval = defaultSwarmInterval
+				if len(args) == 1 {
+					dur, err := time.ParseDuration(args[0])
+					if err != nil {
+						return c.Errf("error parsing swarm interval: %v", err)
+					}
+					if dur <= 0 {
+						return c.Errf("swarm interval must be positive: %s", args[0])
+					}
+					d.swarmInterval = dur
+				}
+			case "heartbeat":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return c.ArgErr()
+				}
+				interval, err := time.ParseDuration(args[0])
+				if err != nil {
+					return c.Errf("error parsing heartbeat interval: %v", err)
+				}
+				if interval <= 0 {
+					return c.Errf("heartbeat interval must be positive: %s", args[0])
+				}
+				timeout := interval
+				if len(args) == 2 {
+					timeout, err = time.ParseDuration(args[1])
+					if err != nil {
+						return c.Errf("error parsing heartbeat timeout: %v", err)
+					}
+					if timeout <= 0 {
+						return c.Errf("heartbeat timeout must be positive: %s", args[1])
+					}
+				}
+				d.heartbeatInterval = interval
+				d.heartbeatTimeout = timeout
 			case "networks":
 				d.networks = c.RemainingArgs()
 				if len(d.networks) == 0 {
@@ -105,6 +312,75 @@ func parse(c *caddy.Controller, d *Docker) error {
 					return c.Errf("ttl must be in range [0, 3600]: %d", t)
 				}
 				d.ttl = uint32(t)
+			case "negative_ttl":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				t, err := strconv.Atoi(c.Val())
+				if err != nil {
+					return c.Err("error parsing negative_ttl: " + err.Error())
+				}
+				if t < 0 || t > 3600 {
+					return c.Errf("negative_ttl must be in range [0, 3600]: %d", t)
+				}
+				negTTL := uint32(t)
+				d.negativeTTL = &negTTL
+			case "ns":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return c.ArgErr()
+				}
+				for _, arg := range args {
+					if _, ok := dns.IsDomainName(arg); !ok {
+						return c.Errf("invalid ns name %q", arg)
+					}
+				}
+				d.nsNames = args
+			case "soa_mbox":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				if _, ok := dns.IsDomainName(c.Val()); !ok {
+					return c.Errf("invalid soa_mbox %q", c.Val())
+				}
+				d.soaMbox = c.Val()
+				if c.NextArg() {
+					return c.ArgErr()
+				}
+			case "soa_timers":
+				args := c.RemainingArgs()
+				if len(args) != 3 {
+					return c.ArgErr()
+				}
+				var timers [3]uint32
+				for i, arg := range args {
+					t, err := parseSeconds(arg)
+					if err != nil {
+						return c.Errf("error parsing soa_timers: %v", err)
+					}
+					if t == 0 {
+						return c.Errf("soa_timers must be positive: %s", arg)
+					}
+					timers[i] = t
+				}
+				d.soaRefresh, d.soaRetry, d.soaExpire = timers[0], timers[1], timers[2]
+			case "ns_glue":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return c.ArgErr()
+				}
+				if len(args) == 1 && args[0] == "auto" {
+					d.glueAuto = true
+					break
+				}
+				d.glue = nil
+				for _, arg := range args {
+					ip := net.ParseIP(arg)
+					if ip == nil {
+						return c.Errf("invalid ns_glue address %q", arg)
+					}
+					d.glue = append(d.glue, ip)
+				}
 			case "fallthrough":
 				d.Fall.SetZonesFromArgs(c.RemainingArgs())
 			case "host_mode":
@@ -117,6 +393,57 @@ func parse(c *caddy.Controller, d *Docker) error {
 						return c.Errf("unknown host_mode option %q", arg)
 					}
 				}
+			case "link_local":
+				if c.NextArg() {
+					return c.ArgErr()
+				}
+				d.linkLocal = true
+			case "lazy_connect":
+				if c.NextArg() {
+					return c.ArgErr()
+				}
+				d.lazyConnect = true
+			case "snapshot_file":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				d.snapshotFile = c.Val()
+				if c.NextArg() {
+					return c.ArgErr()
+				}
+			case "endpoint":
+				args := c.RemainingArgs()
+				switch len(args) {
+				case 1:
+					if err := parseEndpointURL(c, d, args[0]); err != nil {
+						return err
+					}
+				case 2:
+					e, err := parseNamedEndpoint(c, args[0], args[1])
+					if err != nil {
+						return err
+					}
+					for _, existing := range d.endpoints {
+						if existing.name == e.name {
+							return c.Errf("duplicate endpoint %q", e.name)
+						}
+					}
+					d.endpoints = append(d.endpoints, e)
+				default:
+					return c.ArgErr()
+				}
+			case "tls_ca", "tls_cert", "tls_key", "api_version":
+				if err := parseClientOption(c, d, selector); err != nil {
+					return err
+				}
+			case "runtime":
+				if err := parseRuntime(c, d); err != nil {
+					return err
+				}
+			case "source":
+				if err := parseSource(c, d); err != nil {
+					return err
+				}
 			case "zone":
 				args := c.RemainingArgs()
 				if len(args) == 0 {
@@ -130,6 +457,47 @@ func parse(c *caddy.Controller, d *Docker) error {
 					}
 					d.zones = append(d.zones, z)
 				}
+			case "dnssec":
+				d.dnssec = true
+				d.dnssecKeys = c.RemainingArgs()
+			case "update_key":
+				args := c.RemainingArgs()
+				if len(args) != 3 {
+					return c.ArgErr()
+				}
+				name := dns.Fqdn(args[0])
+				if _, ok := dns.IsDomainName(name); !ok {
+					return c.Errf("invalid update_key name %q", args[0])
+				}
+				algorithm, ok := tsigAlgorithms[strings.TrimSuffix(strings.ToLower(args[1]), ".")]
+				if !ok {
+					return c.Errf("unsupported update_key algorithm %q", args[1])
+				}
+				if _, err := base64.StdEncoding.DecodeString(args[2]); err != nil {
+					return c.Errf("error parsing update_key secret: %v", err)
+				}
+				if _, ok := d.updateKeys[name]; ok {
+					return c.Errf("duplicate update_key %q", name)
+				}
+				if d.updateKeys == nil {
+					d.updateKeys = make(map[string]updateKey)
+				}
+				d.updateKeys[name] = updateKey{algorithm: algorithm, secret: args[2]}
+			case "update_lease":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				dur, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return c.Errf("error parsing update_lease: %v", err)
+				}
+				if dur <= 0 || dur%time.Second != 0 {
+					return c.Errf("update_lease must be a positive whole number of seconds: %s", c.Val())
+				}
+				d.updateLease = dur
+				if c.NextArg() {
+					return c.ArgErr()
+				}
 			case "name_from_labels":
 				args := c.RemainingArgs()
 				if len(args) == 0 {
@@ -147,5 +515,197 @@ func parse(c *caddy.Controller, d *Docker) error {
 		}
 	}
 
+	if d.sourceDir != "" {
+		if len(d.endpoints) > 0 || d.endpoint != "" || d.runtime != "" || d.tlsCA != "" || d.tlsCert != "" || d.tlsKey != "" || d.apiVersion != "" || d.heartbeatInterval > 0 || d.swarmInterval > 0 {
+			return c.Err("source file cannot be combined with endpoint, runtime, tls_*, api_version, heartbeat or swarm")
+		}
+		return nil
+	}
+	if len(d.endpoints) > 0 {
+		if d.endpoint != "" || d.runtime != "" || d.tlsCA != "" || d.tlsCert != "" || d.tlsKey != "" || d.apiVersion != "" {
+			return c.Err("endpoint URL, runtime, tls_* and api_version cannot be combined with named endpoint blocks; set them inside each block instead")
+		}
+		for _, e := range d.endpoints {
+			if err := validateClient(c, e); err != nil {
+				return err
+			}
+		}
+		d.configureEndpoints()
+	}
+	return validateClient(c, d)
+}
+
+// parseEndpointURL validates an endpoint URL, or auto, and sets it on d.
+func parseEndpointURL(c *caddy.Controller, d *Docker, endpoint string) error {
+	if endpoint != endpointAuto {
+		if _, err := validateEndpoint(endpoint); err != nil {
+			return c.Errf("error parsing endpoint: %v", err)
+		}
+	}
+	d.endpoint = endpoint
+	return nil
+}
+
+// parseNamedEndpoint parses an "endpoint NAME URL" line and its optional
+// block of per-endpoint options.
+func parseNamedEndpoint(c *caddy.Controller, name, url string) (*Docker, error) {
+	e := &Docker{name: name}
+	if err := parseEndpointURL(c, e, url); err != nil {
+		return nil, err
+	}
+	// RemainingArgs stops in front of an opening brace, so another
+	// argument on this line means the endpoint has a block.
+	if !c.NextArg() {
+		return e, nil
+	}
+	for c.Next() {
+		selector := c.Val()
+		switch selector {
+		case "}":
+			return e, nil
+		case "subzone":
+			args := c.RemainingArgs()
+			if len(args) > 1 {
+				return nil, c.ArgErr()
+			}
+			subzone := name
+			if len(args) == 1 {
+				subzone = args[0]
+			}
+			subzone = strings.Trim(subzone, ".")
+			if subzone == "" {
+				return nil, c.Err("subzone cannot be empty")
+			}
+			e.subzone = strings.ToLower(subzone)
+		case "tls_ca", "tls_cert", "tls_key", "api_version":
+			if err := parseClientOption(c, e, selector); err != nil {
+				return nil, err
+			}
+		case "runtime":
+			if err := parseRuntime(c, e); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, c.Errf("unknown endpoint property '%s'", selector)
+		}
+	}
+	return nil, c.EOFErr()
+}
+
+// parseClientOption parses one of the Docker client options that can be
+// set both at the top level and inside an endpoint block.
+func parseClientOption(c *caddy.Controller, d *Docker, selector string) error {
+	if !c.NextArg() {
+		return c.ArgErr()
+	}
+	val := c.Val()
+	if c.NextArg() {
+		return c.ArgErr()
+	}
+	switch selector {
+	case "api_version":
+		if !apiVersionPattern.MatchString(val) {
+			return c.Errf("api_version must look like 1.47: %q", val)
+		}
+		d.apiVersion = val
+		return nil
+	}
+	if _, err := os.Stat(val); err != nil {
+		return c.Errf("error reading %s: %v", selector, err)
+	}
+	switch selector {
+	case "tls_ca":
+		d.tlsCA = val
+	case "tls_cert":
+		d.tlsCert = val
+	case "tls_key":
+		d.tlsKey = val
+	}
+	return nil
+}
+
+// parseRuntime parses "runtime docker" or "runtime containerd
+// [NAMESPACE]".
+func parseRuntime(c *caddy.Controller, d *Docker) error {
+	args := c.RemainingArgs()
+	if len(args) == 0 || len(args) > 2 {
+		return c.ArgErr()
+	}
+	switch args[0] {
+	case runtimeDocker:
+		if len(args) > 1 {
+			return c.Err("runtime docker takes no namespace")
+		}
+	case runtimeContainerd:
+		if len(args) > 1 {
+			d.namespace = args[1]
+		}
+	default:
+		return c.Errf("unknown runtime %q, expected docker or containerd", args[0])
+	}
+	d.runtime = args[0]
+	return nil
+}
+
+// parseSource parses "source docker" or "source file DIR".
+func parseSource(c *caddy.Controller, d *Docker) error {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return c.ArgErr()
+	}
+	switch args[0] {
+	case "docker":
+		if len(args) != 1 {
+			return c.ArgErr()
+		}
+		d.sourceDir = ""
+	case sourceFile:
+		if len(args) != 2 {
+			return c.ArgErr()
+		}
+		fi, err := os.Stat(args[1])
+		if err != nil {
+			return c.Errf("error reading source directory: %v", err)
+		}
+		if !fi.IsDir() {
+			return c.Errf("source file requires a directory: %s", args[1])
+		}
+		d.sourceDir = args[1]
+	default:
+		return c.Errf("unknown source %q, expected docker or file", args[0])
+	}
+	return nil
+}
+
+// validateClient checks that d's client options fit together.
+func validateClient(c *caddy.Controller, d *Docker) error {
+	if d.runtime == runtimeContainerd {
+		if d.swarmInterval > 0 {
+			return c.Err("swarm only applies to runtime docker")
+		}
+		if d.tlsCA != "" || d.tlsCert != "" || d.tlsKey != "" || d.apiVersion != "" {
+			return c.Err("tls_* and api_version only apply to runtime docker")
+		}
+		if d.endpoint != "" {
+			if scheme, _ := validateEndpoint(d.endpoint); scheme != "unix" {
+				return c.Err("runtime containerd requires a unix:// endpoint")
+			}
+		}
+		return nil
+	}
+	return validateTLS(c, d)
+}
+
+// validateTLS checks that d's TLS options are complete and only used with
+// a tcp:// endpoint.
+func validateTLS(c *caddy.Controller, d *Docker) error {
+	if (d.tlsCert == "") != (d.tlsKey == "") {
+		return c.Err("tls_cert and tls_key must be set together")
+	}
+	if d.tlsCA != "" || d.tlsCert != "" {
+		if scheme, _ := validateEndpoint(d.endpoint); scheme != "tcp" {
+			return c.Err("tls_ca, tls_cert and tls_key require a tcp:// endpoint")
+		}
+	}
 	return nil
 }
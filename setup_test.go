@@ -1,10 +1,13 @@
 package docker
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin/pkg/fall"
 )
 
@@ -448,3 +451,808 @@ func TestParseNameFromLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLinkLocal(t *testing.T) {
+	tests := []struct {
+		name              string
+		input             string
+		shouldErr         bool
+		expectedLinkLocal bool
+	}{
+		{
+			name:              "default off",
+			input:             `docker`,
+			expectedLinkLocal: false,
+		},
+		{
+			name: "enabled",
+			input: `docker {
+				link_local
+			}`,
+			expectedLinkLocal: true,
+		},
+		{
+			name: "unexpected argument",
+			input: `docker {
+				link_local yes
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", test.input)
+			d := &Docker{
+				labelPrefix: "com.dokku.coredns-docker",
+				maxBackoff:  60 * time.Second,
+				ttl:         DefaultTTL,
+				zones:       []string{"docker."},
+			}
+			err := parse(c, d)
+
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if d.linkLocal != test.expectedLinkLocal {
+				t.Errorf("expected linkLocal %t, got %t", test.expectedLinkLocal, d.linkLocal)
+			}
+		})
+	}
+}
+
+func TestParseSyncDebounce(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		shouldErr        bool
+		expectedDebounce time.Duration
+		expectedMaxDelay time.Duration
+	}{
+		{
+			name:  "default off",
+			input: `docker`,
+		},
+		{
+			name: "window only uses default max delay",
+			input: `docker {
+				sync_debounce 200ms
+			}`,
+			expectedDebounce: 200 * time.Millisecond,
+			expectedMaxDelay: 200 * time.Millisecond * defaultSyncMaxDelayFactor,
+		},
+		{
+			name: "window and max delay",
+			input: `docker {
+				sync_debounce 200ms 2s
+			}`,
+			expectedDebounce: 200 * time.Millisecond,
+			expectedMaxDelay: 2 * time.Second,
+		},
+		{
+			name: "no argument",
+			input: `docker {
+				sync_debounce
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "too many arguments",
+			input: `docker {
+				sync_debounce 200ms 2s 5s
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "invalid window",
+			input: `docker {
+				sync_debounce soon
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "zero window",
+			input: `docker {
+				sync_debounce 0s
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "invalid max delay",
+			input: `docker {
+				sync_debounce 200ms later
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "max delay shorter than window",
+			input: `docker {
+				sync_debounce 1s 200ms
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", test.input)
+			d := &Docker{
+				labelPrefix: "com.dokku.coredns-docker",
+				maxBackoff:  60 * time.Second,
+				ttl:         DefaultTTL,
+				zones:       []string{"docker."},
+			}
+			err := parse(c, d)
+
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if d.syncDebounce != test.expectedDebounce {
+				t.Errorf("expected syncDebounce %s, got %s", test.expectedDebounce, d.syncDebounce)
+			}
+			if d.syncMaxDelay != test.expectedMaxDelay {
+				t.Errorf("expected syncMaxDelay %s, got %s", test.expectedMaxDelay, d.syncMaxDelay)
+			}
+		})
+	}
+}
+
+func TestParseResyncInterval(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		shouldErr        bool
+		expectedInterval time.Duration
+	}{
+		{
+			name:  "default off",
+			input: `docker`,
+		},
+		{
+			name: "interval",
+			input: `docker {
+				resync_interval 5m
+			}`,
+			expectedInterval: 5 * time.Minute,
+		},
+		{
+			name: "no argument",
+			input: `docker {
+				resync_interval
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "invalid duration",
+			input: `docker {
+				resync_interval often
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "zero duration",
+			input: `docker {
+				resync_interval 0s
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", test.input)
+			d := &Docker{
+				labelPrefix: "com.dokku.coredns-docker",
+				maxBackoff:  60 * time.Second,
+				ttl:         DefaultTTL,
+				zones:       []string{"docker."},
+			}
+			err := parse(c, d)
+
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if d.resyncInterval != test.expectedInterval {
+				t.Errorf("expected resyncInterval %s, got %s", test.expectedInterval, d.resyncInterval)
+			}
+		})
+	}
+}
+
+func TestParseTimeouts(t *testing.T) {
+	tests := []struct {
+		name                      string
+		input                     string
+		shouldErr                 bool
+		expectedAPITimeout        time.Duration
+		expectedHeartbeatInterval time.Duration
+		expectedHeartbeatTimeout  time.Duration
+	}{
+		{
+			name:  "defaults off",
+			input: `docker`,
+		},
+		{
+			name: "api timeout",
+			input: `docker {
+				api_timeout 10s
+			}`,
+			expectedAPITimeout: 10 * time.Second,
+		},
+		{
+			name: "heartbeat interval only",
+			input: `docker {
+				heartbeat 15s
+			}`,
+			expectedHeartbeatInterval: 15 * time.Second,
+			expectedHeartbeatTimeout:  15 * time.Second,
+		},
+		{
+			name: "heartbeat interval and timeout",
+			input: `docker {
+				heartbeat 15s 5s
+			}`,
+			expectedHeartbeatInterval: 15 * time.Second,
+			expectedHeartbeatTimeout:  5 * time.Second,
+		},
+		{
+			name: "api timeout missing argument",
+			input: `docker {
+				api_timeout
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "api timeout invalid",
+			input: `docker {
+				api_timeout soon
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "api timeout zero",
+			input: `docker {
+				api_timeout 0s
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "heartbeat missing argument",
+			input: `docker {
+				heartbeat
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "heartbeat too many arguments",
+			input: `docker {
+				heartbeat 1s 1s 1s
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "heartbeat invalid interval",
+			input: `docker {
+				heartbeat often
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "heartbeat zero interval",
+			input: `docker {
+				heartbeat 0s
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "heartbeat invalid timeout",
+			input: `docker {
+				heartbeat 15s soon
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "heartbeat zero timeout",
+			input: `docker {
+				heartbeat 15s 0s
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", test.input)
+			d := &Docker{
+				labelPrefix: "com.dokku.coredns-docker",
+				maxBackoff:  60 * time.Second,
+				ttl:         DefaultTTL,
+				zones:       []string{"docker."},
+			}
+			err := parse(c, d)
+
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if d.apiTimeout != test.expectedAPITimeout {
+				t.Errorf("expected apiTimeout %s, got %s", test.expectedAPITimeout, d.apiTimeout)
+			}
+			if d.heartbeatInterval != test.expectedHeartbeatInterval {
+				t.Errorf("expected heartbeatInterval %s, got %s", test.expectedHeartbeatInterval, d.heartbeatInterval)
+			}
+			if d.heartbeatTimeout != test.expectedHeartbeatTimeout {
+				t.Errorf("expected heartbeatTimeout %s, got %s", test.expectedHeartbeatTimeout, d.heartbeatTimeout)
+			}
+		})
+	}
+}
+
+func TestParseInspectConcurrency(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		shouldErr bool
+		expected  int
+	}{
+		{
+			name:     "default",
+			input:    `docker`,
+			expected: defaultInspectConcurrency,
+		},
+		{
+			name: "custom",
+			input: `docker {
+				inspect_concurrency 32
+			}`,
+			expected: 32,
+		},
+		{
+			name: "serial",
+			input: `docker {
+				inspect_concurrency 1
+			}`,
+			expected: 1,
+		},
+		{
+			name: "missing argument",
+			input: `docker {
+				inspect_concurrency
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "not a number",
+			input: `docker {
+				inspect_concurrency many
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "zero",
+			input: `docker {
+				inspect_concurrency 0
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", test.input)
+			d := &Docker{
+				labelPrefix:        "com.dokku.coredns-docker",
+				maxBackoff:         60 * time.Second,
+				ttl:                DefaultTTL,
+				zones:              []string{"docker."},
+				inspectConcurrency: defaultInspectConcurrency,
+			}
+			err := parse(c, d)
+
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if d.inspectConcurrency != test.expected {
+				t.Errorf("expected inspectConcurrency %d, got %d", test.expected, d.inspectConcurrency)
+			}
+		})
+	}
+}
+
+func TestParseInspectGrace(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		shouldErr bool
+		expected  time.Duration
+	}{
+		{
+			name:     "default",
+			input:    `docker`,
+			expected: defaultInspectGrace,
+		},
+		{
+			name: "custom",
+			input: `docker {
+				inspect_grace 5m
+			}`,
+			expected: 5 * time.Minute,
+		},
+		{
+			name: "disabled",
+			input: `docker {
+				inspect_grace 0s
+			}`,
+			expected: 0,
+		},
+		{
+			name: "missing argument",
+			input: `docker {
+				inspect_grace
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "invalid",
+			input: `docker {
+				inspect_grace forever
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "negative",
+			input: `docker {
+				inspect_grace -1m
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", test.input)
+			d := &Docker{
+				labelPrefix:  "com.dokku.coredns-docker",
+				maxBackoff:   60 * time.Second,
+				ttl:          DefaultTTL,
+				zones:        []string{"docker."},
+				inspectGrace: defaultInspectGrace,
+			}
+			err := parse(c, d)
+
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if d.inspectGrace != test.expected {
+				t.Errorf("expected inspectGrace %s, got %s", test.expected, d.inspectGrace)
+			}
+		})
+	}
+}
+
+func TestParseLazyConnect(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		shouldErr bool
+		expected  bool
+	}{
+		{
+			name:     "default off",
+			input:    `docker`,
+			expected: false,
+		},
+		{
+			name: "enabled",
+			input: `docker {
+				lazy_connect
+			}`,
+			expected: true,
+		},
+		{
+			name: "unexpected argument",
+			input: `docker {
+				lazy_connect yes
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", test.input)
+			d := &Docker{
+				labelPrefix: "com.dokku.coredns-docker",
+				maxBackoff:  60 * time.Second,
+				ttl:         DefaultTTL,
+				zones:       []string{"docker."},
+			}
+			err := parse(c, d)
+
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if d.lazyConnect != test.expected {
+				t.Errorf("expected lazyConnect %t, got %t", test.expected, d.lazyConnect)
+			}
+		})
+	}
+}
+
+func TestSetupUnreachableDaemon(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "unix://"+filepath.Join(t.TempDir(), "docker.sock"))
+
+	c := caddy.NewTestController("dns", `docker`)
+	if err := setup(c); err == nil {
+		t.Errorf("expected setup to fail without a reachable daemon")
+	}
+
+	c = caddy.NewTestController("dns", `docker {
+		lazy_connect
+	}`)
+	if err := setup(c); err != nil {
+		t.Fatalf("expected lazy_connect setup to succeed, got %v", err)
+	}
+	plugins := dnsserver.GetConfig(c).Plugin
+	if len(plugins) != 1 {
+		t.Fatalf("expected the plugin to be registered, got %d plugins", len(plugins))
+	}
+	d := plugins[0](nil).(*Docker)
+	defer d.client.Close()
+	if d.Ready() {
+		t.Errorf("expected lazy_connect plugin not to be ready before its first sync")
+	}
+}
+
+func TestParseSnapshotFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		shouldErr bool
+		expected  string
+	}{
+		{
+			name:     "default off",
+			input:    `docker`,
+			expected: "",
+		},
+		{
+			name: "path",
+			input: `docker {
+				snapshot_file /var/lib/coredns/docker.json
+			}`,
+			expected: "/var/lib/coredns/docker.json",
+		},
+		{
+			name: "missing argument",
+			input: `docker {
+				snapshot_file
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "too many arguments",
+			input: `docker {
+				snapshot_file a.json b.json
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", test.input)
+			d := &Docker{
+				labelPrefix: "com.dokku.coredns-docker",
+				maxBackoff:  60 * time.Second,
+				ttl:         DefaultTTL,
+				zones:       []string{"docker."},
+			}
+			err := parse(c, d)
+
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if d.snapshotFile != test.expected {
+				t.Errorf("expected snapshotFile %q, got %q", test.expected, d.snapshotFile)
+			}
+		})
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	cert := filepath.Join(dir, "cert.pem")
+	key := filepath.Join(dir, "key.pem")
+	for _, path := range []string{ca, cert, key} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		input      string
+		shouldErr  bool
+		endpoint   string
+		tlsCA      string
+		tlsCert    string
+		tlsKey     string
+		apiVersion string
+	}{
+		{
+			name:  "default",
+			input: `docker`,
+		},
+		{
+			name: "unix socket",
+			input: `docker {
+				endpoint unix:///run/user/1000/docker.sock
+			}`,
+			endpoint: "unix:///run/user/1000/docker.sock",
+		},
+		{
+			name: "auto",
+			input: `docker {
+				endpoint auto
+			}`,
+			endpoint: "auto",
+		},
+		{
+			name: "tcp with tls",
+			input: `docker {
+				endpoint tcp://10.0.0.5:2376
+				tls_ca ` + ca + `
+				tls_cert ` + cert + `
+				tls_key ` + key + `
+				api_version 1.47
+			}`,
+			endpoint:   "tcp://10.0.0.5:2376",
+			tlsCA:      ca,
+			tlsCert:    cert,
+			tlsKey:     key,
+			apiVersion: "1.47",
+		},
+		{
+			name: "tcp with ca only",
+			input: `docker {
+				endpoint tcp://10.0.0.5:2376
+				tls_ca ` + ca + `
+			}`,
+			endpoint: "tcp://10.0.0.5:2376",
+			tlsCA:    ca,
+		},
+		{
+			name: "unsupported scheme",
+			input: `docker {
+				endpoint ssh://user@host
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "not a url",
+			input: `docker {
+				endpoint /var/run/docker.sock
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "missing endpoint argument",
+			input: `docker {
+				endpoint
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "tls without tcp endpoint",
+			input: `docker {
+				endpoint unix:///var/run/docker.sock
+				tls_ca ` + ca + `
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "cert without key",
+			input: `docker {
+				endpoint tcp://10.0.0.5:2376
+				tls_cert ` + cert + `
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "missing tls file",
+			input: `docker {
+				endpoint tcp://10.0.0.5:2376
+				tls_ca ` + filepath.Join(dir, "missing.pem") + `
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "invalid api_version",
+			input: `docker {
+				api_version latest
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", test.input)
+			d := &Docker{
+				labelPrefix: "com.dokku.coredns-docker",
+				maxBackoff:  60 * time.Second,
+				ttl:         DefaultTTL,
+				zones:       []string{"docker."},
+			}
+			err := parse(c, d)
+
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if d.endpoint != test.endpoint {
+				t.Errorf("expected endpoint %q, got %q", test.endpoint, d.endpoint)
+			}
+			if d.tlsCA != test.tlsCA || d.tlsCert != test.tlsCert || d.tlsKey != test.tlsKey {
+				t.Errorf("expected tls %q/%q/%q, got %q/%q/%q", test.tlsCA, test.tlsCert, test.tlsKey, d.tlsCA, d.tlsCert, d.tlsKey)
+			}
+			if d.apiVersion != test.apiVersion {
+				t.Errorf("expected apiVersion %q, got %q", test.apiVersion, d.apiVersion)
+			}
+		})
+	}
+}
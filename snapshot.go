@@ -0,0 +1,83 @@
+package docker
+
+import "time"
+
+// snapshot is an immutable view of everything ServeDNS reads: the merged
+// record tables, the answers precomputed from them, and the connection
+// state that decides between normal and stale TTLs. A new snapshot is
+// published through an atomic pointer whenever either changes, so queries
+// never wait on a sync and always see records and connection state from
+// the same moment.
+type snapshot struct {
+	recordSet
+	answers      *answerTable
+	connected    bool
+	lastSyncTime time.Time
+	containers   int
+	// staleNames lists the names whose endpoint is disconnected while
+	// others are still connected. They get the stale TTL even though
+	// connected is true.
+	staleNames map[string]struct{}
+}
+
+// emptySnapshot is served before the first snapshot is published. Lookups
+// in its nil maps find nothing.
+var emptySnapshot = &snapshot{}
+
+// loadSnapshot returns the current snapshot. The caller must not modify
+// it.
+func (d *Docker) loadSnapshot() *snapshot {
+	if s := d.snap.Load(); s != nil {
+		return s
+	}
+	return emptySnapshot
+}
+
+// updateSnapshot publishes a copy of the current snapshot with fn applied
+// to it. Writers are serialized by d.mu so concurrent updates (a sync
+// finishing while the heartbeat marks the daemon down) never lose each
+// other's changes; readers never take the lock.
+func (d *Docker) updateSnapshot(fn func(*snapshot)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	next := *d.loadSnapshot()
+	fn(&next)
+	d.snap.Store(&next)
+}
+
+// fresh reports whether answers for name get the normal TTL: the plugin
+// is connected and name does not come from a disconnected endpoint.
+func (s *snapshot) fresh(name string) bool {
+	if !s.connected {
+		return false
+	}
+	_, stale := s.staleNames[name]
+	return !stale
+}
+
+// setConnected records whether the plugin is connected to the Docker
+// daemon and updates the connected gauges. For a named endpoint the
+// aggregated snapshot is republished, since which names are stale
+// depends on it.
+func (d *Docker) setConnected(connected bool) {
+	d.updateSnapshot(func(s *snapshot) { s.connected = connected })
+	if connected {
+		endpointConnectedGauge.WithLabelValues(d.endpointName()).Set(1)
+	} else {
+		endpointConnectedGauge.WithLabelValues(d.endpointName()).Set(0)
+	}
+	if d.parent != nil {
+		d.parent.publishEndpoints()
+		return
+	}
+	setConnectedGauge(connected)
+}
+
+// setConnectedGauge updates the plugin-wide connected gauge.
+func setConnectedGauge(connected bool) {
+	if connected {
+		connectedGauge.Set(1)
+	} else {
+		connectedGauge.Set(0)
+	}
+}
@@ -0,0 +1,152 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/docker/docker/api/types/container"
+	"github.com/miekg/dns"
+)
+
+func TestLoadSnapshotBeforePublish(t *testing.T) {
+	d := &Docker{}
+	snap := d.loadSnapshot()
+	if snap.connected || !snap.lastSyncTime.IsZero() || len(snap.records) != 0 {
+		t.Errorf("expected an empty snapshot before the first publish, got %+v", snap)
+	}
+}
+
+func TestUpdateSnapshotKeepsConcurrentChanges(t *testing.T) {
+	d := newSyncTestDocker()
+	d.containerSets = map[string]*recordSet{
+		"c1": {records: map[string][]net.IP{"web.docker.": {net.ParseIP("172.17.0.2")}}},
+	}
+	d.containerOrder = []string{"c1"}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			d.syncMu.Lock()
+			d.publishLocked()
+			d.syncMu.Unlock()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range 100 {
+			d.setConnected(i%2 == 0)
+		}
+		d.setConnected(true)
+	}()
+	wg.Wait()
+
+	snap := d.loadSnapshot()
+	if !snap.connected {
+		t.Errorf("expected the last setConnected to survive concurrent publishes")
+	}
+	if _, ok := snap.records["web.docker."]; !ok {
+		t.Errorf("expected published records to survive concurrent setConnected calls")
+	}
+}
+
+func TestSnapshotIsImmutable(t *testing.T) {
+	d := newSyncTestDocker()
+	d.containerSets = map[string]*recordSet{
+		"c1": {records: map[string][]net.IP{"web.docker.": {net.ParseIP("172.17.0.2")}}},
+	}
+	d.containerOrder = []string{"c1"}
+	d.publishLocked()
+	before := d.loadSnapshot()
+
+	d.deleteContainerLocked("c1")
+	d.publishLocked()
+	d.setConnected(true)
+
+	if _, ok := before.records["web.docker."]; !ok {
+		t.Errorf("expected a loaded snapshot to keep its records after later publishes")
+	}
+	if before.connected {
+		t.Errorf("expected a loaded snapshot to keep its connection state")
+	}
+	if _, ok := d.loadSnapshot().records["web.docker."]; ok {
+		t.Errorf("expected the current snapshot to drop the removed container")
+	}
+}
+
+// newBenchmarkDocker returns a plugin tracking count containers, each
+// named webN with its own IP.
+func newBenchmarkDocker(b *testing.B, count int) (*Docker, []container.Summary, ContainerInspector) {
+	b.Helper()
+	inspector := &mockContainerInspector{inspections: map[string]container.InspectResponse{}}
+	containers := make([]container.Summary, 0, count)
+	for i := range count {
+		id := fmt.Sprintf("c%d", i)
+		inspector.inspections[id] = runningContainer(fmt.Sprintf("web%d", i), fmt.Sprintf("172.17.%d.%d", i/250, i%250+2), nil)
+		containers = append(containers, container.Summary{ID: id})
+	}
+	d := newSyncTestDocker()
+	d.Next = test.ErrorHandler()
+	d.containerOrder, d.containerSets = generateRecordSets(context.Background(), d.generateInput(containers, inspector))
+	d.publishLocked()
+	d.setConnected(true)
+	return d, containers, inspector
+}
+
+func benchmarkServeDNS(b *testing.B, d *Docker, count int) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := context.Background()
+		i := 0
+		for pb.Next() {
+			m := new(dns.Msg)
+			m.SetQuestion(fmt.Sprintf("web%d.docker.", i%count), dns.TypeA)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := d.ServeDNS(ctx, rec, m); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+// BenchmarkServeDNS measures query latency with no concurrent syncs.
+func BenchmarkServeDNS(b *testing.B) {
+	const count = 500
+	d, _, _ := newBenchmarkDocker(b, count)
+	benchmarkServeDNS(b, d, count)
+}
+
+// BenchmarkServeDNSSyncChurn measures query latency while full syncs
+// publish new snapshots back to back, as during a burst of Docker events.
+func BenchmarkServeDNSSyncChurn(b *testing.B) {
+	const count = 500
+	d, containers, inspector := newBenchmarkDocker(b, count)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			order, sets := generateRecordSets(ctx, d.generateInput(containers, inspector))
+			d.syncMu.Lock()
+			d.containerOrder, d.containerSets = order, sets
+			d.publishLocked()
+			d.syncMu.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	benchmarkServeDNS(b, d, count)
+	b.StopTimer()
+	cancel()
+	<-done
+}
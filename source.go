@@ -0,0 +1,339 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+)
+
+// ContainerSource supplies the containers records are generated from. The
+// Docker client is one implementation (see dockerSource); other runtimes
+// and test fixtures plug in by implementing it, without touching record
+// generation or ServeDNS.
+type ContainerSource interface {
+	// List returns the containers that are currently running.
+	List(ctx context.Context) ([]ContainerRef, error)
+	// Inspect returns a container's current state. An error for which
+	// errdefs.IsNotFound holds means the container is gone.
+	Inspect(ctx context.Context, id string) (Container, error)
+	// Watch streams changes to containers until ctx is cancelled or the
+	// source fails, in which case the error channel receives the error.
+	Watch(ctx context.Context) (<-chan ContainerEvent, <-chan error)
+}
+
+// ContainerRef identifies a listed container.
+type ContainerRef struct {
+	ID string
+	// Name is used in logs and metrics.
+	Name string
+	// Fingerprint changes whenever the container changes in a way that
+	// matters for records, so an unchanged container can be served from
+	// the inspect cache. Zero means the source cannot tell, and the
+	// container is inspected on every sync.
+	Fingerprint uint64
+}
+
+// ContainerEvent reports that a container changed.
+type ContainerEvent struct {
+	ID string
+	// Name is used in logs and metrics.
+	Name string
+	// Remove is set when the container stopped, was removed, or was
+	// paused, so its records can be dropped without inspecting it.
+	Remove bool
+}
+
+// Container is the runtime-neutral view of a container that records are
+// generated from.
+type Container struct {
+	ID   string
+	Name string
+	// Aliases are extra names the container is published under on every
+	// network, such as its Podman pod's name.
+	Aliases []string
+	Labels  map[string]string
+
+	Running bool
+	Paused  bool
+
+	// PrimaryNetwork is the network records come from when no networks
+	// filter is configured.
+	PrimaryNetwork string
+	// Networks maps network names to the container's endpoint on each.
+	// A nil map means the container publishes no records.
+	Networks map[string]ContainerNetwork
+	// Ports maps container port specs ("80/tcp", or "80" for both
+	// protocols) to their host bindings, which may be empty for ports
+	// that are exposed but not published.
+	Ports map[string][]PortBinding
+}
+
+// ContainerNetwork is a container's endpoint on one network.
+type ContainerNetwork struct {
+	IPAddress         string
+	GlobalIPv6Address string
+	LinkLocalIPs      []string
+	Aliases           []string
+	DNSNames          []string
+}
+
+// PortBinding is a host address a container port is published on. Empty
+// or unspecified host IPs mean loopback in host mode.
+type PortBinding struct {
+	HostIP   string
+	HostPort string
+}
+
+// containerSource returns the source the next sync reads containers from:
+// the configured source if there is one, or else a dockerSource for the
+// plugin's client, shared with other instances through d's watcher.
+func (d *Docker) containerSource() ContainerSource {
+	if d.source != nil {
+		return d.source
+	}
+	src := d.newDockerSource(d.client, d.withAPITimeout(d.client))
+	if d.watcher != nil {
+		return &sharedSource{w: d.watcher, src: src}
+	}
+	return src
+}
+
+// dockerSource is the ContainerSource for a Docker-compatible daemon,
+// including Podman and the containerd adapter. It captures the daemon's
+// Podman state when it is created, so each sync and event batch gets its
+// own.
+type dockerSource struct {
+	d *Docker
+	// client lists and watches containers; inspector inspects them.
+	// client may be nil for a source that only inspects.
+	client    daemonClient
+	inspector ContainerInspector
+	podman    bool
+
+	podsOnce sync.Once
+	pods     podIndex
+
+	// inspected remembers inspect responses in Podman mode, so a pod's
+	// infra container is inspected once however many members share its
+	// network namespace.
+	mu        sync.Mutex
+	inspected map[string]container.InspectResponse
+}
+
+// newDockerSource returns a dockerSource for the plugin's current state.
+func (d *Docker) newDockerSource(client daemonClient, inspector ContainerInspector) *dockerSource {
+	return &dockerSource{
+		d:         d,
+		client:    client,
+		inspector: inspector,
+		podman:    d.podman.Load(),
+		inspected: make(map[string]container.InspectResponse),
+	}
+}
+
+// List implements ContainerSource.
+func (s *dockerSource) List(ctx context.Context) ([]ContainerRef, error) {
+	listCtx, cancel := s.d.apiContext(ctx)
+	containers, err := s.client.ContainerList(listCtx, container.ListOptions{})
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	s.loadPods(ctx)
+	return s.refs(containers), nil
+}
+
+// refs returns the ContainerRefs for a container list.
+func (s *dockerSource) refs(containers []container.Summary) []ContainerRef {
+	refs := make([]ContainerRef, len(containers))
+	for i, c := range containers {
+		refs[i] = ContainerRef{ID: c.ID, Name: summaryName(c), Fingerprint: summaryFingerprint(c)}
+		// A container sharing another's network namespace takes its
+		// addresses from that container, which its own summary does not
+		// show.
+		if s.podman && strings.HasPrefix(c.HostConfig.NetworkMode, "container:") {
+			refs[i].Fingerprint = 0
+		}
+	}
+	return refs
+}
+
+// loadPods refreshes the pod index once per source.
+func (s *dockerSource) loadPods(ctx context.Context) {
+	s.podsOnce.Do(func() {
+		if s.d == nil {
+			return
+		}
+		s.d.refreshPods(ctx)
+		s.pods = s.d.loadPods()
+	})
+}
+
+// Inspect implements ContainerSource.
+func (s *dockerSource) Inspect(ctx context.Context, id string) (Container, error) {
+	inspect, err := s.inspect(ctx, id)
+	if err != nil {
+		return Container{}, err
+	}
+	return s.container(ctx, id, inspect), nil
+}
+
+// inspect inspects a container, reusing an earlier response in Podman
+// mode.
+func (s *dockerSource) inspect(ctx context.Context, id string) (container.InspectResponse, error) {
+	if !s.podman {
+		return s.inspector.ContainerInspect(ctx, id)
+	}
+	s.mu.Lock()
+	inspect, ok := s.inspected[id]
+	s.mu.Unlock()
+	if ok {
+		return inspect, nil
+	}
+	inspect, err := s.inspector.ContainerInspect(ctx, id)
+	if err != nil {
+		return inspect, err
+	}
+	s.mu.Lock()
+	s.inspected[id] = inspect
+	s.mu.Unlock()
+	return inspect, nil
+}
+
+// container converts an inspect response to a Container. Containers whose
+// response is incomplete, and Podman infra containers, get no networks
+// and so publish no records.
+func (s *dockerSource) container(ctx context.Context, id string, inspect container.InspectResponse) Container {
+	c := Container{ID: id}
+	// Guard against nil pointer fields in the inspect response
+	if inspect.ContainerJSONBase == nil || inspect.ContainerJSONBase.HostConfig == nil {
+		log.Debugf("Container %s has incomplete inspect response, skipping", id)
+		return c
+	}
+	c.Name = strings.TrimPrefix(inspect.Name, "/")
+	if inspect.State != nil {
+		c.Running = inspect.State.Running
+		c.Paused = inspect.State.Paused
+	}
+	if inspect.Config != nil {
+		c.Labels = inspect.Config.Labels
+	}
+
+	// Podman pod members share their pod's infra container's network
+	// namespace and publish the pod name alongside their own. The infra
+	// container itself runs nothing worth resolving.
+	var podmanPrimary string
+	if s.podman {
+		s.loadPods(ctx)
+		member := s.pods[id]
+		if member.infra {
+			log.Debugf("Container %s is the infra container of pod %s, skipping", id, member.pod)
+			return c
+		}
+		if member.pod != "" {
+			c.Aliases = []string{member.pod}
+		}
+		peers := make(map[string]container.InspectResponse, 1)
+		if peerID, ok := networkPeerID(inspect); ok {
+			peer, err := s.inspect(ctx, peerID)
+			if err != nil {
+				log.Debugf("Failed to inspect network peer %s: %v", peerID, err)
+			} else {
+				peers[peerID] = peer
+			}
+		}
+		inspect, podmanPrimary = podmanNetworking(id, inspect, peers)
+	}
+
+	if inspect.NetworkSettings == nil || inspect.NetworkSettings.Networks == nil {
+		return c
+	}
+
+	// Determine the primary network name from NetworkMode
+	c.PrimaryNetwork = string(inspect.HostConfig.NetworkMode)
+	if s.podman {
+		c.PrimaryNetwork = podmanPrimary
+	} else if c.PrimaryNetwork == "" || c.PrimaryNetwork == "default" {
+		c.PrimaryNetwork = "bridge"
+	}
+
+	c.Networks = make(map[string]ContainerNetwork, len(inspect.NetworkSettings.Networks))
+	for name, settings := range inspect.NetworkSettings.Networks {
+		if settings == nil {
+			continue
+		}
+		n := ContainerNetwork{
+			IPAddress:         settings.IPAddress,
+			GlobalIPv6Address: settings.GlobalIPv6Address,
+			Aliases:           settings.Aliases,
+			DNSNames:          settings.DNSNames,
+		}
+		if settings.IPAMConfig != nil {
+			n.LinkLocalIPs = settings.IPAMConfig.LinkLocalIPs
+		}
+		c.Networks[name] = n
+	}
+
+	if len(inspect.NetworkSettings.Ports) > 0 {
+		c.Ports = make(map[string][]PortBinding, len(inspect.NetworkSettings.Ports))
+		for port, bindings := range inspect.NetworkSettings.Ports {
+			converted := make([]PortBinding, len(bindings))
+			for i, b := range bindings {
+				converted[i] = PortBinding{HostIP: b.HostIP, HostPort: b.HostPort}
+			}
+			c.Ports[string(port)] = converted
+		}
+	}
+	return c
+}
+
+// Watch implements ContainerSource. It subscribes to the daemon's events
+// and passes on the ones that affect a single container.
+func (s *dockerSource) Watch(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	msgs, errs := s.client.Events(ctx, events.ListOptions{
+		Filters: eventFilter(s.podman),
+	})
+	out := make(chan ContainerEvent)
+	outErrs := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				outErrs <- ctx.Err()
+				return
+			case err := <-errs:
+				outErrs <- err
+				return
+			case msg := <-msgs:
+				if s.podman {
+					msg = normalizePodmanEvent(msg)
+				}
+				log.Debugf("Docker event: %s %s %s", msg.Type, msg.Action, msg.Actor.ID)
+				ev, ok := dockerEvent(msg)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					outErrs <- ctx.Err()
+					return
+				}
+			}
+		}
+	}()
+	return out, outErrs
+}
+
+// dockerEvent converts a Docker event to a ContainerEvent. It returns
+// false for events that do not affect a single container.
+func dockerEvent(msg events.Message) (ContainerEvent, bool) {
+	id := eventContainerID(msg)
+	if id == "" {
+		return ContainerEvent{}, false
+	}
+	return ContainerEvent{ID: id, Name: eventContainerName(msg), Remove: removesContainer(msg)}, true
+}
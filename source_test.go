@@ -0,0 +1,283 @@
+package docker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// fixtureSource is an in-memory ContainerSource.
+type fixtureSource struct {
+	mu         sync.Mutex
+	containers map[string]Container
+	events     chan ContainerEvent
+}
+
+func newFixtureSource(containers ...Container) *fixtureSource {
+	f := &fixtureSource{containers: map[string]Container{}, events: make(chan ContainerEvent)}
+	for _, c := range containers {
+		f.containers[c.ID] = c
+	}
+	return f
+}
+
+func (f *fixtureSource) List(ctx context.Context) ([]ContainerRef, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var refs []ContainerRef
+	for id, c := range f.containers {
+		refs = append(refs, ContainerRef{ID: id, Name: c.Name})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].ID < refs[j].ID })
+	return refs, nil
+}
+
+func (f *fixtureSource) Inspect(ctx context.Context, id string) (Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return Container{}, cerrdefs.ErrNotFound
+	}
+	return c, nil
+}
+
+func (f *fixtureSource) Watch(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	errs := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		errs <- ctx.Err()
+	}()
+	return f.events, errs
+}
+
+func (f *fixtureSource) set(c Container) {
+	f.mu.Lock()
+	f.containers[c.ID] = c
+	f.mu.Unlock()
+}
+
+// fixtureContainer returns a running container on the bridge network.
+func fixtureContainer(id, name, ip string) Container {
+	return Container{
+		ID:             id,
+		Name:           name,
+		Running:        true,
+		PrimaryNetwork: "bridge",
+		Networks:       map[string]ContainerNetwork{"bridge": {IPAddress: ip}},
+	}
+}
+
+func TestFullSyncFromSource(t *testing.T) {
+	d := newSyncTestDocker()
+	src := newFixtureSource(fixtureContainer("c1", "web", "172.17.0.2"), fixtureContainer("c2", "api", "172.17.0.3"))
+	d.source = src
+	if d.Ready() {
+		t.Errorf("expected the plugin not to be ready before the first sync")
+	}
+
+	if _, err := d.fullSync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+	assertRecordIPs(t, d, "api.docker.", "172.17.0.3")
+	if !d.Ready() {
+		t.Errorf("expected the plugin to be ready once a source has synced")
+	}
+}
+
+func TestApplyContainerEventsFromSource(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	src := newFixtureSource()
+	d.source = src
+
+	src.set(fixtureContainer("c1", "web", "172.17.0.2"))
+	d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c1"}})
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+
+	d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c1", Remove: true}})
+	assertRecordIPs(t, d, "web.docker.")
+
+	d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c1"}})
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+
+	// A container the source no longer knows about is dropped.
+	src.mu.Lock()
+	delete(src.containers, "c1")
+	src.mu.Unlock()
+	d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c1"}})
+	assertRecordIPs(t, d, "web.docker.")
+}
+
+// pausedListSource is a fixtureSource whose List returns its result only
+// once released, so a test can change the source in between.
+type pausedListSource struct {
+	*fixtureSource
+	listed  chan struct{}
+	release chan struct{}
+}
+
+func (p *pausedListSource) List(ctx context.Context) ([]ContainerRef, error) {
+	refs, err := p.fixtureSource.List(ctx)
+	close(p.listed)
+	<-p.release
+	return refs, err
+}
+
+func TestFullSyncKeepsEventAppliedDuringList(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	src := &pausedListSource{
+		fixtureSource: newFixtureSource(fixtureContainer("c1", "web", "172.17.0.2")),
+		listed:        make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+	d.source = src
+
+	synced := make(chan struct{})
+	go func() {
+		defer close(synced)
+		if _, err := d.fullSync(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// A container starts after the list was taken but before it is applied.
+	<-src.listed
+	src.set(fixtureContainer("c2", "api", "172.17.0.3"))
+	applied := make(chan struct{})
+	go func() {
+		defer close(applied)
+		d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c2"}})
+	}()
+	select {
+	case <-applied:
+		t.Fatal("expected the event to wait for the full sync")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(src.release)
+	<-synced
+	<-applied
+
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+	assertRecordIPs(t, d, "api.docker.", "172.17.0.3")
+}
+
+func TestEventLoopWatchesSource(t *testing.T) {
+	d := newSyncTestDocker()
+	d.maxBackoff = time.Second
+	src := newFixtureSource(fixtureContainer("c1", "web", "172.17.0.2"))
+	d.source = src
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.startEventLoop(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	src.set(fixtureContainer("c2", "api", "172.17.0.3"))
+	select {
+	case src.events <- ContainerEvent{ID: "c2", Name: "api"}:
+	case <-time.After(time.Second):
+		t.Fatal("event loop did not watch the source")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := d.loadSnapshot().records["api.docker."]; ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the event to publish api.docker.")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+	if !d.loadSnapshot().connected {
+		t.Errorf("expected the plugin to be connected while watching the source")
+	}
+}
+
+func TestDockerSourceContainer(t *testing.T) {
+	ctx := context.Background()
+	s := newSyncTestDocker().newDockerSource(nil, nil)
+
+	inspect := runningContainer("web", "172.17.0.2", map[string]string{"a": "1"})
+	inspect.HostConfig.NetworkMode = "default"
+	inspect.NetworkSettings.Networks["bridge"].GlobalIPv6Address = "fd00::2"
+	inspect.NetworkSettings.Networks["bridge"].IPAMConfig = &network.EndpointIPAMConfig{LinkLocalIPs: []string{"169.254.0.2"}}
+	inspect.NetworkSettings.Networks["gone"] = nil
+	inspect.NetworkSettings.Ports = nat.PortMap{
+		"80/tcp":  {{HostIP: "0.0.0.0", HostPort: "8080"}},
+		"443/tcp": nil,
+	}
+
+	c := s.container(ctx, "c1", inspect)
+	if c.Name != "web" || c.Labels["a"] != "1" || !c.Running {
+		t.Errorf("expected name, labels and state to carry over, got %+v", c)
+	}
+	if c.PrimaryNetwork != "bridge" {
+		t.Errorf("expected the default network mode to mean bridge, got %q", c.PrimaryNetwork)
+	}
+	if _, ok := c.Networks["gone"]; ok || len(c.Networks) != 1 {
+		t.Errorf("expected endpoints without settings to be left out, got %v", c.Networks)
+	}
+	if n := c.Networks["bridge"]; n.GlobalIPv6Address != "fd00::2" || len(n.LinkLocalIPs) != 1 {
+		t.Errorf("expected the bridge endpoint's addresses, got %+v", n)
+	}
+	if b := c.Ports["80/tcp"]; len(b) != 1 || b[0].HostPort != "8080" {
+		t.Errorf("expected the published port binding, got %v", c.Ports)
+	}
+	if _, ok := c.Ports["443/tcp"]; !ok {
+		t.Errorf("expected exposed ports without bindings to be kept, got %v", c.Ports)
+	}
+
+	if c := s.container(ctx, "c2", container.InspectResponse{}); c.Networks != nil {
+		t.Errorf("expected an incomplete inspect response to publish no networks")
+	}
+}
+
+func TestDockerSourceRefs(t *testing.T) {
+	shared := runningSummary("c2", "app", "")
+	shared.HostConfig.NetworkMode = "container:c1"
+	containers := []container.Summary{runningSummary("c1", "web", "ep-1"), shared}
+
+	d := newSyncTestDocker()
+	refs := d.newDockerSource(nil, nil).refs(containers)
+	if refs[0].Name != "web" || refs[0].Fingerprint != summaryFingerprint(containers[0]) || refs[1].Fingerprint == 0 {
+		t.Errorf("expected refs named and fingerprinted from their summaries, got %+v", refs)
+	}
+
+	d.podman.Store(true)
+	refs = d.newDockerSource(nil, nil).refs(containers)
+	if refs[0].Fingerprint == 0 || refs[1].Fingerprint != 0 {
+		t.Errorf("expected only the container sharing a network namespace to skip the cache in Podman mode, got %+v", refs)
+	}
+}
+
+func TestDockerEvent(t *testing.T) {
+	ev, ok := dockerEvent(containerEvent(events.ActionDie, "c1"))
+	if !ok || ev.ID != "c1" || !ev.Remove {
+		t.Errorf("expected die to remove c1, got %+v", ev)
+	}
+	ev, ok = dockerEvent(networkEvent(events.ActionConnect, "net1", "c1"))
+	if !ok || ev.ID != "c1" || ev.Remove {
+		t.Errorf("expected connect to re-inspect c1, got %+v", ev)
+	}
+	if _, ok := dockerEvent(networkEvent(events.ActionDestroy, "net1", "")); ok {
+		t.Errorf("expected network events without a container to be dropped")
+	}
+}
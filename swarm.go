@@ -0,0 +1,298 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/miekg/dns"
+)
+
+// defaultSwarmInterval is how often swarm services and tasks are listed
+// when swarm is enabled without an interval. Task state changes have no
+// events, so this bounds how long a task's records lag behind it.
+const defaultSwarmInterval = 10 * time.Second
+
+// swarmClient is the part of the Docker client swarm mode uses. It is
+// only available on runtime docker.
+type swarmClient interface {
+	ServiceList(ctx context.Context, options swarm.ServiceListOptions) ([]swarm.Service, error)
+	TaskList(ctx context.Context, options swarm.TaskListOptions) ([]swarm.Task, error)
+	NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error)
+}
+
+// swarmEventFilter subscribes to service changes, which move VIPs, ports
+// and the set of tasks.
+func swarmEventFilter() filters.Args {
+	return filters.NewArgs(filters.Arg("type", string(events.ServiceEventType)))
+}
+
+// startSwarmLoop publishes records for the swarm's services and tasks
+// until ctx is cancelled. It relists them every swarm interval and
+// whenever a service event arrives. The daemon must be a swarm manager;
+// on any other node listing fails and no swarm records are published.
+func (d *Docker) startSwarmLoop(ctx context.Context) {
+	cli, ok := d.client.(swarmClient)
+	if !ok {
+		log.Warningf("swarm requires runtime docker, not publishing swarm records for %s", d.endpointName())
+		return
+	}
+	log.Infof("Starting swarm loop, relisting services every %s", d.swarmInterval)
+	ticker := time.NewTicker(d.swarmInterval)
+	defer ticker.Stop()
+
+	var msgs <-chan events.Message
+	var errs <-chan error
+	cancelEvents := func() {}
+	defer func() { cancelEvents() }()
+	subscribe := func() {
+		var evCtx context.Context
+		evCtx, cancelEvents = context.WithCancel(ctx)
+		msgs, errs = d.client.Events(evCtx, events.ListOptions{Filters: swarmEventFilter()})
+	}
+
+	subscribe()
+	d.syncSwarm(ctx, cli)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Resubscribe after an event stream error; until then the
+			// ticker alone keeps records current.
+			if msgs == nil {
+				subscribe()
+			}
+			d.syncSwarm(ctx, cli)
+		case msg := <-msgs:
+			log.Debugf("Docker swarm event: %s %s %s", msg.Type, msg.Action, msg.Actor.ID)
+			d.syncSwarm(ctx, cli)
+		case err := <-errs:
+			if err != nil && err != context.Canceled {
+				log.Debugf("Docker swarm event error: %v", err)
+			}
+			cancelEvents()
+			msgs, errs = nil, nil
+		}
+	}
+}
+
+// syncSwarm lists the swarm's services, running tasks and networks and
+// publishes the records they produce. On error the previous swarm records
+// are kept.
+func (d *Docker) syncSwarm(ctx context.Context, cli swarmClient) {
+	services, tasks, networks, err := listSwarm(ctx, d, cli)
+	if err != nil {
+		// A worker or a node outside any swarm fails the same way on
+		// every relist, so only a new error is worth a warning.
+		if msg := err.Error(); msg != d.swarmErr {
+			log.Warningf("Failed to list swarm services: %v", err)
+			d.swarmErr = msg
+		} else {
+			log.Debugf("Failed to list swarm services: %v", err)
+		}
+		return
+	}
+	d.swarmErr = ""
+	log.Debugf("Found %d swarm services with %d running tasks", len(services), len(tasks))
+	rs := swarmRecords(services, tasks, networks, d.sourceInput(nil, nil))
+
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+	if reflect.DeepEqual(d.swarmSet, rs) {
+		return
+	}
+	d.swarmSet = rs
+	// Until the first container sync, the swarm records wait to be
+	// published along with it.
+	if d.containerSets != nil {
+		d.publishLocked()
+	}
+}
+
+// listSwarm lists services, the tasks that should be running, and the
+// swarm-scoped networks.
+func listSwarm(ctx context.Context, d *Docker, cli swarmClient) ([]swarm.Service, []swarm.Task, []network.Summary, error) {
+	listCtx, cancel := d.apiContext(ctx)
+	defer cancel()
+	services, err := cli.ServiceList(listCtx, swarm.ServiceListOptions{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tasks, err := cli.TaskList(listCtx, swarm.TaskListOptions{
+		Filters: filters.NewArgs(filters.Arg("desired-state", string(swarm.TaskStateRunning))),
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	networks, err := cli.NetworkList(listCtx, network.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("scope", "swarm")),
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return services, tasks, networks, nil
+}
+
+// swarmRecords generates the records for swarm services and their running
+// tasks:
+//
+//   - <service>.<zone> resolves to the service's virtual IP on each overlay
+//     network, or to its task IPs for endpoint mode dnsrr.
+//   - tasks.<service>.<zone> resolves to every running task's IPs.
+//   - <service>.<slot>.<zone> resolves to one task's IPs; global services
+//     have no slots and use the task's node ID instead.
+//   - each published port gives an SRV record _proto._proto.<service>.<zone>
+//     for its target port, which the VIP forwards.
+//
+// The ingress network is skipped, as Docker's own DNS does, and the
+// networks option filters the rest by name.
+func swarmRecords(services []swarm.Service, tasks []swarm.Task, networks []network.Summary, input GenerateRecordsInput) *recordSet {
+	networkNames := make(map[string]string, len(networks))
+	ingress := make(map[string]bool)
+	for _, n := range networks {
+		networkNames[n.ID] = n.Name
+		if n.Ingress {
+			ingress[n.ID] = true
+		}
+	}
+	allowed := func(id, name string) bool {
+		if ingress[id] {
+			return false
+		}
+		if len(input.Networks) == 0 {
+			return true
+		}
+		if name == "" {
+			name = networkNames[id]
+		}
+		return slices.Contains(input.Networks, name)
+	}
+
+	tasksByService := make(map[string][]swarm.Task)
+	for _, t := range tasks {
+		if t.Status.State != swarm.TaskStateRunning {
+			continue
+		}
+		tasksByService[t.ServiceID] = append(tasksByService[t.ServiceID], t)
+	}
+
+	sort.Slice(services, func(i, j int) bool { return services[i].Spec.Name < services[j].Spec.Name })
+
+	rs := newRecordSet()
+	for _, svc := range services {
+		name := svc.Spec.Name
+		if name == "" {
+			continue
+		}
+		serviceTasks := tasksByService[svc.ID]
+		sort.Slice(serviceTasks, func(i, j int) bool {
+			return swarmTaskName(serviceTasks[i]) < swarmTaskName(serviceTasks[j])
+		})
+
+		var vips []net.IP
+		for _, vip := range svc.Endpoint.VirtualIPs {
+			if !allowed(vip.NetworkID, "") {
+				continue
+			}
+			if ip, _, err := net.ParseCIDR(vip.Addr); err == nil {
+				vips = append(vips, ip)
+			}
+		}
+
+		var allTaskIPs []net.IP
+		for _, t := range serviceTasks {
+			var ips []net.IP
+			for _, att := range t.NetworksAttachments {
+				if att.Network.Spec.Ingress || !allowed(att.Network.ID, att.Network.Spec.Name) {
+					continue
+				}
+				for _, addr := range att.Addresses {
+					if ip, _, err := net.ParseCIDR(addr); err == nil {
+						ips = append(ips, ip)
+					}
+				}
+			}
+			if len(ips) == 0 {
+				continue
+			}
+			allTaskIPs = append(allTaskIPs, ips...)
+			taskName := name + "." + swarmTaskName(t)
+			for _, zone := range input.Zones {
+				rs.addSwarmName(swarmFQDN(taskName, zone), ips, true)
+			}
+		}
+
+		useVIP := svc.Endpoint.Spec.Mode != swarm.ResolutionModeDNSRR && len(vips) > 0
+		serviceIPs := vips
+		if !useVIP {
+			serviceIPs = allTaskIPs
+		}
+		for _, zone := range input.Zones {
+			fqdn := swarmFQDN(name, zone)
+			rs.addSwarmName(fqdn, serviceIPs, useVIP)
+			rs.addSwarmName(swarmFQDN("tasks."+name, zone), allTaskIPs, false)
+			if len(serviceIPs) == 0 {
+				continue
+			}
+			for _, p := range svc.Endpoint.Ports {
+				if p.TargetPort < 1 || p.TargetPort > 65535 {
+					continue
+				}
+				proto := strings.ToLower(string(p.Protocol))
+				if proto == "" {
+					proto = "tcp"
+				}
+				srvName := "_" + proto + "._" + proto + "." + fqdn
+				srv := srvRecord{target: fqdn, port: uint16(p.TargetPort)}
+				if !slices.Contains(rs.srvs[srvName], srv) {
+					rs.srvs[srvName] = append(rs.srvs[srvName], srv)
+				}
+			}
+		}
+	}
+	return rs
+}
+
+// addSwarmName publishes ips under fqdn, with PTR records pointing back
+// to it when ptr is set.
+func (rs *recordSet) addSwarmName(fqdn string, ips []net.IP, ptr bool) {
+	for _, ip := range ips {
+		if !slices.ContainsFunc(rs.records[fqdn], ip.Equal) {
+			rs.records[fqdn] = append(rs.records[fqdn], ip)
+		}
+		if !ptr {
+			continue
+		}
+		if arpa, err := dns.ReverseAddr(ip.String()); err == nil && !slices.Contains(rs.ptrs[arpa], fqdn) {
+			rs.ptrs[arpa] = append(rs.ptrs[arpa], fqdn)
+		}
+	}
+}
+
+// swarmTaskName returns the label that tells a service's tasks apart: the
+// slot for replicated services, or the node ID for global ones.
+func swarmTaskName(t swarm.Task) string {
+	if t.Slot > 0 {
+		return strconv.Itoa(t.Slot)
+	}
+	return t.NodeID
+}
+
+// swarmFQDN returns name under zone, lowercased.
+func swarmFQDN(name, zone string) string {
+	fqdn := strings.ToLower(name + "." + zone)
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	return fqdn
+}
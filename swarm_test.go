@@ -0,0 +1,223 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// fakeSwarm is a swarmClient that returns fixed services, tasks and
+// networks, or err.
+type fakeSwarm struct {
+	services []swarm.Service
+	tasks    []swarm.Task
+	networks []network.Summary
+	err      error
+}
+
+func (f *fakeSwarm) ServiceList(ctx context.Context, options swarm.ServiceListOptions) ([]swarm.Service, error) {
+	return f.services, f.err
+}
+
+func (f *fakeSwarm) TaskList(ctx context.Context, options swarm.TaskListOptions) ([]swarm.Task, error) {
+	return f.tasks, f.err
+}
+
+func (f *fakeSwarm) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+	return f.networks, f.err
+}
+
+func swarmService(id, name string, mode swarm.ResolutionMode, vips ...swarm.EndpointVirtualIP) swarm.Service {
+	svc := swarm.Service{ID: id}
+	svc.Spec.Name = name
+	svc.Endpoint.Spec.Mode = mode
+	svc.Endpoint.VirtualIPs = vips
+	return svc
+}
+
+func swarmTask(serviceID string, slot int, nodeID, networkID, networkName, addr string) swarm.Task {
+	t := swarm.Task{ServiceID: serviceID, Slot: slot, NodeID: nodeID}
+	t.Status.State = swarm.TaskStateRunning
+	att := swarm.NetworkAttachment{Addresses: []string{addr}}
+	att.Network.ID = networkID
+	att.Network.Spec.Name = networkName
+	t.NetworksAttachments = []swarm.NetworkAttachment{att}
+	return t
+}
+
+func swarmFixture() *fakeSwarm {
+	web := swarmService("s1", "web", swarm.ResolutionModeVIP,
+		swarm.EndpointVirtualIP{NetworkID: "ingress", Addr: "10.0.0.2/24"},
+		swarm.EndpointVirtualIP{NetworkID: "n1", Addr: "10.0.1.2/24"},
+	)
+	web.Endpoint.Ports = []swarm.PortConfig{{Protocol: swarm.PortConfigProtocolTCP, TargetPort: 80, PublishedPort: 8080}}
+	stopped := swarmTask("s1", 3, "node3", "n1", "app", "10.0.1.9/24")
+	stopped.Status.State = swarm.TaskStateShutdown
+	return &fakeSwarm{
+		services: []swarm.Service{
+			web,
+			swarmService("s2", "db", swarm.ResolutionModeDNSRR),
+			swarmService("s3", "agent", swarm.ResolutionModeVIP,
+				swarm.EndpointVirtualIP{NetworkID: "n2", Addr: "10.0.2.2/24"},
+			),
+		},
+		tasks: []swarm.Task{
+			swarmTask("s1", 2, "node2", "n1", "app", "10.0.1.4/24"),
+			swarmTask("s1", 1, "node1", "n1", "app", "10.0.1.3/24"),
+			stopped,
+			swarmTask("s2", 1, "node1", "n1", "app", "10.0.1.5/24"),
+			swarmTask("s3", 0, "node1", "n2", "monitoring", "10.0.2.3/24"),
+		},
+		networks: []network.Summary{
+			{ID: "ingress", Name: "ingress", Ingress: true},
+			{ID: "n1", Name: "app"},
+			{ID: "n2", Name: "monitoring"},
+		},
+	}
+}
+
+func TestSwarmRecords(t *testing.T) {
+	f := swarmFixture()
+	rs := swarmRecords(f.services, f.tasks, f.networks, newSyncTestDocker().sourceInput(nil, nil))
+
+	assertIPs := func(fqdn string, want ...string) {
+		t.Helper()
+		var got []string
+		for _, ip := range rs.records[fqdn] {
+			got = append(got, ip.String())
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("expected %v for %s, got %v", want, fqdn, got)
+		}
+	}
+	// The ingress VIP is left out, as Docker's DNS does.
+	assertIPs("web.docker.", "10.0.1.2")
+	assertIPs("tasks.web.docker.", "10.0.1.3", "10.0.1.4")
+	assertIPs("web.1.docker.", "10.0.1.3")
+	assertIPs("web.2.docker.", "10.0.1.4")
+	assertIPs("web.3.docker.")
+	assertIPs("db.docker.", "10.0.1.5")
+	assertIPs("tasks.db.docker.", "10.0.1.5")
+	assertIPs("agent.node1.docker.", "10.0.2.3")
+
+	if got := rs.ptrs[mustReverseAddr("10.0.1.2")]; !slices.Equal(got, []string{"web.docker."}) {
+		t.Errorf("expected the VIP to point back to the service, got %v", got)
+	}
+	if got := rs.ptrs[mustReverseAddr("10.0.1.3")]; !slices.Equal(got, []string{"web.1.docker."}) {
+		t.Errorf("expected a task IP to point back to its task, got %v", got)
+	}
+	if got := rs.ptrs[mustReverseAddr("10.0.1.5")]; !slices.Equal(got, []string{"db.1.docker."}) {
+		t.Errorf("expected a dnsrr task IP to point back to its task only, got %v", got)
+	}
+	if got := rs.srvs["_tcp._tcp.web.docker."]; len(got) != 1 || got[0] != (srvRecord{target: "web.docker.", port: 80}) {
+		t.Errorf("expected an SRV record for the target port, got %v", got)
+	}
+}
+
+func TestSwarmRecordsNetworksFilter(t *testing.T) {
+	f := swarmFixture()
+	d := newSyncTestDocker()
+	d.networks = []string{"monitoring"}
+	rs := swarmRecords(f.services, f.tasks, f.networks, d.sourceInput(nil, nil))
+
+	if _, ok := rs.records["web.docker."]; ok {
+		t.Errorf("expected services outside the networks filter to be left out, got %v", rs.records["web.docker."])
+	}
+	if got := rs.records["agent.docker."]; len(got) != 1 || got[0].String() != "10.0.2.2" {
+		t.Errorf("expected the service on an allowed network, got %v", got)
+	}
+}
+
+func TestSyncSwarm(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	d.source = newFixtureSource(fixtureContainer("c1", "web-1", "172.17.0.2"))
+	f := swarmFixture()
+
+	// Swarm records wait for the first container sync.
+	d.syncSwarm(ctx, f)
+	if _, ok := d.loadSnapshot().records["web.docker."]; ok {
+		t.Fatalf("expected nothing published before the first container sync")
+	}
+	if _, err := d.fullSync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertRecordIPs(t, d, "web.docker.", "10.0.1.2")
+	assertRecordIPs(t, d, "web-1.docker.", "172.17.0.2")
+
+	f.services = slices.DeleteFunc(f.services, func(svc swarm.Service) bool { return svc.Spec.Name == "web" })
+	d.syncSwarm(ctx, f)
+	assertRecordIPs(t, d, "web.docker.")
+	assertRecordIPs(t, d, "db.docker.", "10.0.1.5")
+
+	// A failed listing keeps the last swarm records.
+	f.err = errors.New("This node is not a swarm manager.")
+	d.syncSwarm(ctx, f)
+	assertRecordIPs(t, d, "db.docker.", "10.0.1.5")
+	if d.swarmErr == "" {
+		t.Errorf("expected the listing error to be remembered")
+	}
+	f.err = nil
+	d.syncSwarm(ctx, f)
+	if d.swarmErr != "" {
+		t.Errorf("expected a successful listing to clear the error, got %q", d.swarmErr)
+	}
+	assertRecordIPs(t, d, "web-1.docker.", "172.17.0.2")
+}
+
+func TestParseSwarm(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		want  string
+	}{
+		"default":  {"docker {\nswarm\n}", "10s"},
+		"interval": {"docker {\nswarm 30s\n}", "30s"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := &Docker{}
+			if err := parse(caddy.NewTestController("dns", tt.input), d); err != nil {
+				t.Fatal(err)
+			}
+			if d.swarmInterval.String() != tt.want {
+				t.Errorf("expected swarm interval %s, got %s", tt.want, d.swarmInterval)
+			}
+		})
+	}
+
+	d := &Docker{}
+	if err := parse(caddy.NewTestController("dns", `docker {
+		swarm 1m
+		endpoint a unix:///a.sock
+		endpoint b unix:///b.sock
+	}`), d); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range d.endpoints {
+		if e.swarmInterval != d.swarmInterval {
+			t.Errorf("expected endpoint %s to inherit the swarm interval, got %s", e.name, e.swarmInterval)
+		}
+	}
+}
+
+func TestParseSwarmErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid":            "docker {\nswarm soon\n}",
+		"zero":               "docker {\nswarm 0s\n}",
+		"too many arguments": "docker {\nswarm 10s 20s\n}",
+		"containerd":         "docker {\nswarm\nruntime containerd\n}",
+		"file source":        "docker {\nswarm\nsource file " + t.TempDir() + "\n}",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := parse(caddy.NewTestController("dns", input), &Docker{}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,453 @@
+package docker
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+)
+
+// generateInput returns the GenerateRecordsInput for the plugin's
+// configuration and the given Docker containers.
+func (d *Docker) generateInput(containers []container.Summary, inspector ContainerInspector) GenerateRecordsInput {
+	src := d.newDockerSource(nil, inspector)
+	return d.sourceInput(src, src.refs(containers))
+}
+
+// sourceInput returns the GenerateRecordsInput for the plugin's
+// configuration and the given containers from src.
+func (d *Docker) sourceInput(src ContainerSource, refs []ContainerRef) GenerateRecordsInput {
+	return GenerateRecordsInput{
+		Source:        src,
+		Refs:          refs,
+		Zones:         d.zones,
+		LabelPrefix:   d.labelPrefix,
+		Networks:      d.networks,
+		HostMode:      d.hostMode,
+		HostModePTR:   d.hostModePTR,
+		NameTemplates: d.nameTemplates,
+		LinkLocal:     d.linkLocal,
+		Concurrency:   d.inspectConcurrency,
+		Cache:         d.inspectCache,
+	}
+}
+
+// syncRecords lists every running container, inspects each one, and
+// replaces the record tables wholesale. It runs whenever the event loop
+// (re)connects to the Docker daemon and on every resync_interval tick.
+func (d *Docker) syncRecords(ctx context.Context) error {
+	_, err := d.fullSync(ctx)
+	return err
+}
+
+// fullSync does the work of syncRecords and reports how many containers'
+// records differ from what was tracked before the sync: containers that
+// appeared, disappeared, or whose records changed.
+func (d *Docker) fullSync(ctx context.Context) (int, error) {
+	syncStart := time.Now()
+	src := d.containerSource()
+	// List under syncMu, so an event applied between the listing and the
+	// replace cannot be undone by the older list.
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+	refs, err := src.List(ctx)
+	if err != nil {
+		log.Errorf("Failed to list containers: %v", err)
+		syncErrorCount.Inc()
+		return 0, err
+	}
+	log.Debugf("Found %d running containers", len(refs))
+
+	drift := d.replaceRefsLocked(ctx, src, refs)
+	syncDuration.Observe(time.Since(syncStart).Seconds())
+	return drift, nil
+}
+
+// replaceContainers inspects the given Docker containers, replaces the
+// tracked record sets with theirs, publishes the result, and returns the
+// drift.
+func (d *Docker) replaceContainers(ctx context.Context, containers []container.Summary, inspector ContainerInspector) int {
+	src := d.newDockerSource(nil, inspector)
+	return d.replaceRefs(ctx, src, src.refs(containers))
+}
+
+// replaceRefs inspects the given containers from src, replaces the
+// tracked record sets with theirs, publishes the result, and returns the
+// drift. Containers whose inspect fails keep their previous records for
+// up to inspect_grace.
+func (d *Docker) replaceRefs(ctx context.Context, src ContainerSource, refs []ContainerRef) int {
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+	return d.replaceRefsLocked(ctx, src, refs)
+}
+
+// replaceRefsLocked does the work of replaceRefs. The caller must hold
+// d.syncMu.
+func (d *Docker) replaceRefsLocked(ctx context.Context, src ContainerSource, refs []ContainerRef) int {
+	_, sets := generateRecordSets(ctx, d.sourceInput(src, refs))
+
+	// Rebuild the order from the container list so containers whose
+	// inspect failed keep their place when their records are carried
+	// forward.
+	now := time.Now()
+	order := make([]string, 0, len(refs))
+	listed := make(map[string]struct{}, len(refs))
+	for _, ref := range refs {
+		listed[ref.ID] = struct{}{}
+		if _, ok := sets[ref.ID]; ok {
+			delete(d.inspectFailures, ref.ID)
+			order = append(order, ref.ID)
+			continue
+		}
+		if !d.inspectFailedLocked(ref.ID, ref.Name, now) {
+			continue
+		}
+		sets[ref.ID] = d.containerSets[ref.ID]
+		order = append(order, ref.ID)
+	}
+	for id := range d.inspectFailures {
+		if _, ok := listed[id]; !ok {
+			delete(d.inspectFailures, id)
+		}
+	}
+
+	drift := countDrift(d.containerSets, sets)
+	d.containerOrder, d.containerSets = order, sets
+	d.publishLocked()
+	return drift
+}
+
+// countDrift returns the number of containers whose record sets differ
+// between before and after, counting containers present in only one of
+// them.
+func countDrift(before, after map[string]*recordSet) int {
+	drift := 0
+	for id, rs := range after {
+		prev, ok := before[id]
+		if !ok || !reflect.DeepEqual(prev, rs) {
+			drift++
+		}
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			drift++
+		}
+	}
+	return drift
+}
+
+// startResyncLoop runs a full sync every resync_interval until ctx is
+// cancelled. Events keep the tables current between ticks; the periodic
+// sync heals anything a dropped event left behind and counts the
+// containers it had to correct in resyncDriftCount. Ticks are skipped
+// while the daemon is disconnected, since the event loop runs a full
+// sync as soon as it reconnects.
+func (d *Docker) startResyncLoop(ctx context.Context) {
+	log.Infof("Starting periodic resync every %s", d.resyncInterval)
+	ticker := time.NewTicker(d.resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !d.loadSnapshot().connected {
+				log.Debugf("Skipping periodic resync while disconnected from Docker daemon")
+				continue
+			}
+			drift, err := d.fullSync(ctx)
+			if err != nil {
+				continue
+			}
+			if drift > 0 {
+				log.Warningf("Periodic resync corrected records for %d container(s); Docker events may have been missed", drift)
+				resyncDriftCount.Add(float64(drift))
+			} else {
+				log.Debugf("Periodic resync found no drift")
+			}
+		}
+	}
+}
+
+// handleEvent applies a single Docker event to the record tables.
+func (d *Docker) handleEvent(ctx context.Context, inspector ContainerInspector, msg events.Message) {
+	d.applyEvents(ctx, inspector, []events.Message{msg})
+}
+
+// eventContainerID returns the ID of the container an event affects, or
+// "" for events that do not affect a single container. Network
+// connect/disconnect events carry the network as their actor and name the
+// container in the "container" attribute.
+func eventContainerID(msg events.Message) string {
+	if msg.Type == events.NetworkEventType {
+		if msg.Action != events.ActionConnect && msg.Action != events.ActionDisconnect {
+			return ""
+		}
+		return msg.Actor.Attributes["container"]
+	}
+	return msg.Actor.ID
+}
+
+// removesContainer reports whether an event means the container's records
+// should be dropped without inspecting it: it stopped, was removed, or was
+// paused and can no longer answer traffic.
+func removesContainer(msg events.Message) bool {
+	if msg.Type == events.NetworkEventType {
+		return false
+	}
+	switch msg.Action {
+	case events.ActionDie, events.ActionStop, events.ActionDestroy, events.ActionPause:
+		return true
+	}
+	return false
+}
+
+// applyEvents applies a batch of Docker events to the record tables.
+func (d *Docker) applyEvents(ctx context.Context, inspector ContainerInspector, msgs []events.Message) {
+	var evs []ContainerEvent
+	for _, msg := range msgs {
+		if ev, ok := dockerEvent(msg); ok {
+			evs = append(evs, ev)
+		}
+	}
+	d.applyContainerEvents(ctx, d.newDockerSource(nil, inspector), evs)
+}
+
+// applyContainerEvents applies a batch of container events to the record
+// tables and publishes the result once. Only the containers named in the
+// events are touched, and only the latest event per container matters:
+// containers that stopped or were paused are dropped without a round
+// trip to the source, and anything else (start, rename, unpause,
+// health_status, network connect/disconnect, ...) is re-inspected so its
+// records reflect its current names and addresses.
+func (d *Docker) applyContainerEvents(ctx context.Context, src ContainerSource, evs []ContainerEvent) {
+	syncStart := time.Now()
+
+	latest := make(map[string]ContainerEvent, len(evs))
+	var ids []string
+	for _, ev := range evs {
+		if ev.ID == "" {
+			continue
+		}
+		if _, ok := latest[ev.ID]; !ok {
+			ids = append(ids, ev.ID)
+		}
+		latest[ev.ID] = ev
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	type containerUpdate struct {
+		id      string
+		rs      *recordSet
+		running bool
+		failed  bool
+	}
+	updates := make([]containerUpdate, 0, len(ids))
+	input := d.sourceInput(src, nil)
+	for _, id := range ids {
+		// The event may have changed the container in ways its
+		// fingerprint does not show, so the next full sync re-inspects it.
+		d.inspectCache.invalidate(id)
+		if latest[id].Remove {
+			updates = append(updates, containerUpdate{id: id})
+			continue
+		}
+
+		c, err := src.Inspect(ctx, id)
+		if err != nil && !cerrdefs.IsNotFound(err) {
+			log.Errorf("Failed to inspect container %s: %v", id, err)
+			syncErrorCount.Inc()
+			updates = append(updates, containerUpdate{id: id, failed: true})
+			continue
+		}
+		if err != nil || !c.Running {
+			updates = append(updates, containerUpdate{id: id})
+			continue
+		}
+		updates = append(updates, containerUpdate{id: id, rs: containerRecords(c, input), running: true})
+	}
+
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+
+	changed := false
+	now := time.Now()
+	for _, u := range updates {
+		_, tracked := d.containerSets[u.id]
+		if u.failed {
+			if d.inspectFailedLocked(u.id, latest[u.id].Name, now) || !tracked {
+				continue
+			}
+			d.deleteContainerLocked(u.id)
+			changed = true
+			continue
+		}
+		delete(d.inspectFailures, u.id)
+		if !u.running {
+			if !tracked {
+				log.Debugf("Container %s is not running and not tracked, nothing to do", u.id)
+				continue
+			}
+			log.Debugf("Container %s is not running, removing its records", u.id)
+			d.deleteContainerLocked(u.id)
+			changed = true
+			continue
+		}
+		if d.containerSets == nil {
+			d.containerSets = make(map[string]*recordSet)
+		}
+		if !tracked {
+			d.containerOrder = append(d.containerOrder, u.id)
+		}
+		d.containerSets[u.id] = u.rs
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	d.publishLocked()
+	syncDuration.Observe(time.Since(syncStart).Seconds())
+}
+
+// inspectFailedLocked records a failed inspect for a container and
+// reports whether its previous records should be carried forward: it must
+// be tracked, and its first failure in the current run of failures must
+// be less than inspect_grace ago. Once the grace period runs out, the
+// container is dropped until an inspect succeeds again, without waiting
+// for the next sync or event. The caller must hold d.syncMu.
+func (d *Docker) inspectFailedLocked(id, name string, now time.Time) bool {
+	inspectFailureCount.Inc()
+	if d.inspectFailures == nil {
+		d.inspectFailures = make(map[string]time.Time)
+	}
+	_, tracked := d.containerSets[id]
+	since, ok := d.inspectFailures[id]
+	if !ok {
+		since = now
+		d.inspectFailures[id] = now
+		if tracked && d.inspectGrace > 0 {
+			time.AfterFunc(d.inspectGrace, func() { d.expireInspectFailure(id, name, since) })
+		}
+	}
+	if !tracked {
+		return false
+	}
+	if now.Sub(since) >= d.inspectGrace {
+		log.Warningf("Inspect of container %s has been failing since %s, dropping its records", name, since.Format(time.RFC3339))
+		return false
+	}
+	log.Warningf("Inspect of container %s failed, keeping its previous records", name)
+	return true
+}
+
+// expireInspectFailure drops a container whose inspect has been failing
+// since since, once inspect_grace has run out. It does nothing if an
+// inspect succeeded, or the container went away, in the meantime.
+func (d *Docker) expireInspectFailure(id, name string, since time.Time) {
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+	if failing, ok := d.inspectFailures[id]; !ok || !failing.Equal(since) {
+		return
+	}
+	if _, tracked := d.containerSets[id]; !tracked {
+		return
+	}
+	log.Warningf("Inspect of container %s has been failing since %s, dropping its records", name, since.Format(time.RFC3339))
+	d.deleteContainerLocked(id)
+	d.publishLocked()
+}
+
+// summaryName returns a container's name from its list summary, falling
+// back to its short ID.
+func summaryName(c container.Summary) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return shortID(c.ID)
+}
+
+// eventContainerName returns the name of the container an event affects,
+// falling back to its short ID. Network events only carry the ID.
+func eventContainerName(msg events.Message) string {
+	if msg.Type == events.ContainerEventType {
+		if name := msg.Actor.Attributes["name"]; name != "" {
+			return name
+		}
+	}
+	return shortID(eventContainerID(msg))
+}
+
+// shortID truncates a container ID the way the Docker CLI displays it.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// deleteContainerLocked forgets a container's record set. The caller
+// must hold d.syncMu.
+func (d *Docker) deleteContainerLocked(id string) {
+	delete(d.containerSets, id)
+	delete(d.inspectFailures, id)
+	d.containerOrder = slices.DeleteFunc(d.containerOrder, func(existing string) bool {
+		return existing == id
+	})
+}
+
+// publishLocked merges the per-container record sets and publishes the
+// result in a new snapshot for ServeDNS. The caller must hold d.syncMu.
+func (d *Docker) publishLocked() {
+	merged := mergeRecordSets(d.containerOrder, d.containerSets)
+	merged.merge(d.swarmSet)
+	now := time.Now()
+
+	d.recordEndpointSync(now)
+
+	// A named endpoint's snapshot only feeds the aggregated one, which
+	// its parent builds the answers for.
+	if d.parent != nil {
+		d.updateSnapshot(func(s *snapshot) {
+			s.recordSet = *merged
+			s.lastSyncTime = now
+			s.containers = len(d.containerOrder)
+		})
+		log.Debugf("Synced %d records for endpoint %s", len(merged.records), d.name)
+		d.parent.publishEndpoints()
+		return
+	}
+
+	var prev, next *answerTable
+	d.updateSnapshot(func(s *snapshot) {
+		prev = s.answers
+		next = d.buildAnswers(merged)
+		s.recordSet = *merged
+		s.answers = next
+		s.lastSyncTime = now
+		s.containers = len(d.containerOrder)
+	})
+	d.notifyChanges(prev, next)
+	d.persistLocked(merged, now)
+	lastSyncTimestamp.Set(float64(now.Unix()))
+	d.recordGauges(merged, len(d.containerOrder))
+}
+
+// recordGauges updates the record and container gauges for the records
+// being served.
+func (d *Docker) recordGauges(merged *recordSet, containers int) {
+	recordsCount.Set(float64(len(merged.records)))
+	srvRecordsCount.Set(float64(len(merged.srvs)))
+	ptrRecordsCount.Set(float64(len(merged.ptrs)))
+	cnameRecordsCount.Set(float64(len(merged.cnames)))
+	txtRecordsCount.Set(float64(len(merged.txts)))
+	containersCount.Set(float64(containers))
+	log.Debugf("Synced %d records, %d SRV records, %d PTR records, %d CNAME records, and %d TXT records", len(merged.records), len(merged.srvs), len(merged.ptrs), len(merged.cnames), len(merged.txts))
+}
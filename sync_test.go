@@ -0,0 +1,584 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// runningContainer returns a minimal inspect response for a running
+// container attached to the default bridge network.
+func runningContainer(name, ip string, labels map[string]string) container.InspectResponse {
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			Name:  "/" + name,
+			State: &container.State{Running: true},
+			HostConfig: &container.HostConfig{
+				NetworkMode: container.NetworkMode("bridge"),
+			},
+		},
+		Config: &container.Config{Labels: labels},
+		NetworkSettings: &container.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"bridge": {IPAddress: ip},
+			},
+		},
+	}
+}
+
+func newSyncTestDocker() *Docker {
+	return &Docker{
+		ttl:          DefaultTTL,
+		zones:        []string{"docker."},
+		labelPrefix:  "com.dokku.coredns-docker",
+		inspectGrace: defaultInspectGrace,
+	}
+}
+
+func containerEvent(action events.Action, id string) events.Message {
+	return events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor:  events.Actor{ID: id},
+	}
+}
+
+func assertRecordIPs(t *testing.T, d *Docker, fqdn string, want ...string) {
+	t.Helper()
+	got, ok := d.loadSnapshot().records[fqdn]
+	if len(want) == 0 {
+		if ok {
+			t.Errorf("expected no records for %s, got %v", fqdn, got)
+		}
+		return
+	}
+	if !ok {
+		t.Fatalf("expected records for %s, none found", fqdn)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d IPs for %s, got %v", len(want), fqdn, got)
+	}
+	for i, w := range want {
+		if !got[i].Equal(net.ParseIP(w)) {
+			t.Errorf("expected IP %s for %s at index %d, got %s", w, fqdn, i, got[i])
+		}
+	}
+}
+
+func TestHandleEventIncrementalSync(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web1", "172.17.0.2", map[string]string{"com.dokku.coredns-docker/hostname": "web"}),
+			"c2": runningContainer("web2", "172.17.0.3", map[string]string{"com.dokku.coredns-docker/hostname": "web"}),
+		},
+	}
+
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+	assertRecordIPs(t, d, "web1.docker.", "172.17.0.2")
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c2"))
+	assertRecordIPs(t, d, "web2.docker.", "172.17.0.3")
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2", "172.17.0.3")
+
+	// A restart with a new IP replaces only that container's contribution.
+	inspector.inspections["c1"] = runningContainer("web1", "172.17.0.9", map[string]string{"com.dokku.coredns-docker/hostname": "web"})
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionRestart, "c1"))
+	assertRecordIPs(t, d, "web1.docker.", "172.17.0.9")
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.9", "172.17.0.3")
+	_, stalePTR := d.loadSnapshot().ptrs[mustReverseAddr("172.17.0.2")]
+	if stalePTR {
+		t.Errorf("expected PTR for the old IP to be removed after restart")
+	}
+
+	// die removes the container without inspecting it.
+	inspector.errors = map[string]error{"c2": errInspectFailed}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionDie, "c2"))
+	assertRecordIPs(t, d, "web2.docker.")
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.9")
+
+	if len(d.containerOrder) != 1 || d.containerOrder[0] != "c1" {
+		t.Errorf("expected only c1 to be tracked, got %v", d.containerOrder)
+	}
+}
+
+func TestHandleEventRemovesMissingOrStoppedContainers(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web1", "172.17.0.2", map[string]string{}),
+			"c2": runningContainer("web2", "172.17.0.3", map[string]string{}),
+		},
+	}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c2"))
+
+	// A container that is gone by the time it is inspected is removed.
+	inspector.errors = map[string]error{"c1": cerrdefs.ErrNotFound}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+	assertRecordIPs(t, d, "web1.docker.")
+
+	// A container that is no longer running is removed.
+	stopped := runningContainer("web2", "172.17.0.3", map[string]string{})
+	stopped.State.Running = false
+	inspector.inspections["c2"] = stopped
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionCreate, "c2"))
+	assertRecordIPs(t, d, "web2.docker.")
+
+	if len(d.containerOrder) != 0 {
+		t.Errorf("expected no tracked containers, got %v", d.containerOrder)
+	}
+}
+
+func TestHandleEventInspectErrorKeepsRecords(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web1", "172.17.0.2", map[string]string{}),
+		},
+	}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+
+	inspector.errors = map[string]error{"c1": errInspectFailed}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+	assertRecordIPs(t, d, "web1.docker.", "172.17.0.2")
+}
+
+func TestHandleEventIgnoresEventsWithoutActor(t *testing.T) {
+	d := newSyncTestDocker()
+	d.handleEvent(context.Background(), &mockContainerInspector{}, containerEvent(events.ActionStart, ""))
+	if d.containerSets != nil || !d.loadSnapshot().lastSyncTime.IsZero() {
+		t.Errorf("expected an event without an actor ID to be ignored")
+	}
+}
+
+func TestMergeRecordSetsMatchesGenerateRecords(t *testing.T) {
+	input := GenerateRecordsInput{
+		Inspector: &mockContainerInspector{
+			inspections: map[string]container.InspectResponse{
+				"c1": runningContainer("web1", "172.17.0.2", map[string]string{
+					"com.dokku.coredns-docker/hostname":       "web",
+					"com.dokku.coredns-docker/srv._tcp._http": "80",
+					"com.dokku.coredns-docker/txt":            "v=1",
+				}),
+				"c2": runningContainer("web2", "172.17.0.3", map[string]string{
+					"com.dokku.coredns-docker/hostname": "web",
+					"com.dokku.coredns-docker/txt":      "v=1",
+				}),
+			},
+		},
+		Containers:  []container.Summary{{ID: "c1"}, {ID: "c2"}},
+		Zones:       []string{"docker."},
+		LabelPrefix: "com.dokku.coredns-docker",
+	}
+
+	records, srvs, ptrs, _, txts := generateRecords(context.Background(), input)
+	if len(records["web.docker."]) != 2 {
+		t.Errorf("expected both containers under web.docker., got %v", records["web.docker."])
+	}
+	if len(srvs["_http._tcp.web.docker."]) != 1 {
+		t.Errorf("expected one SRV for web.docker., got %v", srvs["_http._tcp.web.docker."])
+	}
+	if len(ptrs[mustReverseAddr("172.17.0.2")]) != 2 {
+		t.Errorf("expected PTR for 172.17.0.2 to list web1 and web, got %v", ptrs[mustReverseAddr("172.17.0.2")])
+	}
+	if len(txts["web.docker."]) != 2 {
+		t.Errorf("expected TXT RRs from both containers at web.docker., got %v", txts["web.docker."])
+	}
+}
+
+func TestApplyEventsLatestActionWins(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web1", "172.17.0.2", map[string]string{}),
+			"c2": runningContainer("web2", "172.17.0.3", map[string]string{}),
+		},
+	}
+
+	d.applyEvents(ctx, inspector, []events.Message{
+		containerEvent(events.ActionCreate, "c1"),
+		containerEvent(events.ActionStart, "c1"),
+		containerEvent(events.ActionStart, "c2"),
+		containerEvent(events.ActionDie, "c2"),
+	})
+	assertRecordIPs(t, d, "web1.docker.", "172.17.0.2")
+	assertRecordIPs(t, d, "web2.docker.")
+	if len(d.containerOrder) != 1 || d.containerOrder[0] != "c1" {
+		t.Errorf("expected only c1 to be tracked, got %v", d.containerOrder)
+	}
+}
+
+func TestCountDrift(t *testing.T) {
+	web := &recordSet{records: map[string][]net.IP{"web.docker.": {net.ParseIP("172.17.0.2")}}}
+	webMoved := &recordSet{records: map[string][]net.IP{"web.docker.": {net.ParseIP("172.17.0.9")}}}
+	db := &recordSet{records: map[string][]net.IP{"db.docker.": {net.ParseIP("172.17.0.3")}}}
+
+	tests := []struct {
+		name   string
+		before map[string]*recordSet
+		after  map[string]*recordSet
+		want   int
+	}{
+		{"identical", map[string]*recordSet{"c1": web, "c2": db}, map[string]*recordSet{"c1": web, "c2": db}, 0},
+		{"both empty", nil, map[string]*recordSet{}, 0},
+		{"missed start", map[string]*recordSet{"c1": web}, map[string]*recordSet{"c1": web, "c2": db}, 1},
+		{"missed stop", map[string]*recordSet{"c1": web, "c2": db}, map[string]*recordSet{"c1": web}, 1},
+		{"missed change", map[string]*recordSet{"c1": web, "c2": db}, map[string]*recordSet{"c1": webMoved, "c2": db}, 1},
+		{"container without records", map[string]*recordSet{"c1": nil}, map[string]*recordSet{"c1": nil}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countDrift(tt.before, tt.after); got != tt.want {
+				t.Errorf("countDrift() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func networkEvent(action events.Action, networkID, containerID string) events.Message {
+	msg := events.Message{
+		Type:   events.NetworkEventType,
+		Action: action,
+		Actor:  events.Actor{ID: networkID, Attributes: map[string]string{}},
+	}
+	if containerID != "" {
+		msg.Actor.Attributes["container"] = containerID
+	}
+	return msg
+}
+
+func TestHandleEventRename(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("old", "172.17.0.2", map[string]string{}),
+		},
+	}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+	assertRecordIPs(t, d, "old.docker.", "172.17.0.2")
+
+	inspector.inspections["c1"] = runningContainer("new", "172.17.0.2", map[string]string{})
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionRename, "c1"))
+	assertRecordIPs(t, d, "old.docker.")
+	assertRecordIPs(t, d, "new.docker.", "172.17.0.2")
+}
+
+func TestHandleEventPauseUnpause(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web", "172.17.0.2", map[string]string{}),
+		},
+	}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionPause, "c1"))
+	assertRecordIPs(t, d, "web.docker.")
+
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionUnPause, "c1"))
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+
+	// A full resync of a paused container also leaves it out.
+	paused := runningContainer("web", "172.17.0.2", map[string]string{})
+	paused.State.Paused = true
+	inspector.inspections["c1"] = paused
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionUnPause, "c1"))
+	assertRecordIPs(t, d, "web.docker.")
+}
+
+func TestHandleEventHealthStatus(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	withHealth := func(status container.HealthStatus) container.InspectResponse {
+		c := runningContainer("web", "172.17.0.2", map[string]string{})
+		c.State.Health = &container.Health{Status: status}
+		return c
+	}
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": withHealth(container.Starting),
+		},
+	}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+
+	// A health change re-inspects the container, but its health does not
+	// decide whether it is served.
+	inspector.inspections["c1"] = withHealth(container.Unhealthy)
+	inspector.inspections["c1"].NetworkSettings.Networks["bridge"].IPAddress = "172.17.0.3"
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionHealthStatusUnhealthy, "c1"))
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.3")
+
+	inspector.inspections["c1"] = withHealth(container.Healthy)
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionHealthStatusHealthy, "c1"))
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+}
+
+func TestHandleEventNetworkConnectDisconnect(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	d.networks = []string{"bridge", "app"}
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web", "172.17.0.2", map[string]string{}),
+		},
+	}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+
+	connected := runningContainer("web", "172.17.0.2", map[string]string{})
+	connected.NetworkSettings.Networks["app"] = &network.EndpointSettings{
+		IPAddress: "172.20.0.5",
+		Aliases:   []string{"api"},
+	}
+	inspector.inspections["c1"] = connected
+	d.handleEvent(ctx, inspector, networkEvent(events.ActionConnect, "net1", "c1"))
+	assertRecordIPs(t, d, "web.docker.", "172.20.0.5", "172.17.0.2")
+	assertRecordIPs(t, d, "api.docker.", "172.20.0.5")
+
+	inspector.inspections["c1"] = runningContainer("web", "172.17.0.2", map[string]string{})
+	d.handleEvent(ctx, inspector, networkEvent(events.ActionDisconnect, "net1", "c1"))
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2")
+	assertRecordIPs(t, d, "api.docker.")
+}
+
+func TestHandleEventIgnoresNetworkEventsWithoutContainer(t *testing.T) {
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		errors: map[string]error{"net1": errInspectFailed},
+	}
+	// A network "destroy" shares its action name with the container
+	// event; it must not be treated as a container removal or inspect.
+	d.handleEvent(context.Background(), inspector, networkEvent(events.ActionDestroy, "net1", ""))
+	d.handleEvent(context.Background(), inspector, networkEvent(events.ActionConnect, "net1", ""))
+	if !d.loadSnapshot().lastSyncTime.IsZero() {
+		t.Errorf("expected network events without a container to be ignored")
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	filter := eventFilter(false)
+	for _, typ := range []string{"container", "network"} {
+		if !filter.ExactMatch("type", typ) {
+			t.Errorf("expected filter to include type %s", typ)
+		}
+	}
+	for _, action := range []string{
+		"start", "die", "destroy", "stop", "create", "restart",
+		"rename", "pause", "unpause", "health_status", "connect", "disconnect",
+	} {
+		if !filter.ExactMatch("event", action) {
+			t.Errorf("expected filter to include event %s", action)
+		}
+	}
+}
+
+func TestReplaceContainersCarriesForwardFailedInspect(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web1", "172.17.0.2", map[string]string{"com.dokku.coredns-docker/hostname": "web"}),
+			"c2": runningContainer("web2", "172.17.0.3", map[string]string{"com.dokku.coredns-docker/hostname": "web"}),
+		},
+		errors: map[string]error{},
+	}
+	containers := []container.Summary{
+		{ID: "c1", Names: []string{"/web1"}},
+		{ID: "c2", Names: []string{"/web2"}},
+	}
+	d.replaceContainers(ctx, containers, inspector)
+
+	before := testutil.ToFloat64(inspectFailureCount)
+	inspector.errors["c1"] = errInspectFailed
+	if drift := d.replaceContainers(ctx, containers, inspector); drift != 0 {
+		t.Errorf("expected carried-forward records not to count as drift, got %d", drift)
+	}
+	assertRecordIPs(t, d, "web1.docker.", "172.17.0.2")
+	// The carried-forward container keeps its place in the merge order.
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.2", "172.17.0.3")
+	if got := testutil.ToFloat64(inspectFailureCount) - before; got != 1 {
+		t.Errorf("expected inspect_failures_total to increase by 1, got %f", got)
+	}
+
+	// Once the grace period has run out, the container is dropped.
+	d.inspectFailures["c1"] = time.Now().Add(-2 * defaultInspectGrace)
+	d.replaceContainers(ctx, containers, inspector)
+	assertRecordIPs(t, d, "web1.docker.")
+	assertRecordIPs(t, d, "web.docker.", "172.17.0.3")
+
+	// A successful inspect brings it back and resets the grace period.
+	delete(inspector.errors, "c1")
+	d.replaceContainers(ctx, containers, inspector)
+	assertRecordIPs(t, d, "web1.docker.", "172.17.0.2")
+	if _, ok := d.inspectFailures["c1"]; ok {
+		t.Errorf("expected a successful inspect to clear the failure state")
+	}
+}
+
+func TestFailedInspectGraceExpiresWithoutSync(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	d.inspectGrace = 50 * time.Millisecond
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web1", "172.17.0.2", map[string]string{}),
+		},
+		errors: map[string]error{},
+	}
+	containers := []container.Summary{{ID: "c1", Names: []string{"/web1"}}}
+	d.replaceContainers(ctx, containers, inspector)
+
+	inspector.errors["c1"] = errInspectFailed
+	d.replaceContainers(ctx, containers, inspector)
+	assertRecordIPs(t, d, "web1.docker.", "172.17.0.2")
+
+	// No further sync or event arrives; the records still go once the
+	// grace period has run out.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := d.loadSnapshot().records["web1.docker."]; !ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertRecordIPs(t, d, "web1.docker.")
+}
+
+func TestFailedInspectGraceExpiryAfterRecovery(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	d.inspectGrace = 50 * time.Millisecond
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web1", "172.17.0.2", map[string]string{}),
+		},
+		errors: map[string]error{},
+	}
+	containers := []container.Summary{{ID: "c1", Names: []string{"/web1"}}}
+	d.replaceContainers(ctx, containers, inspector)
+
+	inspector.errors["c1"] = errInspectFailed
+	d.replaceContainers(ctx, containers, inspector)
+	delete(inspector.errors, "c1")
+	d.replaceContainers(ctx, containers, inspector)
+
+	// The expiry scheduled by the failure must not drop a container
+	// whose inspect has since succeeded.
+	time.Sleep(100 * time.Millisecond)
+	assertRecordIPs(t, d, "web1.docker.", "172.17.0.2")
+}
+
+func TestReplaceContainersFailedInspectNotTracked(t *testing.T) {
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		errors: map[string]error{"c1": errInspectFailed},
+	}
+	d.replaceContainers(context.Background(), []container.Summary{{ID: "c1", Names: []string{"/web1"}}}, inspector)
+	if len(d.containerOrder) != 0 {
+		t.Errorf("expected a never-inspected container not to be tracked, got %v", d.containerOrder)
+	}
+
+	// A container that disappears from the list forgets its failures.
+	d.replaceContainers(context.Background(), nil, inspector)
+	if len(d.inspectFailures) != 0 {
+		t.Errorf("expected failure state for unlisted containers to be dropped, got %v", d.inspectFailures)
+	}
+}
+
+func TestReplaceContainersZeroGrace(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	d.inspectGrace = 0
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web1", "172.17.0.2", map[string]string{}),
+		},
+		errors: map[string]error{},
+	}
+	containers := []container.Summary{{ID: "c1", Names: []string{"/web1"}}}
+	d.replaceContainers(ctx, containers, inspector)
+
+	inspector.errors["c1"] = errInspectFailed
+	d.replaceContainers(ctx, containers, inspector)
+	assertRecordIPs(t, d, "web1.docker.")
+}
+
+func TestHandleEventInspectErrorGraceExpires(t *testing.T) {
+	ctx := context.Background()
+	d := newSyncTestDocker()
+	inspector := &mockContainerInspector{
+		inspections: map[string]container.InspectResponse{
+			"c1": runningContainer("web1", "172.17.0.2", map[string]string{}),
+		},
+		errors: map[string]error{},
+	}
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionStart, "c1"))
+
+	inspector.errors["c1"] = errInspectFailed
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionHealthStatus, "c1"))
+	assertRecordIPs(t, d, "web1.docker.", "172.17.0.2")
+
+	d.inspectFailures["c1"] = time.Now().Add(-2 * defaultInspectGrace)
+	d.handleEvent(ctx, inspector, containerEvent(events.ActionHealthStatus, "c1"))
+	assertRecordIPs(t, d, "web1.docker.")
+	if _, ok := d.inspectFailures["c1"]; ok {
+		t.Errorf("expected dropping the container to clear its failure state")
+	}
+}
+
+func TestEventContainerName(t *testing.T) {
+	named := containerEvent(events.ActionStart, "0123456789abcdef0123")
+	named.Actor.Attributes = map[string]string{"name": "web1"}
+	if got := eventContainerName(named); got != "web1" {
+		t.Errorf("expected the name attribute, got %q", got)
+	}
+	if got := eventContainerName(containerEvent(events.ActionStart, "0123456789abcdef0123")); got != "0123456789ab" {
+		t.Errorf("expected the short ID, got %q", got)
+	}
+	if got := eventContainerName(networkEvent(events.ActionConnect, "net1", "0123456789abcdef0123")); got != "0123456789ab" {
+		t.Errorf("expected the short container ID for network events, got %q", got)
+	}
+}
+
+func TestResyncWithSeveralPortsHasNoDrift(t *testing.T) {
+	for _, hostMode := range []bool{false, true} {
+		c := fixtureContainer("c1", "web", "172.17.0.2")
+		c.Ports = map[string][]PortBinding{
+			"80/tcp":   {{HostIP: "0.0.0.0", HostPort: "8080"}},
+			"443/tcp":  {{HostIP: "0.0.0.0", HostPort: "8443"}},
+			"8000/tcp": {{HostIP: "0.0.0.0", HostPort: "9000"}},
+			"53/udp":   {{HostIP: "0.0.0.0", HostPort: "5353"}},
+		}
+		d := newSyncTestDocker()
+		d.hostMode = hostMode
+		d.source = newFixtureSource(c)
+		if _, err := d.fullSync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		for range 20 {
+			drift, err := d.fullSync(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if drift != 0 {
+				t.Fatalf("host mode %t: expected an unchanged container to report no drift, got %d", hostMode, drift)
+			}
+		}
+	}
+}
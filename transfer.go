@@ -0,0 +1,158 @@
+package docker
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/miekg/dns"
+)
+
+// errNotSynced is returned for a zone transfer before the first sync, so
+// secondaries keep their copy instead of replacing it with an empty zone.
+var errNotSynced = errors.New("zone has not been synced yet")
+
+// notifier sends DNS NOTIFY for a zone to its secondaries. The transfer
+// plugin implements it.
+type notifier interface {
+	Notify(zone string) error
+}
+
+// Transfer implements transfer.Transferer. An AXFR gets the zone's SOA
+// and NS records, every record of the last published snapshot whose
+// closest configured zone is zone, and the SOA again. An IXFR for the
+// current serial or a newer one gets the SOA alone, and one for a serial
+// still in the zone's journal gets the changes since then in the RFC 1995
+// format; any other IXFR is answered as an AXFR.
+func (d *Docker) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
+	i := slices.IndexFunc(d.zones, func(z string) bool { return strings.EqualFold(z, zone) })
+	if i < 0 {
+		return nil, transfer.ErrNotAuthoritative
+	}
+	zone = d.zones[i]
+
+	snap := d.loadSnapshot()
+	if snap.answers == nil {
+		return nil, errNotSynced
+	}
+	soa := d.apexSOA(snap, zone).(*dns.SOA)
+	v, _ := snap.answers.version(zone)
+
+	ch := make(chan []dns.RR)
+	go func() {
+		defer close(ch)
+		if serial != 0 && serialNotBefore(serial, soa.Serial) {
+			ch <- []dns.RR{soa}
+			return
+		}
+		if diffs, ok := v.since(serial); serial != 0 && ok {
+			ch <- []dns.RR{soa}
+			for _, diff := range diffs {
+				ch <- append([]dns.RR{soaWithSerial(soa, diff.from)}, copyRRs(diff.deleted)...)
+				ch <- append([]dns.RR{soaWithSerial(soa, diff.to)}, copyRRs(diff.added)...)
+			}
+			ch <- []dns.RR{copyRR(soa)}
+			return
+		}
+		ch <- []dns.RR{soa}
+		ch <- d.zoneRecords(snap.answers, zone)
+		ch <- []dns.RR{copyRR(soa)}
+	}()
+	return ch, nil
+}
+
+// serialNotBefore reports whether serial a is the same as or newer than
+// serial b, comparing them with RFC 1982 serial number arithmetic so the
+// comparison holds when serials wrap around.
+func serialNotBefore(a, b uint32) bool {
+	return a == b || int32(a-b) > 0
+}
+
+// soaWithSerial returns a copy of soa with its serial set to serial, for
+// the intermediate SOA records of an incremental transfer.
+func soaWithSerial(soa *dns.SOA, serial uint32) dns.RR {
+	rr := copyRR(soa).(*dns.SOA)
+	rr.Serial = serial
+	return rr
+}
+
+// copyRRs returns copies of rrs.
+func copyRRs(rrs []dns.RR) []dns.RR {
+	out := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		out[i] = copyRR(rr)
+	}
+	return out
+}
+
+// zoneRecords returns the zone's NS records and every record in the table
+// whose closest configured zone is zone, sorted by owner name, for a
+// zone transfer. The SOA is left out. The RRs are copies.
+func (d *Docker) zoneRecords(a *answerTable, zone string) []dns.RR {
+	if a == nil {
+		return nil
+	}
+	zones := plugin.Zones(d.zones)
+	var rrs []dns.RR
+	rrs = append(rrs, copyRRs(a.nss[zone])...)
+
+	names := make([]string, 0, len(a.names))
+	for name := range a.names {
+		if zones.Matches(name) == zone {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n := a.names[name]
+		if n.cname != nil {
+			rrs = append(rrs, copyRR(n.cname))
+			continue
+		}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeSRV, dns.TypeTXT} {
+			for _, rr := range n.rrsets[qtype] {
+				rrs = append(rrs, copyRR(rr))
+			}
+		}
+	}
+
+	// PTR records only belong to a zone when a reverse zone is configured.
+	var arpas []string
+	for arpa := range a.ptrs {
+		if zones.Matches(arpa) == zone {
+			arpas = append(arpas, arpa)
+		}
+	}
+	sort.Strings(arpas)
+	for _, arpa := range arpas {
+		for _, rr := range a.ptrs[arpa] {
+			rrs = append(rrs, copyRR(rr))
+		}
+	}
+	return rrs
+}
+
+// notifyChanges sends NOTIFY for every zone whose serial differs between
+// the answers published before and now, which is every zone whose
+// records changed.
+func (d *Docker) notifyChanges(prev, next *answerTable) {
+	if d.xfr == nil {
+		return
+	}
+	for _, zone := range d.zones {
+		before, ok := prev.version(zone)
+		after, _ := next.version(zone)
+		if ok && after != nil && before.serial == after.serial {
+			continue
+		}
+		log.Debugf("Records in zone %s changed, notifying secondaries", zone)
+		go func(zone string) {
+			if err := d.xfr.Notify(strings.ToLower(zone)); err != nil {
+				log.Warningf("Failed to notify secondaries of zone %s: %v", zone, err)
+			}
+		}(zone)
+	}
+}
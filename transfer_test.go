@@ -0,0 +1,204 @@
+package docker
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/miekg/dns"
+)
+
+// fakeNotifier records the zones it is asked to notify.
+type fakeNotifier struct {
+	zones chan string
+}
+
+func (f *fakeNotifier) Notify(zone string) error {
+	f.zones <- zone
+	return nil
+}
+
+// collectTransfer drains a transfer channel into one RR list.
+func collectTransfer(t *testing.T, ch <-chan []dns.RR) []dns.RR {
+	t.Helper()
+	var rrs []dns.RR
+	for batch := range ch {
+		rrs = append(rrs, batch...)
+	}
+	return rrs
+}
+
+func newTransferTestDocker(t *testing.T, containers ...Container) *Docker {
+	t.Helper()
+	d := newSyncTestDocker()
+	d.zones = []string{"docker.", "internal.docker."}
+	d.source = newFixtureSource(containers...)
+	if _, err := d.fullSync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestTransferAXFR(t *testing.T) {
+	d := newTransferTestDocker(t,
+		fixtureContainer("c1", "web", "172.17.0.2"),
+		fixtureContainer("c2", "api", "172.17.0.3"),
+		fixtureContainer("c3", "db", "172.17.0.4"),
+	)
+
+	ch, err := d.Transfer("docker.", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrs := collectTransfer(t, ch)
+	if len(rrs) < 4 {
+		t.Fatalf("expected SOA, NS, records and SOA, got %v", rrs)
+	}
+	first, ok1 := rrs[0].(*dns.SOA)
+	last, ok2 := rrs[len(rrs)-1].(*dns.SOA)
+	if !ok1 || !ok2 || first.Serial != last.Serial {
+		t.Errorf("expected the transfer to start and end with the same SOA, got %v and %v", rrs[0], rrs[len(rrs)-1])
+	}
+	if _, ok := rrs[1].(*dns.NS); !ok {
+		t.Errorf("expected the NS record after the SOA, got %v", rrs[1])
+	}
+
+	var owners []string
+	for _, rr := range rrs[2 : len(rrs)-1] {
+		owners = append(owners, rr.Header().Name)
+	}
+	want := []string{"api.docker.", "db.docker.", "web.docker."}
+	if !slices.Equal(owners, want) {
+		t.Errorf("expected %v in the transfer, without the internal.docker. records, got %v", want, owners)
+	}
+}
+
+func TestTransferNestedZone(t *testing.T) {
+	d := newTransferTestDocker(t, fixtureContainer("c1", "web", "172.17.0.2"))
+	d.zones = []string{"docker.", "web.docker."}
+	d.publishLocked()
+
+	ch, err := d.Transfer("docker.", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rr := range collectTransfer(t, ch) {
+		if rr.Header().Name == "web.docker." && rr.Header().Rrtype == dns.TypeA {
+			t.Errorf("expected records of a more specific zone to be left out, got %v", rr)
+		}
+	}
+}
+
+func TestTransferNotAuthoritative(t *testing.T) {
+	d := newTransferTestDocker(t)
+	if _, err := d.Transfer("example.org.", 0); err != transfer.ErrNotAuthoritative {
+		t.Errorf("expected ErrNotAuthoritative for a foreign zone, got %v", err)
+	}
+	if _, err := d.Transfer("DOCKER.", 0); err != nil {
+		t.Errorf("expected zone names to match case-insensitively, got %v", err)
+	}
+
+	if _, err := newSyncTestDocker().Transfer("docker.", 0); err != errNotSynced {
+		t.Errorf("expected a transfer before the first sync to fail, got %v", err)
+	}
+}
+
+func TestTransferIXFR(t *testing.T) {
+	d := newTransferTestDocker(t, fixtureContainer("c1", "web", "172.17.0.2"))
+	serial := d.apexSOA(d.loadSnapshot(), "docker.").(*dns.SOA).Serial
+
+	ch, err := d.Transfer("docker.", serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rrs := collectTransfer(t, ch); len(rrs) != 1 {
+		t.Errorf("expected an up to date IXFR to get the SOA alone, got %v", rrs)
+	}
+
+	ch, err = d.Transfer("docker.", serial-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rrs := collectTransfer(t, ch); len(rrs) < 4 {
+		t.Errorf("expected an outdated IXFR to fall back to a full transfer, got %v", rrs)
+	}
+}
+
+func TestTransferIXFRSerialArithmetic(t *testing.T) {
+	d := newTransferTestDocker(t, fixtureContainer("c1", "web", "172.17.0.2"))
+	serial := d.apexSOA(d.loadSnapshot(), "docker.").(*dns.SOA).Serial
+
+	// Half the serial space ahead is behind in RFC 1982 terms, even
+	// though it may compare higher as a plain number.
+	ch, err := d.Transfer("docker.", serial+1<<31+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rrs := collectTransfer(t, ch); len(rrs) < 4 {
+		t.Errorf("expected a serial behind ours to get a full transfer, got %v", rrs)
+	}
+
+	ch, err = d.Transfer("docker.", serial+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rrs := collectTransfer(t, ch); len(rrs) != 1 {
+		t.Errorf("expected a serial ahead of ours to get the SOA alone, got %v", rrs)
+	}
+}
+
+func TestSerialNotBefore(t *testing.T) {
+	tests := []struct {
+		a, b uint32
+		want bool
+	}{
+		{a: 5, b: 5, want: true},
+		{a: 6, b: 5, want: true},
+		{a: 5, b: 6, want: false},
+		{a: 1, b: 0xffffffff, want: true},
+		{a: 0xffffffff, b: 1, want: false},
+		{a: 0x80000005, b: 5, want: false},
+	}
+	for _, test := range tests {
+		if got := serialNotBefore(test.a, test.b); got != test.want {
+			t.Errorf("serialNotBefore(%d, %d) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestNotifyOnZoneChange(t *testing.T) {
+	ctx := context.Background()
+	d := newTransferTestDocker(t)
+	notified := &fakeNotifier{zones: make(chan string, 4)}
+	d.xfr = notified
+	src := d.source.(*fixtureSource)
+
+	// A container publishes its name in both zones, so both change.
+	src.set(fixtureContainer("c1", "web", "172.17.0.2"))
+	d.applyContainerEvents(ctx, src, []ContainerEvent{{ID: "c1"}})
+	var zones []string
+	for range 2 {
+		select {
+		case zone := <-notified.zones:
+			zones = append(zones, zone)
+		case <-time.After(time.Second):
+			t.Fatalf("expected a notify for both zones, got %v", zones)
+		}
+	}
+	slices.Sort(zones)
+	if !slices.Equal(zones, []string{"docker.", "internal.docker."}) {
+		t.Errorf("expected a notify for each zone, got %v", zones)
+	}
+
+	// A sync that changes nothing sends no notify.
+	if _, err := d.fullSync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case zone := <-notified.zones:
+		t.Errorf("expected no notify for an unchanged zone, got %s", zone)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,554 @@
+package docker
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/miekg/dns"
+)
+
+// updateTypes are the record types a dynamic update may add or delete.
+// The SOA, NS and DNSSEC records stay synthetic.
+var updateTypes = map[uint16]bool{
+	dns.TypeA:     true,
+	dns.TypeAAAA:  true,
+	dns.TypeCNAME: true,
+	dns.TypeTXT:   true,
+	dns.TypeSRV:   true,
+	dns.TypePTR:   true,
+}
+
+// tsigAlgorithms maps the algorithm names update_key accepts to their
+// TSIG names.
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// updateKey is a TSIG key allowed to send dynamic updates.
+type updateKey struct {
+	algorithm string
+	secret    string
+}
+
+// overlay holds the records added by dynamic updates (RFC 2136). They
+// are merged into every answer table buildAnswers makes, so they survive
+// syncs, which only replace the container records. timer removes them
+// when their lease runs out.
+type overlay struct {
+	mu      sync.Mutex
+	records map[string][]overlayRecord // lowercase owner name -> records
+	timer   *time.Timer
+	stopped bool
+}
+
+// overlayRecord is a record added by an update and when it expires; a
+// zero expires never does.
+type overlayRecord struct {
+	rr      dns.RR
+	expires time.Time
+}
+
+// live reports whether the record is still served at now.
+func (r overlayRecord) live(now time.Time) bool {
+	return r.expires.IsZero() || now.Before(r.expires)
+}
+
+var (
+	acceptUpdatesOnce sync.Once
+	// updateUsers counts the running instances with an update_key.
+	updateUsers atomic.Int32
+)
+
+// acceptUpdates lets UPDATE messages through to the plugins until the
+// matching releaseUpdates. The miekg/dns server CoreDNS runs answers them
+// with NOTIMP by default and CoreDNS has no per-server option to change
+// that, so the process-wide default accept function is wrapped once. The
+// wrapper admits UPDATE messages only while an instance with an
+// update_key is running, and only with one zone and at most an OPT and a
+// TSIG record in the additional section; every other message is still
+// checked by the original. While it admits them, server blocks without an
+// update_key receive UPDATE messages too.
+//
+// A server picks up the accept function when it starts, so acceptUpdates
+// must be called before the servers start, from OnStartup.
+func acceptUpdates() {
+	acceptUpdatesOnce.Do(func() {
+		accept := dns.DefaultMsgAcceptFunc
+		dns.DefaultMsgAcceptFunc = func(dh dns.Header) dns.MsgAcceptAction {
+			response := dh.Bits&(1<<15) != 0
+			opcode := int(dh.Bits>>11) & 0xF
+			if response || opcode != dns.OpcodeUpdate || updateUsers.Load() == 0 {
+				return accept(dh)
+			}
+			if dh.Qdcount != 1 || dh.Arcount > 2 {
+				return dns.MsgReject
+			}
+			return dns.MsgAccept
+		}
+	})
+	updateUsers.Add(1)
+}
+
+// releaseUpdates undoes acceptUpdates, on shutdown. Once no instance with
+// an update_key is left, UPDATE messages get NOTIMP again.
+func releaseUpdates() {
+	updateUsers.Add(-1)
+}
+
+// serveUpdate answers an UPDATE message. Updates are never passed to the
+// next plugin, which might forward them to another server: without an
+// update_key they get NOTIMP, as from a server without the plugin, and
+// updates for zones the plugin does not serve are refused.
+func (d *Docker) serveUpdate(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	var qname, zone string
+	if len(r.Question) == 1 {
+		qname = strings.ToLower(r.Question[0].Name)
+		zone = plugin.Zones(d.zones).Matches(qname)
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	lease, requested := d.lease(r)
+	switch {
+	case len(d.updateKeys) == 0:
+		m.Rcode = dns.RcodeNotImplemented
+	case zone == "" || !strings.EqualFold(zone, qname):
+		log.Debugf("Refusing update for %s, not one of the zones [%s]", qname, strings.Join(d.zones, ", "))
+		m.Rcode = dns.RcodeRefused
+	case r.Question[0].Qtype != dns.TypeSOA:
+		// The zone section names the zone by its SOA (RFC 2136 section
+		// 3.1.1).
+		m.Rcode = dns.RcodeFormatError
+	case requested && lease == 0:
+		// A lease of zero would keep the records forever.
+		m.Rcode = dns.RcodeFormatError
+	default:
+		m.Rcode = d.authorize(w, r)
+	}
+	if m.Rcode == dns.RcodeSuccess {
+		m.Rcode = d.update(r, zone, lease)
+		if requested && m.Rcode == dns.RcodeSuccess {
+			m.SetEdns0(dns.DefaultMsgSize, false)
+			opt := m.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: uint32(lease / time.Second)})
+		}
+	}
+	log.Debugf("Update for zone %s: %s", qname, dns.RcodeToString[m.Rcode])
+	updateCount.WithLabelValues(metrics.WithServer(ctx), dns.RcodeToString[m.Rcode]).Inc()
+
+	// The server signs the response with the request's key.
+	if t := r.IsTsig(); t != nil && w.TsigStatus() == nil {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, t.Fudge, time.Now().Unix())
+	}
+	if err := w.WriteMsg(m); err != nil {
+		log.Errorf("Failed to write message: %v", err)
+		requestFailedCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
+	} else {
+		requestSuccessCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// authorize checks that an update carries a valid TSIG from one of the
+// update keys. Unsigned updates are refused; those signed with another
+// key, or failing verification, are not authorized.
+func (d *Docker) authorize(w dns.ResponseWriter, r *dns.Msg) int {
+	t := r.IsTsig()
+	if t == nil {
+		log.Debugf("Refusing unsigned update")
+		return dns.RcodeRefused
+	}
+	key, ok := d.updateKeys[t.Hdr.Name]
+	if !ok || !strings.EqualFold(t.Algorithm, key.algorithm) {
+		log.Warningf("Rejecting update signed with unknown key %s (%s)", t.Hdr.Name, t.Algorithm)
+		return dns.RcodeNotAuth
+	}
+	if err := w.TsigStatus(); err != nil {
+		log.Warningf("Rejecting update signed with key %s: %v", t.Hdr.Name, err)
+		return dns.RcodeNotAuth
+	}
+	return dns.RcodeSuccess
+}
+
+// lease returns how long the records an update adds are served: the
+// lease of its EDNS0 update lease option if it has one, otherwise
+// update_lease. Zero means until they are deleted, which only
+// update_lease can ask for. requested reports whether the option was
+// present, so the granted lease is echoed back.
+func (d *Docker) lease(r *dns.Msg) (lease time.Duration, requested bool) {
+	if opt := r.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ul, ok := o.(*dns.EDNS0_UL); ok {
+				return time.Duration(ul.Lease) * time.Second, true
+			}
+		}
+	}
+	return d.updateLease, false
+}
+
+// update checks an authorized update's prerequisites against the served
+// records and applies its update section to the overlay (RFC 2136
+// section 3). Updates are applied one at a time, each republishing the
+// answers before the next is checked.
+func (d *Docker) update(r *dns.Msg, zone string, lease time.Duration) int {
+	d.updateMu.Lock()
+	defer d.updateMu.Unlock()
+
+	a := d.loadSnapshot().answers
+	if rcode := checkPrerequisites(a, zone, r.Answer); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	if rcode := prescan(zone, r.Ns); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	var expires time.Time
+	if lease > 0 {
+		expires = time.Now().Add(lease)
+	}
+	if d.overlay.apply(a, r.Ns, d.ttl, expires) {
+		d.republish()
+	}
+	d.scheduleExpiry()
+	return dns.RcodeSuccess
+}
+
+// checkPrerequisites evaluates the prerequisite section of an update
+// (RFC 2136 section 3.2) against the served answers.
+func checkPrerequisites(a *answerTable, zone string, prereqs []dns.RR) int {
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	var keys []rrsetKey
+	want := make(map[rrsetKey][]dns.RR)
+	for _, rr := range prereqs {
+		h := rr.Header()
+		name := strings.ToLower(h.Name)
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !dns.IsSubDomain(zone, name) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rrtype == dns.TypeANY {
+				if !nameInUse(a, zone, name) {
+					return dns.RcodeNameError
+				}
+			} else if len(rrset(a, zone, name, h.Rrtype)) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if h.Rrtype == dns.TypeANY {
+				if nameInUse(a, zone, name) {
+					return dns.RcodeYXDomain
+				}
+			} else if len(rrset(a, zone, name, h.Rrtype)) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			k := rrsetKey{name, h.Rrtype}
+			if _, ok := want[k]; !ok {
+				keys = append(keys, k)
+			}
+			want[k] = append(want[k], rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	// Value-dependent prerequisites must match whole RRsets.
+	for _, k := range keys {
+		if !sameRdata(rrset(a, zone, k.name, k.rrtype), want[k]) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// prescan validates the update section before anything is applied (RFC
+// 2136 section 3.4.1), so an update is applied entirely or not at all.
+func prescan(zone string, updates []dns.RR) int {
+	for _, rr := range updates {
+		h := rr.Header()
+		if !dns.IsSubDomain(zone, strings.ToLower(h.Name)) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassINET:
+			if !updateTypes[h.Rrtype] {
+				return dns.RcodeRefused
+			}
+		case dns.ClassANY, dns.ClassNONE:
+			if h.Ttl != 0 {
+				return dns.RcodeFormatError
+			}
+			if !updateTypes[h.Rrtype] && (h.Class == dns.ClassNONE || h.Rrtype != dns.TypeANY) {
+				return dns.RcodeRefused
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// nameInUse reports whether name owns any record in the served answers.
+func nameInUse(a *answerTable, zone, name string) bool {
+	if name == strings.ToLower(zone) {
+		return true
+	}
+	if _, ok := a.lookup(name); ok {
+		return true
+	}
+	_, ok := a.ptr(name)
+	return ok
+}
+
+// rrset returns the served RRset of name and type.
+func rrset(a *answerTable, zone, name string, rrtype uint16) []dns.RR {
+	if a == nil {
+		return nil
+	}
+	if name == strings.ToLower(zone) {
+		switch rrtype {
+		case dns.TypeSOA:
+			if soa, ok := a.soas[zone]; ok {
+				return []dns.RR{soa}
+			}
+			return nil
+		case dns.TypeNS:
+			return a.nss[zone]
+		}
+	}
+	if rrtype == dns.TypePTR {
+		rrs, _ := a.ptr(name)
+		return rrs
+	}
+	n, ok := a.lookup(name)
+	if !ok {
+		return nil
+	}
+	if rrtype == dns.TypeCNAME {
+		if n.cname != nil {
+			return []dns.RR{n.cname}
+		}
+		return nil
+	}
+	return n.rrsets[rrtype]
+}
+
+// rdataKey identifies a record by owner, type and RDATA, ignoring the
+// owner's case, the TTL and the class.
+func rdataKey(rr dns.RR) string {
+	c := dns.Copy(rr)
+	h := c.Header()
+	h.Name = strings.ToLower(h.Name)
+	h.Ttl = 0
+	h.Class = dns.ClassINET
+	h.Rdlength = 0
+	return c.String()
+}
+
+// sameRdata reports whether two RRsets hold the same records.
+func sameRdata(a, b []dns.RR) bool {
+	keys := func(rrs []dns.RR) []string {
+		out := make([]string, 0, len(rrs))
+		for _, rr := range rrs {
+			out = append(out, rdataKey(rr))
+		}
+		slices.Sort(out)
+		return slices.Compact(out)
+	}
+	return slices.Equal(keys(a), keys(b))
+}
+
+// apply applies a prescanned update section to the overlay: class IN
+// adds a record, class ANY deletes an RRset or, with type ANY, every
+// RRset at the name, and class NONE deletes one record (RFC 2136 section
+// 3.4.2). Deletes only remove records added by updates; container
+// records are left alone. Added records keep the TTL the update gives
+// them, up to ttl. Following RFC 2136, a CNAME is not added to a name
+// with other records, nor other records to a CNAME name; a record already
+// present is replaced, taking the new TTL and expiry. apply reports
+// whether the served records changed.
+func (o *overlay) apply(a *answerTable, updates []dns.RR, ttl uint32, expires time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.records == nil {
+		o.records = make(map[string][]overlayRecord)
+	}
+	changed := false
+	for _, rr := range updates {
+		h := rr.Header()
+		name := strings.ToLower(h.Name)
+		recs := o.records[name]
+		switch h.Class {
+		case dns.ClassINET:
+			c := dns.Copy(rr)
+			*c.Header() = dns.RR_Header{Name: name, Rrtype: h.Rrtype, Class: dns.ClassINET, Ttl: min(h.Ttl, ttl)}
+			key := rdataKey(rr)
+			if i := slices.IndexFunc(recs, func(r overlayRecord) bool { return rdataKey(r.rr) == key }); i >= 0 {
+				// Replace rather than modify the record, which published
+				// answer tables still hold.
+				changed = changed || recs[i].rr.Header().Ttl != c.Header().Ttl
+				recs[i] = overlayRecord{rr: c, expires: expires}
+				continue
+			}
+			if o.conflicts(a, name, h.Rrtype) {
+				log.Debugf("Ignoring update adding %s %s next to a conflicting CNAME", name, dns.TypeToString[h.Rrtype])
+				continue
+			}
+			if h.Rrtype == dns.TypeCNAME {
+				// A name has a single CNAME, so a new one replaces it.
+				recs = slices.DeleteFunc(recs, func(r overlayRecord) bool { return r.rr.Header().Rrtype == dns.TypeCNAME })
+			}
+			recs = append(recs, overlayRecord{rr: c, expires: expires})
+			changed = true
+		case dns.ClassANY:
+			n := len(recs)
+			recs = slices.DeleteFunc(recs, func(r overlayRecord) bool {
+				return h.Rrtype == dns.TypeANY || r.rr.Header().Rrtype == h.Rrtype
+			})
+			changed = changed || len(recs) != n
+		case dns.ClassNONE:
+			key := rdataKey(rr)
+			n := len(recs)
+			recs = slices.DeleteFunc(recs, func(r overlayRecord) bool { return rdataKey(r.rr) == key })
+			changed = changed || len(recs) != n
+		}
+		if len(recs) == 0 {
+			delete(o.records, name)
+		} else {
+			o.records[name] = recs
+		}
+	}
+	return changed
+}
+
+// conflicts reports whether adding a record of rrtype at name breaks the
+// rule that a CNAME owner has no other records, given the served answers
+// and the overlay. A CNAME may replace another CNAME. The caller must
+// hold o.mu.
+func (o *overlay) conflicts(a *answerTable, name string, rrtype uint16) bool {
+	n, _ := a.lookup(name)
+	if rrtype == dns.TypeCNAME {
+		if n != nil && n.cname == nil && len(n.rrsets) > 0 {
+			return true
+		}
+		return slices.ContainsFunc(o.records[name], func(r overlayRecord) bool { return r.rr.Header().Rrtype != dns.TypeCNAME })
+	}
+	if n != nil && n.cname != nil {
+		return true
+	}
+	return slices.ContainsFunc(o.records[name], func(r overlayRecord) bool { return r.rr.Header().Rrtype == dns.TypeCNAME })
+}
+
+// addTo adds the overlay's live records to the answer table being built.
+func (o *overlay) addTo(a *answerTable, name func(string) *nameAnswers, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for owner, recs := range o.records {
+		for _, r := range recs {
+			if !r.live(now) {
+				continue
+			}
+			switch rrtype := r.rr.Header().Rrtype; rrtype {
+			case dns.TypePTR:
+				a.ptrs[owner] = append(a.ptrs[owner], r.rr)
+			case dns.TypeCNAME:
+				name(owner).cname = r.rr
+			default:
+				n := name(owner)
+				n.rrsets[rrtype] = append(n.rrsets[rrtype], r.rr)
+			}
+		}
+	}
+}
+
+// expire removes the records whose lease ran out by now, reporting
+// whether there were any.
+func (o *overlay) expire(now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	expired := false
+	for owner, recs := range o.records {
+		n := len(recs)
+		recs = slices.DeleteFunc(recs, func(r overlayRecord) bool { return !r.live(now) })
+		expired = expired || len(recs) != n
+		if len(recs) == 0 {
+			delete(o.records, owner)
+		} else {
+			o.records[owner] = recs
+		}
+	}
+	return expired
+}
+
+// stop cancels the expiry timer for good, on shutdown.
+func (o *overlay) stop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stopped = true
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+}
+
+// scheduleExpiry arms the overlay's timer for the earliest expiry, if
+// any record has one. The caller must hold d.updateMu.
+func (d *Docker) scheduleExpiry() {
+	o := &d.overlay
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	var next time.Time
+	for _, recs := range o.records {
+		for _, r := range recs {
+			if !r.expires.IsZero() && (next.IsZero() || r.expires.Before(next)) {
+				next = r.expires
+			}
+		}
+	}
+	if next.IsZero() || o.stopped {
+		return
+	}
+	o.timer = time.AfterFunc(time.Until(next), d.expireOverlay)
+}
+
+// expireOverlay removes expired overlay records and republishes the
+// answers without them.
+func (d *Docker) expireOverlay() {
+	d.updateMu.Lock()
+	defer d.updateMu.Unlock()
+	if d.overlay.expire(time.Now()) {
+		log.Debugf("Removing expired dynamic update records")
+		d.republish()
+	}
+	d.scheduleExpiry()
+}
+
+// republish rebuilds the answers from the current records after the
+// overlay changed, and notifies secondaries of the zones that changed.
+func (d *Docker) republish() {
+	var prev, next *answerTable
+	d.updateSnapshot(func(s *snapshot) {
+		prev = s.answers
+		next = d.buildAnswers(&s.recordSet)
+		s.answers = next
+	})
+	d.notifyChanges(prev, next)
+}
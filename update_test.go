@@ -0,0 +1,480 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+const (
+	testUpdateKey    = "acme."
+	testUpdateSecret = "c2VjcmV0IHVwZGF0ZSBrZXkgZm9yIHRlc3Rz"
+)
+
+// tsigWriter is a ResponseWriter whose TSIG verification result is
+// status, standing in for the server's check.
+type tsigWriter struct {
+	test.ResponseWriter
+	status error
+}
+
+func (w *tsigWriter) TsigStatus() error { return w.status }
+
+// newUpdateTestDocker returns a Docker serving web.docker. from a
+// container and accepting updates signed with the test key.
+func newUpdateTestDocker(t *testing.T) *Docker {
+	t.Helper()
+	d := newTransferTestDocker(t, fixtureContainer("c1", "web", "172.17.0.2"))
+	d.Next = test.ErrorHandler()
+	d.updateKeys = map[string]updateKey{testUpdateKey: {algorithm: dns.HmacSHA256, secret: testUpdateSecret}}
+	t.Cleanup(d.overlay.stop)
+	return d
+}
+
+// newUpdate returns a signed UPDATE message for zone.
+func newUpdate(zone string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.SetTsig(testUpdateKey, dns.HmacSHA256, 300, time.Now().Unix())
+	return m
+}
+
+// sendUpdate serves an update whose TSIG verified with status, after a
+// round trip through the wire format, and returns the response.
+func sendUpdate(t *testing.T, d *Docker, m *dns.Msg, status error) *dns.Msg {
+	t.Helper()
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	w := dnstest.NewRecorder(&tsigWriter{status: status})
+	if _, err := d.ServeDNS(context.Background(), w, r); err != nil {
+		t.Fatal(err)
+	}
+	return w.Msg
+}
+
+func newRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+// txts returns the TXT strings served for name.
+func txts(t *testing.T, d *Docker, name string) []string {
+	t.Helper()
+	r := new(dns.Msg)
+	r.SetQuestion(name, dns.TypeTXT)
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := d.ServeDNS(context.Background(), w, r); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rr := range w.Msg.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			got = append(got, txt.Txt...)
+		}
+	}
+	slices.Sort(got)
+	return got
+}
+
+func TestUpdateAddDelete(t *testing.T) {
+	d := newUpdateTestDocker(t)
+	serial := zoneSerial(t, d, "docker.")
+
+	m := newUpdate("docker.")
+	m.Insert([]dns.RR{
+		newRR(t, `_acme-challenge.web.docker. 60 IN TXT "token1"`),
+		newRR(t, `_acme-challenge.web.docker. 60 IN TXT "token2"`),
+	})
+	resp := sendUpdate(t, d, m, nil)
+	if resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected the update to succeed, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if resp.IsTsig() == nil {
+		t.Errorf("expected the response to be signed")
+	}
+	if got := txts(t, d, "_acme-challenge.web.docker."); !slices.Equal(got, []string{"token1", "token2"}) {
+		t.Errorf("expected both tokens, got %v", got)
+	}
+	if got := zoneSerial(t, d, "docker."); got <= serial {
+		t.Errorf("expected the update to raise serial %d, got %d", serial, got)
+	}
+
+	m = newUpdate("docker.")
+	m.Remove([]dns.RR{newRR(t, `_acme-challenge.web.docker. 0 IN TXT "token1"`)})
+	if resp := sendUpdate(t, d, m, nil); resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected the delete to succeed, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if got := txts(t, d, "_acme-challenge.web.docker."); !slices.Equal(got, []string{"token2"}) {
+		t.Errorf("expected one token left, got %v", got)
+	}
+
+	// Deleting the name removes the update's records but not the
+	// container's.
+	m = newUpdate("docker.")
+	m.Insert([]dns.RR{newRR(t, "web.docker. 60 IN A 192.0.2.1")})
+	sendUpdate(t, d, m, nil)
+	m = newUpdate("docker.")
+	m.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "web.docker."}}})
+	m.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "_acme-challenge.web.docker."}}})
+	if resp := sendUpdate(t, d, m, nil); resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected the delete to succeed, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if _, ok := d.loadSnapshot().answers.lookup("_acme-challenge.web.docker."); ok {
+		t.Errorf("expected the name to be gone")
+	}
+	n, _ := d.loadSnapshot().answers.lookup("web.docker.")
+	if rrs := n.answers(dns.TypeA); len(rrs) != 1 || rrs[0].(*dns.A).A.String() != "172.17.0.2" {
+		t.Errorf("expected only the container's address left, got %v", rrs)
+	}
+}
+
+func TestUpdateAuthorization(t *testing.T) {
+	d := newUpdateTestDocker(t)
+	add := func(m *dns.Msg) *dns.Msg {
+		m.Insert([]dns.RR{newRR(t, `_acme-challenge.docker. 60 IN TXT "token"`)})
+		return m
+	}
+
+	unsigned := new(dns.Msg)
+	unsigned.SetUpdate("docker.")
+	if resp := sendUpdate(t, d, add(unsigned), nil); resp.Rcode != dns.RcodeRefused {
+		t.Errorf("expected an unsigned update to be refused, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if resp := sendUpdate(t, d, add(newUpdate("docker.")), dns.ErrSig); resp.Rcode != dns.RcodeNotAuth || resp.IsTsig() != nil {
+		t.Errorf("expected a bad signature to get an unsigned NOTAUTH, got %v", resp)
+	}
+	other := new(dns.Msg)
+	other.SetUpdate("docker.")
+	other.SetTsig("other.", dns.HmacSHA256, 300, time.Now().Unix())
+	if resp := sendUpdate(t, d, add(other), nil); resp.Rcode != dns.RcodeNotAuth {
+		t.Errorf("expected another key to be rejected, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if got := txts(t, d, "_acme-challenge.docker."); len(got) != 0 {
+		t.Errorf("expected no rejected update to be applied, got %v", got)
+	}
+
+}
+
+func TestUpdateNotPassedOn(t *testing.T) {
+	d := newUpdateTestDocker(t)
+	passed := false
+	d.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		passed = true
+		return dns.RcodeSuccess, nil
+	})
+	add := func(m *dns.Msg) *dns.Msg {
+		m.Insert([]dns.RR{newRR(t, `_acme-challenge.example.org. 60 IN TXT "token"`)})
+		return m
+	}
+
+	if resp := sendUpdate(t, d, add(newUpdate("example.org.")), nil); resp.Rcode != dns.RcodeRefused {
+		t.Errorf("expected an update for another zone to be refused, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	d.updateKeys = nil
+	if resp := sendUpdate(t, d, add(newUpdate("docker.")), nil); resp.Rcode != dns.RcodeNotImplemented {
+		t.Errorf("expected an update without update_key to get NOTIMP, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if passed {
+		t.Errorf("expected no update to reach the next plugin")
+	}
+}
+
+func TestUpdateTTL(t *testing.T) {
+	d := newUpdateTestDocker(t)
+	d.setConnected(true)
+	ttl := func(name string) uint32 {
+		t.Helper()
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeTXT)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := d.ServeDNS(context.Background(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		if len(w.Msg.Answer) != 1 {
+			t.Fatalf("expected one answer for %s, got %v", name, w.Msg.Answer)
+		}
+		return w.Msg.Answer[0].Header().Ttl
+	}
+
+	m := newUpdate("docker.")
+	m.Insert([]dns.RR{
+		newRR(t, `short.docker. 10 IN TXT "token"`),
+		newRR(t, `long.docker. 86400 IN TXT "token"`),
+	})
+	sendUpdate(t, d, m, nil)
+	if got := ttl("short.docker."); got != 10 {
+		t.Errorf("expected the update's TTL of 10, got %d", got)
+	}
+	if got := ttl("long.docker."); got != DefaultTTL {
+		t.Errorf("expected a long TTL to be capped at %d, got %d", DefaultTTL, got)
+	}
+
+	// Adding the record again replaces its TTL.
+	m = newUpdate("docker.")
+	m.Insert([]dns.RR{newRR(t, `short.docker. 20 IN TXT "token"`)})
+	sendUpdate(t, d, m, nil)
+	if got := ttl("short.docker."); got != 20 {
+		t.Errorf("expected the new TTL of 20, got %d", got)
+	}
+}
+
+func TestUpdateRules(t *testing.T) {
+	d := newUpdateTestDocker(t)
+	tests := map[string]struct {
+		build func(m *dns.Msg)
+		want  int
+	}{
+		"name in use":           {func(m *dns.Msg) { m.NameUsed([]dns.RR{newRR(t, "web.docker. 0 IN A 0.0.0.0")}) }, dns.RcodeSuccess},
+		"name not in use":       {func(m *dns.Msg) { m.NameUsed([]dns.RR{newRR(t, "missing.docker. 0 IN A 0.0.0.0")}) }, dns.RcodeNameError},
+		"name must not exist":   {func(m *dns.Msg) { m.NameNotUsed([]dns.RR{newRR(t, "web.docker. 0 IN A 0.0.0.0")}) }, dns.RcodeYXDomain},
+		"rrset exists":          {func(m *dns.Msg) { m.RRsetUsed([]dns.RR{newRR(t, "web.docker. 0 IN AAAA ::")}) }, dns.RcodeNXRrset},
+		"rrset must not exist":  {func(m *dns.Msg) { m.RRsetNotUsed([]dns.RR{newRR(t, "web.docker. 0 IN A 0.0.0.0")}) }, dns.RcodeYXRrset},
+		"rrset value matches":   {func(m *dns.Msg) { m.Used([]dns.RR{newRR(t, "web.docker. 0 IN A 172.17.0.2")}) }, dns.RcodeSuccess},
+		"rrset value differs":   {func(m *dns.Msg) { m.Used([]dns.RR{newRR(t, "web.docker. 0 IN A 172.17.0.9")}) }, dns.RcodeNXRrset},
+		"outside the zone":      {func(m *dns.Msg) { m.Insert([]dns.RR{newRR(t, "web.example.org. 60 IN A 192.0.2.1")}) }, dns.RcodeNotZone},
+		"unsupported type":      {func(m *dns.Msg) { m.Insert([]dns.RR{newRR(t, "docker. 60 IN MX 10 mail.docker.")}) }, dns.RcodeRefused},
+		"synthetic record type": {func(m *dns.Msg) { m.RemoveRRset([]dns.RR{newRR(t, "docker. 0 IN NS ns.docker.")}) }, dns.RcodeRefused},
+		"zone section not soa":  {func(m *dns.Msg) { m.Question[0].Qtype = dns.TypeA }, dns.RcodeFormatError},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := newUpdate("docker.")
+			tt.build(m)
+			if resp := sendUpdate(t, d, m, nil); resp.Rcode != tt.want {
+				t.Errorf("expected %s, got %s", dns.RcodeToString[tt.want], dns.RcodeToString[resp.Rcode])
+			}
+		})
+	}
+
+	// A failed prerequisite leaves the update section unapplied.
+	m := newUpdate("docker.")
+	m.NameUsed([]dns.RR{newRR(t, "missing.docker. 0 IN A 0.0.0.0")})
+	m.Insert([]dns.RR{newRR(t, `_acme-challenge.docker. 60 IN TXT "token"`)})
+	sendUpdate(t, d, m, nil)
+	if got := txts(t, d, "_acme-challenge.docker."); len(got) != 0 {
+		t.Errorf("expected nothing added, got %v", got)
+	}
+
+	// A CNAME is not added next to the container's address.
+	m = newUpdate("docker.")
+	m.Insert([]dns.RR{newRR(t, "web.docker. 60 IN CNAME other.docker.")})
+	sendUpdate(t, d, m, nil)
+	if n, _ := d.loadSnapshot().answers.lookup("web.docker."); n.cname != nil {
+		t.Errorf("expected the conflicting CNAME to be ignored, got %v", n.cname)
+	}
+}
+
+func TestUpdateSurvivesSync(t *testing.T) {
+	ctx := context.Background()
+	d := newUpdateTestDocker(t)
+	src := d.source.(*fixtureSource)
+
+	m := newUpdate("docker.")
+	m.Insert([]dns.RR{newRR(t, `_acme-challenge.docker. 60 IN TXT "token"`)})
+	sendUpdate(t, d, m, nil)
+
+	src.set(fixtureContainer("c2", "api", "172.17.0.3"))
+	if _, err := d.fullSync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := txts(t, d, "_acme-challenge.docker."); !slices.Equal(got, []string{"token"}) {
+		t.Errorf("expected the token to survive a sync, got %v", got)
+	}
+	if _, ok := d.loadSnapshot().answers.lookup("api.docker."); !ok {
+		t.Errorf("expected the sync to publish the new container")
+	}
+
+	// The record is part of the zone for transfers.
+	ch, err := d.Transfer("docker.", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(collectTransfer(t, ch), func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeTXT }) {
+		t.Errorf("expected the TXT record in the zone transfer")
+	}
+}
+
+func TestUpdateLease(t *testing.T) {
+	d := newUpdateTestDocker(t)
+	d.updateLease = time.Hour
+
+	m := new(dns.Msg)
+	m.SetUpdate("docker.")
+	m.Insert([]dns.RR{newRR(t, `_acme-challenge.docker. 60 IN TXT "token"`)})
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: 1})
+	m.SetTsig(testUpdateKey, dns.HmacSHA256, 300, time.Now().Unix())
+	resp := sendUpdate(t, d, m, nil)
+	if resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected the update to succeed, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if opt := resp.IsEdns0(); opt == nil || len(opt.Option) != 1 || opt.Option[0].(*dns.EDNS0_UL).Lease != 1 {
+		t.Errorf("expected the granted lease in the response, got %v", resp.Extra)
+	}
+	if got := txts(t, d, "_acme-challenge.docker."); !slices.Equal(got, []string{"token"}) {
+		t.Fatalf("expected the token while its lease runs, got %v", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(txts(t, d, "_acme-challenge.docker.")) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the token to expire")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Without the option, update_lease applies.
+	m = newUpdate("docker.")
+	m.Insert([]dns.RR{newRR(t, `_acme-challenge.docker. 60 IN TXT "token"`)})
+	sendUpdate(t, d, m, nil)
+	d.overlay.mu.Lock()
+	expires := d.overlay.records["_acme-challenge.docker."][0].expires
+	d.overlay.mu.Unlock()
+	if until := time.Until(expires); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("expected the record to expire in an hour, got %s", until)
+	}
+
+	// A lease of zero is rejected rather than kept forever.
+	m = new(dns.Msg)
+	m.SetUpdate("docker.")
+	m.Insert([]dns.RR{newRR(t, `forever.docker. 60 IN TXT "token"`)})
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	opt = m.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: 0})
+	m.SetTsig(testUpdateKey, dns.HmacSHA256, 300, time.Now().Unix())
+	if resp := sendUpdate(t, d, m, nil); resp.Rcode != dns.RcodeFormatError {
+		t.Errorf("expected a zero lease to be rejected, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if got := txts(t, d, "forever.docker."); len(got) != 0 {
+		t.Errorf("expected nothing added with a zero lease, got %v", got)
+	}
+}
+
+func TestAcceptUpdates(t *testing.T) {
+	update := func(qd, ar uint16) dns.Header {
+		return dns.Header{Bits: uint16(dns.OpcodeUpdate) << 11, Qdcount: qd, Ancount: 3, Nscount: 5, Arcount: ar}
+	}
+
+	acceptUpdates()
+	if got := dns.DefaultMsgAcceptFunc(update(1, 2)); got != dns.MsgAccept {
+		t.Errorf("expected an update to be accepted, got %v", got)
+	}
+	if got := dns.DefaultMsgAcceptFunc(update(2, 0)); got != dns.MsgReject {
+		t.Errorf("expected an update with two zones to be rejected, got %v", got)
+	}
+	if got := dns.DefaultMsgAcceptFunc(update(1, 3)); got != dns.MsgReject {
+		t.Errorf("expected an update with three additional records to be rejected, got %v", got)
+	}
+	if got := dns.DefaultMsgAcceptFunc(dns.Header{Qdcount: 1, Ancount: 2}); got != dns.MsgReject {
+		t.Errorf("expected queries to be checked as before, got %v", got)
+	}
+
+	releaseUpdates()
+	if got := dns.DefaultMsgAcceptFunc(update(1, 2)); got != dns.MsgRejectNotImplemented {
+		t.Errorf("expected updates to get NOTIMP once released, got %v", got)
+	}
+}
+
+func TestUpdateOverTSIG(t *testing.T) {
+	d := newUpdateTestDocker(t)
+	acceptUpdates()
+	t.Cleanup(releaseUpdates)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on loopback: %v", err)
+	}
+	srv := &dns.Server{
+		PacketConn: pc,
+		TsigSecret: map[string]string{testUpdateKey: testUpdateSecret},
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			d.ServeDNS(context.Background(), w, r)
+		}),
+	}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+
+	c := &dns.Client{TsigSecret: map[string]string{testUpdateKey: testUpdateSecret}}
+	m := newUpdate("docker.")
+	m.Insert([]dns.RR{newRR(t, `_acme-challenge.docker. 60 IN TXT "token"`)})
+	var resp *dns.Msg
+	for range 50 {
+		if resp, _, err = c.Exchange(m, pc.LocalAddr().String()); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected the signed update to succeed, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if got := txts(t, d, "_acme-challenge.docker."); !slices.Equal(got, []string{"token"}) {
+		t.Errorf("expected the token, got %v", got)
+	}
+
+	c.TsigSecret = map[string]string{testUpdateKey: "d3Jvbmcgc2VjcmV0"}
+	resp, _, err = c.Exchange(newUpdate("docker."), pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeNotAuth {
+		t.Errorf("expected an update signed with the wrong secret to be rejected, got %s", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	d := &Docker{}
+	if err := parse(caddy.NewTestController("dns", `docker {
+		update_key acme HMAC-SHA256 `+testUpdateSecret+`
+		update_key other. hmac-sha512. `+testUpdateSecret+`
+		update_lease 10m
+	}`), d); err != nil {
+		t.Fatal(err)
+	}
+	if key := d.updateKeys["acme."]; key.algorithm != dns.HmacSHA256 || key.secret != testUpdateSecret {
+		t.Errorf("expected the acme. key, got %+v", d.updateKeys)
+	}
+	if key := d.updateKeys["other."]; key.algorithm != dns.HmacSHA512 {
+		t.Errorf("expected the other. key, got %+v", d.updateKeys)
+	}
+	if d.updateLease != 10*time.Minute {
+		t.Errorf("expected a 10m lease, got %s", d.updateLease)
+	}
+
+	tests := map[string]string{
+		"update_key arguments": "docker {\nupdate_key acme. hmac-sha256\n}",
+		"update_key algorithm": "docker {\nupdate_key acme. hmac-md5 " + testUpdateSecret + "\n}",
+		"update_key secret":    "docker {\nupdate_key acme. hmac-sha256 not*base64\n}",
+		"update_key duplicate": "docker {\nupdate_key acme. hmac-sha256 " + testUpdateSecret + "\nupdate_key acme hmac-sha1 " + testUpdateSecret + "\n}",
+		"update_lease invalid": "docker {\nupdate_lease soon\n}",
+		"update_lease zero":    "docker {\nupdate_lease 0s\n}",
+		"update_lease partial": "docker {\nupdate_lease 1500ms\n}",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := parse(caddy.NewTestController("dns", input), &Docker{}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
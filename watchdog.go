@@ -0,0 +1,117 @@
+package docker
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/system"
+)
+
+// daemonProber is the subset of the Docker client the heartbeat uses.
+type daemonProber interface {
+	Ping(ctx context.Context) (types.Ping, error)
+	Info(ctx context.Context) (system.Info, error)
+}
+
+// timeoutInspector bounds every ContainerInspect call with a deadline so
+// a wedged daemon cannot block a sync forever.
+type timeoutInspector struct {
+	ContainerInspector
+	timeout time.Duration
+}
+
+// ContainerInspect implements ContainerInspector.
+func (t timeoutInspector) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.ContainerInspector.ContainerInspect(ctx, containerID)
+}
+
+// withAPITimeout wraps inspector with the configured api_timeout, if any.
+func (d *Docker) withAPITimeout(inspector ContainerInspector) ContainerInspector {
+	if d.apiTimeout <= 0 {
+		return inspector
+	}
+	return timeoutInspector{ContainerInspector: inspector, timeout: d.apiTimeout}
+}
+
+// apiContext returns a context bounded by the configured api_timeout, if
+// any, for a single Docker API call.
+func (d *Docker) apiContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.apiTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.apiTimeout)
+}
+
+// runHeartbeat pings the Docker daemon every heartbeat interval until ctx
+// is cancelled. If a ping fails or times out, or the daemon reports a
+// different ID than it did when the heartbeat started (the daemon was
+// replaced underneath us), the plugin is marked disconnected and
+// onFailure is called so the event loop drops the connection and
+// reconnects with a full sync. Failures are only reported once synced is
+// set; before that they just call onFailure.
+func (d *Docker) runHeartbeat(ctx context.Context, prober daemonProber, synced *atomic.Bool, onFailure func()) {
+	daemonID, err := d.probeDaemon(ctx, prober)
+	if err != nil {
+		if ctx.Err() == nil {
+			d.heartbeatFailed(err.Error(), synced, onFailure)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(d.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			id, err := d.probeDaemon(ctx, prober)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				d.heartbeatFailed(err.Error(), synced, onFailure)
+				return
+			}
+			if id != daemonID {
+				d.heartbeatFailed("daemon ID changed from "+daemonID+" to "+id+", another daemon answers on the endpoint", synced, onFailure)
+				return
+			}
+			log.Debugf("Docker daemon heartbeat ok (daemon %s)", id)
+		}
+	}
+}
+
+// probeDaemon pings the daemon and returns its ID, bounding both calls by
+// the heartbeat timeout.
+func (d *Docker) probeDaemon(ctx context.Context, prober daemonProber) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.heartbeatTimeout)
+	defer cancel()
+	if _, err := prober.Ping(ctx); err != nil {
+		return "", err
+	}
+	info, err := prober.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.ID, nil
+}
+
+// heartbeatFailed marks the plugin disconnected, so stale-TTL answers kick
+// in right away, and hands control back to the event loop.
+func (d *Docker) heartbeatFailed(reason string, synced *atomic.Bool, onFailure func()) {
+	if !synced.Load() {
+		log.Debugf("Docker daemon heartbeat failed before the sync finished: %s", reason)
+		onFailure()
+		return
+	}
+	log.Errorf("Docker daemon heartbeat failed: %s; reconnecting", reason)
+	heartbeatFailureCount.Inc()
+	d.setConnected(false)
+	onFailure()
+}
@@ -0,0 +1,285 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/system"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeProber is a daemonProber whose responses can be changed mid-test.
+type fakeProber struct {
+	mu      sync.Mutex
+	id      string
+	pingErr error
+	hang    bool
+}
+
+func (f *fakeProber) Ping(ctx context.Context) (types.Ping, error) {
+	f.mu.Lock()
+	hang, err := f.hang, f.pingErr
+	f.mu.Unlock()
+	if hang {
+		<-ctx.Done()
+		return types.Ping{}, ctx.Err()
+	}
+	return types.Ping{}, err
+}
+
+func (f *fakeProber) Info(ctx context.Context) (system.Info, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return system.Info{ID: f.id}, nil
+}
+
+func (f *fakeProber) set(fn func(*fakeProber)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f)
+}
+
+func newHeartbeatTestDocker() *Docker {
+	d := &Docker{
+		heartbeatInterval: 10 * time.Millisecond,
+		heartbeatTimeout:  20 * time.Millisecond,
+	}
+	d.snap.Store(&snapshot{connected: true})
+	return d
+}
+
+// runHeartbeatUntilFailure runs the heartbeat and reports whether it
+// called onFailure before the deadline.
+func runHeartbeatUntilFailure(t *testing.T, d *Docker, prober daemonProber, mutate func()) bool {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var synced atomic.Bool
+	synced.Store(true)
+	failed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		d.runHeartbeat(ctx, prober, &synced, func() { close(failed) })
+		close(done)
+	}()
+
+	if mutate != nil {
+		time.Sleep(30 * time.Millisecond)
+		mutate()
+	}
+
+	select {
+	case <-failed:
+		<-done
+		return true
+	case <-ctx.Done():
+		<-done
+		return false
+	}
+}
+
+func TestHeartbeatPingFailureDisconnects(t *testing.T) {
+	d := newHeartbeatTestDocker()
+	prober := &fakeProber{id: "daemon-1"}
+	before := testutil.ToFloat64(heartbeatFailureCount)
+
+	failed := runHeartbeatUntilFailure(t, d, prober, func() {
+		prober.set(func(f *fakeProber) { f.pingErr = errors.New("connection refused") })
+	})
+	if !failed {
+		t.Fatal("expected heartbeat to report failure")
+	}
+	if d.loadSnapshot().connected {
+		t.Errorf("expected plugin to be marked disconnected")
+	}
+	if got := testutil.ToFloat64(heartbeatFailureCount); got != before+1 {
+		t.Errorf("expected heartbeat_failures_total to increase by 1, before=%f after=%f", before, got)
+	}
+}
+
+func TestHeartbeatHungDaemonTimesOut(t *testing.T) {
+	d := newHeartbeatTestDocker()
+	prober := &fakeProber{id: "daemon-1"}
+
+	failed := runHeartbeatUntilFailure(t, d, prober, func() {
+		prober.set(func(f *fakeProber) { f.hang = true })
+	})
+	if !failed {
+		t.Fatal("expected a hung ping to time out and report failure")
+	}
+}
+
+func TestHeartbeatDaemonIDChange(t *testing.T) {
+	d := newHeartbeatTestDocker()
+	prober := &fakeProber{id: "daemon-1"}
+
+	failed := runHeartbeatUntilFailure(t, d, prober, func() {
+		prober.set(func(f *fakeProber) { f.id = "daemon-2" })
+	})
+	if !failed {
+		t.Fatal("expected a changed daemon ID to report failure")
+	}
+}
+
+func TestHeartbeatInitialProbeFailure(t *testing.T) {
+	d := newHeartbeatTestDocker()
+	prober := &fakeProber{pingErr: errors.New("connection refused")}
+
+	if !runHeartbeatUntilFailure(t, d, prober, nil) {
+		t.Fatal("expected an unreachable daemon to report failure immediately")
+	}
+}
+
+func TestHeartbeatStopsOnCancel(t *testing.T) {
+	d := newHeartbeatTestDocker()
+	prober := &fakeProber{id: "daemon-1"}
+
+	var synced atomic.Bool
+	synced.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.runHeartbeat(ctx, prober, &synced, func() { t.Error("unexpected heartbeat failure") })
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat did not stop after cancel")
+	}
+	if !d.loadSnapshot().connected {
+		t.Errorf("expected plugin to stay connected")
+	}
+}
+
+// deadlineInspector records whether ContainerInspect saw a deadline.
+type deadlineInspector struct {
+	sawDeadline bool
+}
+
+func (i *deadlineInspector) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	_, i.sawDeadline = ctx.Deadline()
+	return container.InspectResponse{}, nil
+}
+
+func TestWithAPITimeout(t *testing.T) {
+	inner := &deadlineInspector{}
+
+	d := &Docker{}
+	if _, _ = d.withAPITimeout(inner).ContainerInspect(context.Background(), "c1"); inner.sawDeadline {
+		t.Errorf("expected no deadline without api_timeout")
+	}
+
+	d.apiTimeout = time.Second
+	if _, _ = d.withAPITimeout(inner).ContainerInspect(context.Background(), "c1"); !inner.sawDeadline {
+		t.Errorf("expected a deadline with api_timeout set")
+	}
+
+	ctx, cancel := d.apiContext(context.Background())
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Errorf("expected apiContext to carry a deadline with api_timeout set")
+	}
+}
+
+// downDaemon is a daemonClient for a daemon that is not running: every
+// call it gets fails.
+type downDaemon struct {
+	daemonClient
+	lists atomic.Int32
+}
+
+var errDaemonDown = errors.New("cannot connect to the Docker daemon")
+
+func (d *downDaemon) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{}, errDaemonDown
+}
+
+func (d *downDaemon) Info(ctx context.Context) (system.Info, error) {
+	return system.Info{}, errDaemonDown
+}
+
+func (d *downDaemon) ServerVersion(ctx context.Context) (types.Version, error) {
+	return types.Version{}, errDaemonDown
+}
+
+func (d *downDaemon) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	d.lists.Add(1)
+	// Like a connection attempt, failing takes a moment.
+	time.Sleep(20 * time.Millisecond)
+	return nil, errDaemonDown
+}
+
+func TestEventLoopHeartbeatQuietBeforeSync(t *testing.T) {
+	d := newSyncTestDocker()
+	daemon := &downDaemon{}
+	d.client = daemon
+	d.heartbeatInterval = 10 * time.Millisecond
+	d.heartbeatTimeout = 10 * time.Millisecond
+	d.maxBackoff = time.Second
+	before := testutil.ToFloat64(heartbeatFailureCount)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	d.startEventLoop(ctx)
+
+	if daemon.lists.Load() == 0 {
+		t.Fatal("expected the event loop to attempt a sync")
+	}
+	if got := testutil.ToFloat64(heartbeatFailureCount) - before; got != 0 {
+		t.Errorf("expected no heartbeat failures reported before the first sync, got %v", got)
+	}
+}
+
+// hungListSource is a fixtureSource whose List never returns on its own,
+// like a daemon that accepted the request and stopped answering.
+type hungListSource struct {
+	*fixtureSource
+	lists     atomic.Int32
+	cancelled chan struct{}
+}
+
+func (h *hungListSource) List(ctx context.Context) ([]ContainerRef, error) {
+	if h.lists.Add(1) == 1 {
+		<-ctx.Done()
+		close(h.cancelled)
+		return nil, ctx.Err()
+	}
+	return h.fixtureSource.List(ctx)
+}
+
+func TestEventLoopHeartbeatCancelsHungSync(t *testing.T) {
+	d := newSyncTestDocker()
+	d.client = &downDaemon{}
+	src := &hungListSource{fixtureSource: newFixtureSource(), cancelled: make(chan struct{})}
+	d.source = src
+	d.heartbeatInterval = 10 * time.Millisecond
+	d.heartbeatTimeout = 10 * time.Millisecond
+	d.maxBackoff = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		d.startEventLoop(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-src.cancelled:
+	case <-ctx.Done():
+		t.Fatal("expected the heartbeat to cancel a sync stuck in List")
+	}
+	cancel()
+	<-done
+}
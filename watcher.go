@@ -0,0 +1,364 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/client"
+)
+
+// watchSubBuffer is how many events a subscriber may fall behind the
+// watcher's event stream before it is dropped; see run.
+const watchSubBuffer = 256
+
+// errSubscriberBehind ends the subscription of an instance that stopped
+// taking events, so it reconnects and catches up with a full sync.
+var errSubscriberBehind = errors.New("too far behind the shared Docker event stream")
+
+// watchers holds the shared watchers by endpoint key; see acquireWatcher.
+var (
+	watchersMu sync.Mutex
+	watchers   = make(map[string]*watcher)
+)
+
+// watcher is a daemon connection shared by every plugin instance, in any
+// server block, configured with the same endpoint. It owns the client,
+// runs one event subscription for all of its subscribers, and caches the
+// containers they inspect. Containers are cached in their runtime-neutral
+// form, so each instance still derives records with its own zones, name
+// templates, networks and host_mode.
+type watcher struct {
+	key    string
+	client daemonClient
+	libpod podLister
+	// refs counts the instances using the watcher; guarded by
+	// watchersMu. The client is closed when the last one releases it.
+	refs int
+
+	// mu guards everything below. stream is the running event
+	// subscription, or nil while no instance is watching; containers are
+	// only cached while it runs, since nothing else invalidates them.
+	mu           sync.Mutex
+	stream       *watchStream
+	subs         map[*watchSub]struct{}
+	containers   map[string]Container
+	fingerprints map[string]uint64
+	inflight     map[string]*watchedInspect
+}
+
+// watchStream is one run of a watcher's event subscription.
+type watchStream struct {
+	cancel context.CancelFunc
+}
+
+// watchSub is an instance's subscription to a watcher's events. It ends
+// when ctx is cancelled or the stream fails.
+type watchSub struct {
+	ctx    context.Context
+	events chan ContainerEvent
+	errs   chan error
+}
+
+// watchedInspect is an inspect in progress that concurrent inspects of
+// the same container wait for instead of querying the daemon again. stale
+// is set, under the watcher's mu, when an event or a changed listing
+// means its result may already be out of date.
+type watchedInspect struct {
+	done      chan struct{}
+	container Container
+	err       error
+	stale     bool
+}
+
+// watcherKey identifies the daemon d's client would talk to: its
+// Corefile connection options plus the environment variables the Docker
+// client reads.
+func (d *Docker) watcherKey() string {
+	return strings.Join([]string{
+		d.runtimeName(), d.namespace, d.endpoint,
+		d.tlsCA, d.tlsCert, d.tlsKey, d.apiVersion,
+		os.Getenv(client.EnvOverrideHost),
+		os.Getenv(client.EnvOverrideCertPath),
+		os.Getenv(client.EnvTLSVerify),
+		os.Getenv(client.EnvOverrideAPIVersion),
+	}, "|")
+}
+
+// acquireWatcher returns the watcher for d's endpoint, creating it and
+// its client if no other instance uses the endpoint yet. Every call must
+// be paired with releaseWatcher.
+func acquireWatcher(d *Docker) (*watcher, error) {
+	key := d.watcherKey()
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	if w, ok := watchers[key]; ok {
+		w.refs++
+		log.Debugf("Sharing the Docker client for %s with %d other plugin instance(s)", d.endpointName(), w.refs-1)
+		return w, nil
+	}
+
+	cli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		key:          key,
+		client:       cli,
+		refs:         1,
+		subs:         make(map[*watchSub]struct{}),
+		containers:   make(map[string]Container),
+		fingerprints: make(map[string]uint64),
+		inflight:     make(map[string]*watchedInspect),
+	}
+	if dockerClient, ok := cli.(*client.Client); ok {
+		libpod, err := newLibpodClient(dockerClient)
+		if err != nil {
+			cli.Close()
+			return nil, err
+		}
+		w.libpod = libpod
+	}
+	watchers[key] = w
+	return w, nil
+}
+
+// releaseWatcher drops a reference to w, closing its client once no
+// instance uses it.
+func releaseWatcher(w *watcher) {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	w.refs--
+	if w.refs > 0 {
+		return
+	}
+	delete(watchers, w.key)
+	w.client.Close()
+}
+
+// releaseWatchers releases the watchers acquired for daemons so far.
+func releaseWatchers(daemons []*Docker) {
+	for _, dm := range daemons {
+		if dm.watcher != nil {
+			releaseWatcher(dm.watcher)
+			dm.watcher = nil
+		}
+	}
+}
+
+// sharedSource is the ContainerSource an instance uses while it shares a
+// watcher. src is the instance's own source, which does the actual
+// listing, inspecting and watching.
+type sharedSource struct {
+	w   *watcher
+	src ContainerSource
+}
+
+// List implements ContainerSource. Containers whose fingerprint changed
+// since the last listing are dropped from the cache, as are containers
+// that are no longer running.
+func (s *sharedSource) List(ctx context.Context) ([]ContainerRef, error) {
+	refs, err := s.src.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.w.listed(refs)
+	return refs, nil
+}
+
+// Inspect implements ContainerSource. A container cached since the last
+// event or listing that changed it is returned without querying the
+// daemon, and an inspect another instance already has in flight is
+// waited for rather than repeated.
+func (s *sharedSource) Inspect(ctx context.Context, id string) (Container, error) {
+	w := s.w
+	w.mu.Lock()
+	if c, ok := w.containers[id]; ok {
+		w.mu.Unlock()
+		return c, nil
+	}
+	if call, ok := w.inflight[id]; ok {
+		w.mu.Unlock()
+		select {
+		case <-call.done:
+			if call.err == nil {
+				return call.container, nil
+			}
+		case <-ctx.Done():
+			return Container{}, ctx.Err()
+		}
+		// Errors are not shared: the other instance's context or
+		// api_timeout may be what failed.
+		return s.src.Inspect(ctx, id)
+	}
+	call := &watchedInspect{done: make(chan struct{})}
+	w.inflight[id] = call
+	w.mu.Unlock()
+
+	call.container, call.err = s.src.Inspect(ctx, id)
+
+	w.mu.Lock()
+	if w.inflight[id] == call {
+		delete(w.inflight, id)
+	}
+	if call.err == nil && !call.stale && w.stream != nil {
+		w.containers[id] = call.container
+	}
+	w.mu.Unlock()
+	close(call.done)
+	return call.container, call.err
+}
+
+// Watch implements ContainerSource by subscribing to the watcher's
+// events, starting its event subscription from src if no other instance
+// is watching.
+func (s *sharedSource) Watch(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	w := s.w
+	sub := &watchSub{ctx: ctx, events: make(chan ContainerEvent, watchSubBuffer), errs: make(chan error, 1)}
+	w.mu.Lock()
+	w.subs[sub] = struct{}{}
+	if w.stream == nil {
+		streamCtx, cancel := context.WithCancel(context.Background())
+		w.stream = &watchStream{cancel: cancel}
+		w.resetLocked()
+		go w.run(streamCtx, w.stream, w.streamSource(s.src))
+	}
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.unsubscribe(sub, ctx.Err())
+	}()
+	return sub.events, sub.errs
+}
+
+// streamSource returns the source the event subscription reads from. A
+// dockerSource is rebuilt around the watcher's own client, keeping only
+// the Podman flag detected when the stream starts, so the stream does not
+// hold on to the instance that started it, which may shut down while
+// others keep watching.
+func (w *watcher) streamSource(src ContainerSource) ContainerSource {
+	if ds, ok := src.(*dockerSource); ok {
+		return &dockerSource{client: w.client, podman: ds.podman}
+	}
+	return src
+}
+
+// run passes the events from src on to every subscriber until the stream
+// is stopped or fails. A container is dropped from the cache before its
+// event is passed on, so subscribers reinspecting it see its new state.
+// Each subscriber has its own buffer, so a busy one does not hold up the
+// others; one whose buffer is full is unsubscribed with
+// errSubscriberBehind, and its full sync on reconnect covers the events
+// it missed.
+func (w *watcher) run(ctx context.Context, stream *watchStream, src ContainerSource) {
+	evs, errs := src.Watch(ctx)
+	for {
+		select {
+		case ev := <-evs:
+			w.invalidate(ev.ID)
+			w.mu.Lock()
+			subs := make([]*watchSub, 0, len(w.subs))
+			for sub := range w.subs {
+				subs = append(subs, sub)
+			}
+			w.mu.Unlock()
+			for _, sub := range subs {
+				select {
+				case sub.events <- ev:
+				default:
+					log.Warningf("A plugin instance fell %d events behind the shared Docker event stream, resyncing it", watchSubBuffer)
+					w.unsubscribe(sub, errSubscriberBehind)
+				}
+			}
+		case err := <-errs:
+			w.stop(stream, err)
+			return
+		}
+	}
+}
+
+// stop ends stream, if it is still the running one, and hands err to
+// every subscriber so their event loops reconnect.
+func (w *watcher) stop(stream *watchStream, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stream != stream {
+		return
+	}
+	stream.cancel()
+	w.stream = nil
+	w.resetLocked()
+	for sub := range w.subs {
+		delete(w.subs, sub)
+		sub.errs <- err
+	}
+}
+
+// unsubscribe ends sub, stopping the event subscription once nobody is
+// watching.
+func (w *watcher) unsubscribe(sub *watchSub, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.subs[sub]; !ok {
+		return
+	}
+	delete(w.subs, sub)
+	sub.errs <- err
+	if len(w.subs) == 0 && w.stream != nil {
+		w.stream.cancel()
+		w.stream = nil
+		w.resetLocked()
+	}
+}
+
+// invalidate drops a container from the cache, and marks an inspect of
+// it in flight as stale so its result is not cached and later inspects
+// do not wait for it.
+func (w *watcher) invalidate(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.invalidateLocked(id)
+}
+
+func (w *watcher) invalidateLocked(id string) {
+	delete(w.containers, id)
+	if call, ok := w.inflight[id]; ok {
+		call.stale = true
+		delete(w.inflight, id)
+	}
+}
+
+// listed invalidates the containers whose fingerprint changed, or that
+// have none, and forgets the ones that are no longer listed.
+func (w *watcher) listed(refs []ContainerRef) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fingerprints := make(map[string]uint64, len(refs))
+	for _, ref := range refs {
+		fingerprints[ref.ID] = ref.Fingerprint
+		if prev, ok := w.fingerprints[ref.ID]; !ok || prev != ref.Fingerprint || ref.Fingerprint == 0 {
+			w.invalidateLocked(ref.ID)
+		}
+	}
+	for id := range w.containers {
+		if _, ok := fingerprints[id]; !ok {
+			delete(w.containers, id)
+		}
+	}
+	w.fingerprints = fingerprints
+}
+
+// resetLocked empties the cache when the event subscription starts or
+// stops: events missed while it was down may have changed any container.
+// The caller must hold w.mu.
+func (w *watcher) resetLocked() {
+	clear(w.containers)
+	clear(w.fingerprints)
+	for id, call := range w.inflight {
+		call.stale = true
+		delete(w.inflight, id)
+	}
+}
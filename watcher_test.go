@@ -0,0 +1,343 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingSource wraps fixtureSource, fingerprinting every container the
+// same way and counting inspects per container. Inspects block while
+// gate is set, until it is closed.
+type countingSource struct {
+	*fixtureSource
+	gate chan struct{}
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newCountingSource(containers ...Container) *countingSource {
+	return &countingSource{fixtureSource: newFixtureSource(containers...), calls: map[string]int{}}
+}
+
+func (c *countingSource) List(ctx context.Context) ([]ContainerRef, error) {
+	refs, err := c.fixtureSource.List(ctx)
+	for i := range refs {
+		refs[i].Fingerprint = 1
+	}
+	return refs, err
+}
+
+func (c *countingSource) Inspect(ctx context.Context, id string) (Container, error) {
+	c.mu.Lock()
+	c.calls[id]++
+	gate := c.gate
+	c.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	return c.fixtureSource.Inspect(ctx, id)
+}
+
+func (c *countingSource) callCount(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[id]
+}
+
+// newTestWatcher returns a watcher with no client.
+func newTestWatcher() *watcher {
+	return &watcher{
+		subs:         make(map[*watchSub]struct{}),
+		containers:   make(map[string]Container),
+		fingerprints: make(map[string]uint64),
+		inflight:     make(map[string]*watchedInspect),
+	}
+}
+
+// nextEvent returns the next event from evs, failing the test if none
+// arrives.
+func nextEvent(t *testing.T, evs <-chan ContainerEvent) ContainerEvent {
+	t.Helper()
+	select {
+	case ev := <-evs:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+	}
+	return ContainerEvent{}
+}
+
+func TestAcquireWatcherSharesEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	a := &Docker{endpoint: "unix://" + filepath.Join(dir, "a.sock")}
+	b := &Docker{endpoint: a.endpoint, zones: []string{"other."}}
+	c := &Docker{endpoint: "unix://" + filepath.Join(dir, "c.sock")}
+
+	wa, err := acquireWatcher(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wb, err := acquireWatcher(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc, err := acquireWatcher(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseWatcher(wc)
+	if wa != wb || wa.refs != 2 {
+		t.Errorf("expected instances with the same endpoint to share a watcher, got %p and %p", wa, wb)
+	}
+	if wc == wa {
+		t.Errorf("expected another endpoint to get its own watcher")
+	}
+
+	releaseWatcher(wa)
+	watchersMu.Lock()
+	_, ok := watchers[wa.key]
+	watchersMu.Unlock()
+	if !ok {
+		t.Errorf("expected the watcher to stay while an instance still uses it")
+	}
+	releaseWatcher(wb)
+	watchersMu.Lock()
+	_, ok = watchers[wa.key]
+	watchersMu.Unlock()
+	if ok {
+		t.Errorf("expected the watcher to be dropped once every instance released it")
+	}
+}
+
+func TestSharedSourceInspectCache(t *testing.T) {
+	ctx := context.Background()
+	src := newCountingSource(fixtureContainer("c1", "web", "172.17.0.2"))
+	w := newTestWatcher()
+	shared := &sharedSource{w: w, src: src}
+
+	// Without an event subscription nothing would invalidate the cache,
+	// so every inspect goes to the source.
+	shared.Inspect(ctx, "c1")
+	shared.Inspect(ctx, "c1")
+	if n := src.callCount("c1"); n != 2 {
+		t.Fatalf("expected 2 inspects before watching, got %d", n)
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	evs, _ := shared.Watch(watchCtx)
+	if _, err := shared.List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	shared.Inspect(ctx, "c1")
+	shared.Inspect(ctx, "c1")
+	if n := src.callCount("c1"); n != 3 {
+		t.Errorf("expected the second inspect while watching to be cached, got %d inspects", n)
+	}
+
+	// An event invalidates the container before subscribers see it.
+	src.set(fixtureContainer("c1", "web", "172.17.0.5"))
+	src.events <- ContainerEvent{ID: "c1"}
+	nextEvent(t, evs)
+	c, err := shared.Inspect(ctx, "c1")
+	if err != nil || c.Networks["bridge"].IPAddress != "172.17.0.5" {
+		t.Errorf("expected the event to refresh c1, got %+v, %v", c, err)
+	}
+	if n := src.callCount("c1"); n != 4 {
+		t.Errorf("expected the event to cost one inspect, got %d inspects", n)
+	}
+
+	// A container that is no longer listed is forgotten.
+	src.mu.Lock()
+	delete(src.containers, "c1")
+	src.mu.Unlock()
+	shared.List(ctx)
+	if _, err := shared.Inspect(ctx, "c1"); err == nil {
+		t.Errorf("expected an unlisted container not to be served from the cache")
+	}
+}
+
+func TestSharedSourceInspectInFlight(t *testing.T) {
+	ctx := context.Background()
+	src := newCountingSource(fixtureContainer("c1", "web", "172.17.0.2"))
+	src.gate = make(chan struct{})
+	shared := &sharedSource{w: newTestWatcher(), src: src}
+
+	var wg sync.WaitGroup
+	results := make([]Container, 3)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = shared.Inspect(ctx, "c1")
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for src.callCount("c1") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(src.gate)
+	wg.Wait()
+
+	if n := src.callCount("c1"); n != 1 {
+		t.Errorf("expected concurrent inspects to share one call, got %d", n)
+	}
+	for i, c := range results {
+		if c.Name != "web" {
+			t.Errorf("expected inspect %d to get c1, got %+v", i, c)
+		}
+	}
+}
+
+func TestSharedSourceWatchFanOut(t *testing.T) {
+	src := newCountingSource()
+	w := newTestWatcher()
+	shared := &sharedSource{w: w, src: src}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	evs1, errs1 := shared.Watch(ctx1)
+	evs2, errs2 := shared.Watch(ctx2)
+
+	// Subscribers are handed the event in no particular order, so both
+	// are read at once.
+	src.events <- ContainerEvent{ID: "c1"}
+	got := make(chan ContainerEvent, 1)
+	go func() { got <- <-evs2 }()
+	if ev := nextEvent(t, evs1); ev.ID != "c1" {
+		t.Errorf("expected the first subscriber to get c1, got %+v", ev)
+	}
+	if ev := nextEvent(t, got); ev.ID != "c1" {
+		t.Errorf("expected the second subscriber to get c1, got %+v", ev)
+	}
+
+	// One instance disconnecting leaves the stream to the other.
+	cancel1()
+	if err := <-errs1; err != context.Canceled {
+		t.Errorf("expected the cancelled subscription to end with context.Canceled, got %v", err)
+	}
+	src.events <- ContainerEvent{ID: "c2"}
+	if ev := nextEvent(t, evs2); ev.ID != "c2" {
+		t.Errorf("expected the remaining subscriber to get c2, got %+v", ev)
+	}
+
+	cancel2()
+	<-errs2
+	w.mu.Lock()
+	stream := w.stream
+	w.mu.Unlock()
+	if stream != nil {
+		t.Errorf("expected the event subscription to stop once nobody watches")
+	}
+}
+
+func TestSharedSourceWatchSlowSubscriber(t *testing.T) {
+	src := newCountingSource()
+	w := newTestWatcher()
+	shared := &sharedSource{w: w, src: src}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	slow, slowErrs := shared.Watch(ctx)
+	fast, _ := shared.Watch(ctx)
+
+	// The slow subscriber never reads; the fast one keeps getting events
+	// past the slow one's buffer, which is then dropped.
+	for i := range watchSubBuffer + 1 {
+		src.events <- ContainerEvent{ID: "c1"}
+		if ev := nextEvent(t, fast); ev.ID != "c1" {
+			t.Fatalf("expected event %d for the fast subscriber, got %+v", i, ev)
+		}
+	}
+	select {
+	case err := <-slowErrs:
+		if err != errSubscriberBehind {
+			t.Errorf("expected the slow subscriber to be dropped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the slow subscriber to be dropped")
+	}
+	if len(slow) != watchSubBuffer {
+		t.Errorf("expected the slow subscriber to keep its buffered events, got %d", len(slow))
+	}
+}
+
+func TestWatcherStreamSource(t *testing.T) {
+	w := newTestWatcher()
+	d := &Docker{}
+	d.podman.Store(true)
+	ds, ok := w.streamSource(d.newDockerSource(nil, nil)).(*dockerSource)
+	if !ok || ds.d != nil || !ds.podman {
+		t.Errorf("expected a source detached from the instance keeping the Podman flag, got %+v", ds)
+	}
+	src := newFixtureSource()
+	if w.streamSource(src) != src {
+		t.Errorf("expected other sources to be used as they are")
+	}
+}
+
+// failingSource is a source whose Watch fails with err.
+type failingSource struct {
+	*fixtureSource
+	err error
+}
+
+func (f *failingSource) Watch(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	errs := make(chan error, 1)
+	errs <- f.err
+	return nil, errs
+}
+
+func TestSharedSourceWatchFailure(t *testing.T) {
+	failure := errors.New("event stream closed")
+	shared := &sharedSource{w: newTestWatcher(), src: &failingSource{fixtureSource: newFixtureSource(), err: failure}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for range 2 {
+		_, errs := shared.Watch(ctx)
+		select {
+		case err := <-errs:
+			if err != failure {
+				t.Errorf("expected the stream's error, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the stream's failure to reach the subscriber")
+		}
+	}
+}
+
+func TestSharedWatcherInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := newCountingSource(fixtureContainer("c1", "web", "172.17.0.2"))
+	w := newTestWatcher()
+
+	a := newSyncTestDocker()
+	b := newSyncTestDocker()
+	b.zones = []string{"test."}
+	b.networks = []string{"bridge"}
+	shared := &sharedSource{w: w, src: src}
+	shared.Watch(ctx)
+
+	for _, d := range []*Docker{a, b} {
+		refs, err := shared.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.replaceRefs(ctx, shared, refs)
+	}
+	assertRecordIPs(t, a, "web.docker.", "172.17.0.2")
+	assertRecordIPs(t, b, "web.test.", "172.17.0.2")
+	assertRecordIPs(t, b, "web.docker.")
+	if n := src.callCount("c1"); n != 1 {
+		t.Errorf("expected both instances to be served by one inspect, got %d", n)
+	}
+}